@@ -0,0 +1,133 @@
+package dht
+
+import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p-kbucket/clock"
+)
+
+// stragglerFactor is how many times the median response time of a lookup a query may be outstanding
+// before it is considered a straggler, which no longer counts against the concurrency of the lookup.
+const stragglerFactor = 2
+
+// maxAlpha returns the upper bound of the concurrency of the lookup. In KadRTT mode, it is twice the
+// largest alpha of the buckets, which leaves room for as many stragglers as regular queries.
+func (q *query) maxAlpha() int {
+	if !q.dht.isKadRTT {
+		return q.dht.alpha
+	}
+	max := q.dht.alpha
+	for _, b := range q.dht.routingTable.BucketParams() {
+		if b.Alpha > max {
+			max = b.Alpha
+		}
+	}
+	return 2 * max
+}
+
+// outstandingQuery describes a query the lookup is waiting on.
+type outstandingQuery struct {
+	// sent is when the query was sent.
+	sent time.Time
+	// hedgeAfter is how long the query may go unanswered before it is hedged, 0 if it isn't.
+	hedgeAfter time.Duration
+}
+
+// stepAlpha returns the concurrency of the next step of the lookup, the outstanding queries to hedge in
+// the slots they free, and how long until it has to be re-evaluated if no response arrives in the meantime;
+// 0 means it doesn't.
+//
+// Outside of KadRTT mode, the concurrency is the alpha of the DHT. In KadRTT mode, the next hops come
+// from the buckets of the closest peers to the target, whose index is the CPL of these peers with the
+// target. Their alpha is estimated by the alpha of our own bucket with that CPL, but is never below the
+// alpha of the DHT. On top of that, the stragglers among the outstanding queries don't count, up to
+// maxAlpha. A query is a straggler once it exceeds its hedge delay, or twice the median response time of
+// the lookup if it has none.
+func (q *query) stepAlpha(now time.Time, maxAlpha int) (int, []peer.ID, time.Duration) {
+	if !q.dht.isKadRTT {
+		return q.dht.alpha, nil, 0
+	}
+
+	target := kb.ConvertKey(q.key)
+	cpl := kb.CommonPrefixLen(q.dht.selfKey, target)
+	if closest := q.queryPeers.GetClosestNInStates(1, qpeerset.PeerHeard, qpeerset.PeerWaiting, qpeerset.PeerQueried); len(closest) > 0 {
+		cpl = kb.CommonPrefixLen(kb.ConvertPeerID(closest[0]), target)
+	}
+	alpha := q.dht.routingTable.BucketParamsForCpl(cpl).Alpha
+	if alpha < q.dht.alpha {
+		alpha = q.dht.alpha
+	}
+	alpha = capAlpha(alpha, maxAlpha)
+
+	median, haveMedian := q.medianResponseTime()
+	var due []peer.ID
+	var next time.Duration
+	for p, o := range q.outstanding {
+		limit := o.hedgeAfter
+		if limit == 0 {
+			if !haveMedian {
+				continue
+			}
+			limit = stragglerFactor * median
+		}
+		if late := now.Sub(o.sent); late <= limit {
+			if wait := limit - late; next == 0 || wait < next {
+				next = wait
+			}
+		} else if alpha < maxAlpha {
+			alpha++
+			if o.hedgeAfter > 0 && !q.hedged[p] {
+				due = append(due, p)
+			}
+		}
+	}
+	// the query that will straggle next still counts if there is no room for another one
+	if alpha >= maxAlpha {
+		next = 0
+	}
+	return alpha, due, next
+}
+
+func capAlpha(alpha, maxAlpha int) int {
+	if alpha > maxAlpha {
+		return maxAlpha
+	}
+	return alpha
+}
+
+// medianResponseTime returns the median duration of the successful queries of the lookup so far.
+func (q *query) medianResponseTime() (time.Duration, bool) {
+	if len(q.peerTimes) == 0 {
+		return 0, false
+	}
+	times := make([]time.Duration, 0, len(q.peerTimes))
+	for _, d := range q.peerTimes {
+		times = append(times, d)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2], true
+}
+
+// resetTimer stops the timer, drains it if it fired, and restarts it to fire after d, unless d is 0.
+func resetTimer(t *clock.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	if d > 0 {
+		t.Reset(d)
+	}
+}
+
+// doneWaiting forgets the outstanding query to the peer.
+func (q *query) doneWaiting(p peer.ID) {
+	delete(q.outstanding, p)
+	delete(q.hedged, p)
+}
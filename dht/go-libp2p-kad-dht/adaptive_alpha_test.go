@@ -0,0 +1,78 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestQuery(t *testing.T, d *IpfsDHT) *query {
+	key := string(test.RandPeerIDFatal(t))
+	return &query{
+		key:         key,
+		dht:         d,
+		queryPeers:  qpeerset.NewQueryPeerset(key),
+		peerTimes:   make(map[peer.ID]time.Duration),
+		outstanding: make(map[peer.ID]outstandingQuery),
+		hedged:      make(map[peer.ID]bool),
+		hedges:      make(map[peer.ID]peer.ID),
+	}
+}
+
+func TestStepAlpha(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// without KadRTT, the concurrency is fixed
+	plain := setupDHT(ctx, t, false)
+	defer plain.Close()
+	q := newTestQuery(t, plain)
+	require.Equal(t, plain.alpha, q.maxAlpha())
+	alpha, due, wake := q.stepAlpha(time.Now(), q.maxAlpha())
+	require.Equal(t, plain.alpha, alpha)
+	require.Empty(t, due)
+	require.Zero(t, wake)
+
+	d := setupDHT(ctx, t, false, IsKadRTT(true))
+	defer d.Close()
+	q = newTestQuery(t, d)
+	maxAlpha := q.maxAlpha()
+	require.GreaterOrEqual(t, maxAlpha, 2*d.alpha)
+
+	// the concurrency follows the bucket of the closest peer
+	for i := 0; i < 4; i++ {
+		q.queryPeers.TryAdd(test.RandPeerIDFatal(t), d.self)
+	}
+	closest := q.queryPeers.GetClosestNInStates(2, qpeerset.PeerHeard)
+	cpl := kb.CommonPrefixLen(kb.ConvertPeerID(closest[0]), kb.ConvertKey(q.key))
+	base := d.routingTable.BucketParamsForCpl(cpl).Alpha
+	if base < d.alpha {
+		base = d.alpha
+	}
+	now := time.Now()
+	alpha, _, wake = q.stepAlpha(now, maxAlpha)
+	require.Equal(t, base, alpha)
+	require.Zero(t, wake)
+
+	// a query outstanding for more than twice the median response time is a straggler, which doesn't count
+	q.peerTimes[test.RandPeerIDFatal(t)] = 10 * time.Millisecond
+	q.outstanding[closest[0]] = outstandingQuery{sent: now.Add(-100 * time.Millisecond)}
+	q.outstanding[closest[1]] = outstandingQuery{sent: now.Add(-5 * time.Millisecond)}
+	alpha, due, wake = q.stepAlpha(now, maxAlpha)
+	require.Equal(t, base+1, alpha)
+	require.Empty(t, due)
+	require.Equal(t, 15*time.Millisecond, wake)
+
+	// but the concurrency never exceeds the maximum
+	alpha, _, wake = q.stepAlpha(now, base)
+	require.Equal(t, base, alpha)
+	require.Zero(t, wake)
+}
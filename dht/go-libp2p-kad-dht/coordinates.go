@@ -0,0 +1,184 @@
+package dht
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+	"github.com/libp2p/go-libp2p-kbucket/vivaldi"
+)
+
+const (
+	protoCoordinates = "/kadrtt/vivaldi/1.0.0"
+
+	// coordGossipSize is the number of peer coordinates sent along with our own in an exchange.
+	coordGossipSize = 8
+
+	// coordExchangeTimeout bounds a whole coordinate exchange.
+	coordExchangeTimeout = 10 * time.Second
+
+	// coordMaxMessageSize bounds the size of a coordinate message we read, which is a few KiB
+	// with coordGossipSize peers.
+	coordMaxMessageSize = 64 << 10
+)
+
+// coordMessage is sent both ways in a coordinate exchange: the requester sends its own coordinate,
+// and the responder answers with its own and some of the peer coordinates it knows.
+type coordMessage struct {
+	Coordinate *vivaldi.Coordinate      `json:"coordinate"`
+	Peers      []vivaldi.PeerCoordinate `json:"peers,omitempty"`
+}
+
+// handleCoordinates answers a coordinate exchange. The coordinate of the requester is recorded,
+// but it doesn't move ours since we have no RTT sample to go with it.
+func (dht *IpfsDHT) handleCoordinates(s network.Stream) {
+	defer s.Close()
+	_ = s.SetDeadline(time.Now().Add(coordExchangeTimeout))
+
+	remote := s.Conn().RemotePeer()
+	var req coordMessage
+	if err := json.NewDecoder(io.LimitReader(s, coordMaxMessageSize)).Decode(&req); err != nil {
+		logger.Debugw("failed to read coordinate exchange", "peer", remote, "error", err)
+		_ = s.Reset()
+		return
+	}
+	if err := dht.coords.SetPeerCoordinate(remote, req.Coordinate, time.Now()); err != nil {
+		logger.Debugw("invalid coordinate", "peer", remote, "error", err)
+	}
+
+	resp := coordMessage{Coordinate: dht.coords.Coordinate()}
+	for _, pc := range dht.coords.Peers(coordGossipSize + 1) {
+		if pc.Peer != remote && len(resp.Peers) < coordGossipSize {
+			resp.Peers = append(resp.Peers, pc)
+		}
+	}
+	if err := json.NewEncoder(s).Encode(&resp); err != nil {
+		logger.Debugw("failed to write coordinate exchange", "peer", remote, "error", err)
+		_ = s.Reset()
+	}
+}
+
+// exchangeCoordinates swaps coordinates with the peer and moves our coordinate with the RTT of the
+// exchange, which it returns. The peer coordinates gossiped by the peer are recorded too.
+func (dht *IpfsDHT) exchangeCoordinates(ctx context.Context, p peer.ID) (time.Duration, error) {
+	if dht.coords == nil {
+		return 0, fmt.Errorf("network coordinates are not enabled")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, coordExchangeTimeout)
+	defer cancel()
+	s, err := dht.host.NewStream(ctx, p, protoCoordinates)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.SetDeadline(deadline)
+	}
+
+	// the RTT is the time between sending our coordinate and reading the reply
+	start := time.Now()
+	if err := json.NewEncoder(s).Encode(&coordMessage{Coordinate: dht.coords.Coordinate()}); err != nil {
+		_ = s.Reset()
+		return 0, err
+	}
+	var resp coordMessage
+	if err := json.NewDecoder(io.LimitReader(s, coordMaxMessageSize)).Decode(&resp); err != nil {
+		_ = s.Reset()
+		return 0, err
+	}
+	rtt := time.Since(start)
+	if len(resp.Peers) > coordGossipSize {
+		resp.Peers = resp.Peers[:coordGossipSize]
+	}
+
+	if _, err := dht.coords.Update(p, resp.Coordinate, rtt); err != nil {
+		return 0, err
+	}
+	for _, pc := range resp.Peers {
+		if pc.Peer == dht.self {
+			continue
+		}
+		if err := dht.coords.SetPeerCoordinate(pc.Peer, pc.Coordinate, pc.UpdatedAt); err != nil {
+			logger.Debugw("invalid gossiped coordinate", "from", p, "peer", pc.Peer, "error", err)
+		}
+	}
+	return rtt, nil
+}
+
+// observeRTT moves our coordinate with an RTT sample measured by other means than a coordinate
+// exchange, provided the coordinate of the peer is known.
+func (dht *IpfsDHT) observeRTT(p peer.ID, rtt time.Duration) {
+	if dht.coords == nil || rtt <= 0 {
+		return
+	}
+	if coord, ok := dht.coords.PeerCoordinate(p); ok {
+		_, _ = dht.coords.Update(p, coord, rtt)
+	}
+}
+
+// VivaldiRTT measures the RTT with a coordinate exchange, which also keeps the network coordinates
+// of the DHT up to date. It requires KadRTT_NetworkCoordinates.
+type VivaldiRTT struct{}
+
+var _ RTTProvider = VivaldiRTT{}
+
+// RTT implements RTTProvider.
+func (VivaldiRTT) RTT(ctx context.Context, dht *IpfsDHT, p peer.ID) (time.Duration, error) {
+	return dht.exchangeCoordinates(ctx, p)
+}
+
+// CoordinateRTT estimates the RTT from the network coordinate of the peer, without contacting it.
+// It requires KadRTT_NetworkCoordinates.
+type CoordinateRTT struct{}
+
+var _ RTTProvider = CoordinateRTT{}
+
+// RTT implements RTTProvider.
+func (CoordinateRTT) RTT(_ context.Context, dht *IpfsDHT, p peer.ID) (time.Duration, error) {
+	if dht.coords == nil {
+		return 0, ErrRTTUnknown
+	}
+	rtt, ok := dht.coords.EstimateRTT(p)
+	if !ok {
+		return 0, ErrRTTUnknown
+	}
+	return rtt, nil
+}
+
+// NetworkCoordinates returns the Vivaldi client of the DHT, or nil if network coordinates aren't enabled.
+func (dht *IpfsDHT) NetworkCoordinates() *vivaldi.Client {
+	return dht.coords
+}
+
+// closestHeardByRTT returns up to n of the beta closest heard peers, by increasing estimated RTT.
+// Peers whose RTT can't be estimated come last, and ties are broken by distance to the target.
+func (q *query) closestHeardByRTT(beta, n int) []peer.ID {
+	peers := q.queryPeers.GetClosestNInStates(beta, qpeerset.PeerHeard)
+	rtts := make(map[peer.ID]time.Duration, len(peers))
+	for _, p := range peers {
+		if rtt, ok := q.dht.routingTable.EstimateRTT(p); ok {
+			rtts[p] = rtt
+		}
+	}
+	// peers are sorted by distance, which the stable sort keeps for equal RTTs
+	sort.SliceStable(peers, func(i, j int) bool {
+		ri, iok := rtts[peers[i]]
+		rj, jok := rtts[peers[j]]
+		if iok != jok {
+			return iok
+		}
+		return ri < rj
+	})
+	if len(peers) > n {
+		peers = peers[:n]
+	}
+	return peers
+}
@@ -0,0 +1,144 @@
+package dht
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+	"github.com/libp2p/go-libp2p-kbucket/vivaldi"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoordinateExchange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dhtA := setupDHT(ctx, t, false, IsKadRTT(true), KadRTT_NetworkCoordinates(true))
+	defer dhtA.Close()
+	dhtB := setupDHT(ctx, t, false, IsKadRTT(true), KadRTT_NetworkCoordinates(true))
+	defer dhtB.Close()
+	require.IsType(t, VivaldiRTT{}, dhtA.rttProvider)
+	require.Equal(t, dhtA.NetworkCoordinates(), dhtA.routingTable.Coordinates())
+
+	// B relays the coordinate of a peer A has never heard of
+	far := test.RandPeerIDFatal(t)
+	farCoord := vivaldi.NewCoordinate(dhtB.coords.Config())
+	farCoord.Vec[0] = 0.1
+	require.NoError(t, dhtB.coords.SetPeerCoordinate(far, farCoord, time.Now()))
+	_, err := CoordinateRTT{}.RTT(ctx, dhtA, far)
+	require.Equal(t, ErrRTTUnknown, err)
+
+	connect(t, ctx, dhtA, dhtB)
+	rtt, err := VivaldiRTT{}.RTT(ctx, dhtA, dhtB.self)
+	require.NoError(t, err)
+	require.NotZero(t, rtt)
+
+	// both sides know each other's coordinate, and A learned the relayed one
+	_, ok := dhtA.coords.PeerCoordinate(dhtB.self)
+	require.True(t, ok)
+	_, ok = dhtB.coords.PeerCoordinate(dhtA.self)
+	require.True(t, ok)
+	_, ok = dhtA.coords.PeerCoordinate(dhtA.self)
+	require.False(t, ok)
+	rtt, err = CoordinateRTT{}.RTT(ctx, dhtA, far)
+	require.NoError(t, err)
+	require.Equal(t, dhtA.coords.Coordinate().DistanceTo(farCoord), rtt)
+	rtt, ok = dhtA.routingTable.EstimateRTT(far)
+	require.True(t, ok)
+	require.NotZero(t, rtt)
+
+	// without coordinates, the providers have nothing to work with
+	plain := setupDHT(ctx, t, false, IsKadRTT(true))
+	defer plain.Close()
+	require.Nil(t, plain.NetworkCoordinates())
+	_, err = VivaldiRTT{}.RTT(ctx, plain, dhtB.self)
+	require.Error(t, err)
+	_, err = CoordinateRTT{}.RTT(ctx, plain, dhtB.self)
+	require.Equal(t, ErrRTTUnknown, err)
+
+	// an explicit provider isn't overridden
+	d := setupDHT(ctx, t, false, IsKadRTT(true), KadRTT_NetworkCoordinates(true), KadRTT_RTTProvider(PeerstoreRTT{}))
+	defer d.Close()
+	require.IsType(t, PeerstoreRTT{}, d.rttProvider)
+}
+
+func TestCoordinateGossipIsBounded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dhtA := setupDHT(ctx, t, false, IsKadRTT(true), KadRTT_NetworkCoordinates(true))
+	defer dhtA.Close()
+	dhtB := setupDHT(ctx, t, false, IsKadRTT(true), KadRTT_NetworkCoordinates(true))
+	defer dhtB.Close()
+
+	// B gossips far more peers than it should, all dated in the future
+	future := time.Now().Add(time.Hour)
+	var exchanges int32
+	dhtB.host.SetStreamHandler(protoCoordinates, func(s network.Stream) {
+		defer s.Close()
+		atomic.AddInt32(&exchanges, 1)
+		var req coordMessage
+		if err := json.NewDecoder(s).Decode(&req); err != nil {
+			_ = s.Reset()
+			return
+		}
+		resp := coordMessage{Coordinate: dhtB.coords.Coordinate()}
+		for i := 0; i < 4*coordGossipSize; i++ {
+			resp.Peers = append(resp.Peers, vivaldi.PeerCoordinate{
+				Peer:       test.RandPeerIDFatal(t),
+				Coordinate: vivaldi.NewCoordinate(dhtB.coords.Config()),
+				UpdatedAt:  future,
+			})
+		}
+		_ = json.NewEncoder(s).Encode(&resp)
+	})
+
+	connect(t, ctx, dhtA, dhtB)
+	_, err := VivaldiRTT{}.RTT(ctx, dhtA, dhtB.self)
+	require.NoError(t, err)
+
+	// adding B to the routing table may have exchanged coordinates too: every exchange records B
+	// and no more than coordGossipSize of the relayed peers, dated no later than now
+	pcs := dhtA.coords.Peers(-1)
+	require.LessOrEqual(t, len(pcs), int(atomic.LoadInt32(&exchanges))*coordGossipSize+1)
+	for _, pc := range pcs {
+		require.False(t, pc.UpdatedAt.After(time.Now()))
+	}
+}
+
+func TestQueryOrdersByEstimatedRTT(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := setupDHT(ctx, t, false, IsKadRTT(true), KadRTT_NetworkCoordinates(true))
+	defer d.Close()
+
+	key := string(test.RandPeerIDFatal(t))
+	q := &query{key: key, dht: d, queryPeers: qpeerset.NewQueryPeerset(key)}
+	for i := 0; i < 4; i++ {
+		q.queryPeers.TryAdd(test.RandPeerIDFatal(t), d.self)
+	}
+	closest := q.queryPeers.GetClosestNInStates(4, qpeerset.PeerHeard)
+
+	// the third closest is the fastest, the closest is slow and the others are unknown
+	at := func(x float64) *vivaldi.Coordinate {
+		c := vivaldi.NewCoordinate(d.coords.Config())
+		c.Vec[0] = x
+		return c
+	}
+	require.NoError(t, d.coords.SetPeerCoordinate(closest[2], at(0.001), time.Now()))
+	require.NoError(t, d.coords.SetPeerCoordinate(closest[0], at(0.2), time.Now()))
+
+	require.Equal(t, []peer.ID{closest[2], closest[0], closest[1], closest[3]}, q.closestHeardByRTT(4, 4))
+	require.Equal(t, []peer.ID{closest[2], closest[0]}, q.closestHeardByRTT(4, 2))
+	// only the beta closest are candidates
+	require.Equal(t, []peer.ID{closest[0], closest[1]}, q.closestHeardByRTT(2, 2))
+}
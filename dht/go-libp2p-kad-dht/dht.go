@@ -21,9 +21,12 @@ import (
 	"github.com/libp2p/go-libp2p-kad-dht/metrics"
 	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
 	"github.com/libp2p/go-libp2p-kad-dht/providers"
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
 	"github.com/libp2p/go-libp2p-kad-dht/rtrefresh"
 	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p-kbucket/clock"
 	"github.com/libp2p/go-libp2p-kbucket/peerdiversity"
+	"github.com/libp2p/go-libp2p-kbucket/vivaldi"
 	record "github.com/libp2p/go-libp2p-record"
 	recpb "github.com/libp2p/go-libp2p-record/pb"
 	//kb "github.com/ncl-teu/go-libp2p-kadrtt-kbucket"
@@ -93,6 +96,29 @@ type IpfsDHT struct {
 
 	rttMap map[peer.ID]time.Duration
 
+	// source of the RTTs of the peers added to a KadRTT routing table
+	rttProvider RTTProvider
+
+	// network coordinates, nil unless enabled with KadRTT_NetworkCoordinates
+	coords *vivaldi.Client
+
+	// orders the peers the lookups query next, nil orders them by distance
+	nextHopScore qpeerset.ScoreFunc
+
+	// records the path of every lookup, see LookupTracing
+	lookupTracing bool
+
+	// number of disjoint paths of every lookup, see DisjointPaths
+	disjointPaths int
+
+	// per-peer lookup query deadlines and hedging, see KadRTT_PeerTimeouts and KadRTT_Hedging
+	queryRTTs                      float64
+	peerTimeoutMin, peerTimeoutMax time.Duration
+	hedging                        bool
+
+	// times the lookup steps and the hedges, see Clock
+	clock clock.Clock
+
 	// ProviderManager stores & manages the provider recorroutingTableds for this Dht peer.
 	ProviderManager *providers.ProviderManager
 
@@ -201,9 +227,28 @@ func New(ctx context.Context, h host.Host, options ...Option) (*IpfsDHT, error)
 	dht.Validator = cfg.validator
 
 	dht.rttMap =  make(map[peer.ID]time.Duration)
+	dht.rttProvider = cfg.rttProvider
+	if dht.coords != nil && !cfg.rttProviderSet {
+		dht.rttProvider = VivaldiRTT{}
+	}
+	dht.nextHopScore = dht.nextHopScoreFunc(cfg.kadrtt_next_hop, cfg.kadrtt_rtt_penalty)
+	dht.queryRTTs = cfg.kadrtt_query_rtts
+	dht.peerTimeoutMin, dht.peerTimeoutMax = cfg.kadrtt_peer_timeout_min, cfg.kadrtt_peer_timeout_max
+	dht.hedging = cfg.kadrtt_hedging
+	dht.clock = cfg.clock
+	dht.lookupTracing = cfg.lookupTracing
+	dht.disjointPaths = cfg.disjointPaths
 
 	dht.testAddressUpdateProcessing = cfg.testAddressUpdateProcessing
 
+	dht.forwardRoutingTableEvents(ctx)
+
+	if cfg.routingTable.persist {
+		if err := dht.restoreRoutingTable(); err != nil {
+			logger.Warnw("failed to restore the routing table", "error", err)
+		}
+	}
+
 	dht.auto = cfg.mode
 	switch cfg.mode {
 	case ModeAuto, ModeClient:
@@ -234,6 +279,7 @@ func New(ctx context.Context, h host.Host, options ...Option) (*IpfsDHT, error)
 	go dht.persistRTPeersInPeerStore()
 
 	dht.proc.Go(dht.rtPeerLoop)
+	dht.proc.Go(dht.rtMetricsLoop)
 
 	// Fill routing table with currently connected peers that are DHT servers
 	dht.plk.Lock()
@@ -323,6 +369,15 @@ func makeDHT(ctx context.Context, h host.Host, cfg config) (*IpfsDHT, error) {
 		//ping-pong登録
 		RegisterPingPong(h)
 
+		if cfg.kadrtt_coordinates {
+			coords, err := vivaldi.NewClient(vivaldi.DefaultConfig())
+			if err != nil {
+				return nil, fmt.Errorf("failed to construct network coordinates, err=%s", err)
+			}
+			dht.coords = coords
+			h.SetStreamHandler(protoCoordinates, dht.handleCoordinates)
+		}
+
 	} else {
 		if cfg.concurrency < cfg.bucketSize { // (alpha < K)
 			l1 := math.Log(float64(1) / float64(cfg.bucketSize))                              //(Log(1/K))
@@ -365,6 +420,11 @@ func makeDHT(ctx context.Context, h host.Host, cfg config) (*IpfsDHT, error) {
 
 	// create a DHT proc with the given context
 	dht.proc = goprocessctx.WithContextAndTeardown(ctx, func() error {
+		if cfg.routingTable.persist {
+			if err := dht.saveRoutingTable(); err != nil {
+				logger.Warnw("failed to save the routing table", "error", err)
+			}
+		}
 		return rtRefresh.Close()
 	})
 
@@ -373,7 +433,9 @@ func makeDHT(ctx context.Context, h host.Host, cfg config) (*IpfsDHT, error) {
 	// the DHT context should be done when the process is closed
 	dht.ctx = goprocessctx.WithProcessClosing(ctxTags, dht.proc)
 
-	pm, err := providers.NewProviderManager(dht.ctx, h.ID(), cfg.datastore, cfg.providersOptions...)
+	// the options passed by the user come last, so that they win
+	pmOpts := append([]providers.Option{providers.Clock(cfg.clock)}, cfg.providersOptions...)
+	pm, err := providers.NewProviderManager(dht.ctx, h.ID(), cfg.datastore, pmOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -402,7 +464,8 @@ func makeRtRefreshManager(dht *IpfsDHT, cfg config, maxLastSuccessfulOutboundThr
 		cfg.routingTable.refreshQueryTimeout,
 		cfg.routingTable.refreshInterval,
 		maxLastSuccessfulOutboundThreshold,
-		dht.refreshFinishedCh)
+		dht.refreshFinishedCh,
+		rtrefresh.Clock(cfg.clock))
 
 	return r, err
 }
@@ -467,12 +530,30 @@ func makeRoutingTable(dht *IpfsDHT, cfg config, maxLastSuccessfulOutboundThresho
 		filter = df
 	}
 
-	rt, err := kb.NewRoutingTable(cfg.bucketSize, dht.selfKey, time.Minute, dht.host.Peerstore(), maxLastSuccessfulOutboundThreshold, filter)
-	if err != nil {
-		return nil, err
+	rtOpts := []kb.Option{kb.KadRTT(cfg.isKadRTT), kb.Clock(cfg.clock)}
+	if cfg.isKadRTT {
+		rtOpts = append(rtOpts,
+			kb.RTTInterval(cfg.GetRTTInterval()),
+			kb.InitialStoreRate(cfg.kadrtt_store_rate),
+			kb.InitialExchangeProbability(cfg.kadrtt_prob_exchange),
+			kb.BucketKRange(cfg.kadrtt_min_k, cfg.kadrtt_max_k),
+			kb.MaxEntries(cfg.kadrtt_max_entries),
+		)
+		if cfg.kadrtt_pool_size > 0 {
+			rtOpts = append(rtOpts, kb.PoolSize(cfg.kadrtt_pool_size))
+		}
+		if dht.coords != nil {
+			rtOpts = append(rtOpts, kb.NetworkCoordinates(dht.coords))
+		}
+		if filter != nil && cfg.kadrtt_diversity_weight > 0 {
+			filter.SetSoft(true)
+			rtOpts = append(rtOpts, kb.DiversityWeight(cfg.kadrtt_diversity_weight))
+		}
 	}
-	if dht.isKadRTT {
 
+	rt, err := kb.NewRoutingTable(cfg.bucketSize, dht.selfKey, time.Minute, dht.host.Peerstore(), maxLastSuccessfulOutboundThreshold, filter, rtOpts...)
+	if err != nil {
+		return nil, err
 	}
 
 	cmgr := dht.host.ConnManager()
@@ -710,9 +791,16 @@ func (dht *IpfsDHT) putLocal(key string, rec *recpb.Record) error {
 
 func (dht *IpfsDHT) processAdd(addReq addPeerRTReq, isBootsrapping bool){
 
-	dur := dht.pingProcess(dht.ctx, addReq.p)
+	dur, err := dht.rttProvider.RTT(dht.ctx, dht, addReq.p)
+	if err != nil {
+		// fall back on the peerstore latency, so that the peer isn't turned away from a full bucket
+		// for want of a measurement.
+		logger.Debugw("no rtt for peer", "peer", addReq.p, "error", err)
+		dur = dht.peerstore.LatencyEWMA(addReq.p)
+	} else if _, ok := dht.rttProvider.(VivaldiRTT); !ok {
+		dht.observeRTT(addReq.p, dur)
+	}
 	var newlyAdded bool
-	var err error
 	newlyAdded, err = dht.routingTable.TryAddPeerKadRTT(addReq.p, addReq.queryPeer, isBootsrapping, dur)
 	if err != nil {
 		// peer not added.
@@ -831,6 +919,7 @@ func (dht *IpfsDHT) peerStoppedDHT(ctx context.Context, p peer.ID) {
 	logger.Debugw("peer stopped dht", "peer", p)
 	// A peer that does not support the DHT protocol is dead for us.
 	// There's no point in talking to anymore till it starts supporting the DHT protocol again.
+	// If the routing table keeps a replacement cache, the best peer it rejected for lack of room takes its place.
 	dht.routingTable.RemovePeer(p)
 }
 
@@ -868,6 +957,17 @@ func (dht *IpfsDHT) nearestPeersToQuery(pmes *pb.Message, count int) []peer.ID {
 	return closer
 }
 
+// lookupSeeds returns the peers of the routing table a lookup for the key starts from: the K closest
+// ones. In KadRTT mode, the lookup starts from the beta closest ones instead, ranked by RTT.
+// Only lookups rank by RTT; the peers sent to remote peers are always the XOR closest.
+func (dht *IpfsDHT) lookupSeeds(key kb.ID) []peer.ID {
+	if !dht.isKadRTT {
+		return dht.routingTable.NearestPeers(key, dht.bucketSize)
+	}
+	beta := dht.routingTable.BucketParamsForCpl(kb.CommonPrefixLen(dht.selfKey, key)).Beta
+	return dht.routingTable.NearestPeersByPolicy(key, beta, kb.KadRTTRanking{})
+}
+
 // betterPeersToQuery returns nearestPeersToQuery with some additional filtering
 func (dht *IpfsDHT) betterPeersToQuery(pmes *pb.Message, from peer.ID, count int) []peer.ID {
 	closer := dht.nearestPeersToQuery(pmes, count)
@@ -1024,43 +1124,15 @@ func (dht *IpfsDHT) Ping(ctx context.Context, p peer.ID) error {
 
 	return nil
 }
-func (dht *IpfsDHT) pingProcess(ctx context.Context, p peer.ID) time.Duration{
-	start := time.Now()
-	playPingPong(dht.host, p)
-	dur := time.Since(start)
-
-	return dur
-}
-// Ping sends a ping message to the passed peer and waits for a response.
+// KadRTTPing sends a ping message to the passed peer and returns the time it took to answer,
+// or 0 if it didn't.
 func (dht *IpfsDHT) KadRTTPing(ctx context.Context, p peer.ID) time.Duration {
-
-	req := pb.NewMessage(pb.Message_PING, nil, 0)
-	//Added by Kanemitsu
-	start := time.Now()
-
-	//resp, err := dht.sendRequest(ctx, p, req)
-	dht.sendRequest(ctx, p, req)
-	/*if err != nil {
-		//return fmt.Errorf("sending request: %w", err)
+	dur, err := DHTPingRTT{}.RTT(ctx, dht, p)
+	if err != nil {
+		logger.Debugw("failed to ping peer", "peer", p, "error", err)
+		return 0
 	}
-	if resp.Type != pb.Message_PING {
-		//return fmt.Errorf("got unexpected response type: %v", resp.Type)
-	}*/
-	//dht.handlePing(ctx, p, req)
-	//dht.handlePing()
-
-	//dht.routingTable.metrics.LatencyEWMA(p)
-	//Added by Kanemitsu
-	//if(dht.isKadRTT){
-	dur := time.Since(start)
-
-
-	//dht.routingTable.SetRTT(p, dur)
 	return dur
-
-	//}
-
-	//return -1
 }
 
 // newContextWithLocalTags returns a new context.Context with the InstanceID and
@@ -12,6 +12,8 @@ import (
 	"github.com/libp2p/go-libp2p-core/protocol"
 	"github.com/libp2p/go-libp2p-kad-dht/providers"
 
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p-kbucket/clock"
 	"github.com/libp2p/go-libp2p-kbucket/peerdiversity"
 	record "github.com/libp2p/go-libp2p-record"
 
@@ -53,11 +55,30 @@ type config struct {
 	enableValues       bool
 	providersOptions []providers.Option
 	queryPeerFilter  QueryFilterFunc
+	clock              clock.Clock
+	lookupTracing      bool
+	disjointPaths      int
 
 	//Added by Kanemitsu
 	isKadRTT			bool
 	kadrtt_interval		int
 	kadrtt_ex_interval time.Duration
+	kadrtt_store_rate	float64
+	kadrtt_prob_exchange	float64
+	kadrtt_pool_size	int
+	rttProvider	RTTProvider
+	rttProviderSet	bool
+	kadrtt_coordinates	bool
+	kadrtt_diversity_weight	float64
+	kadrtt_max_entries	int
+	kadrtt_min_k	int
+	kadrtt_max_k	int
+	kadrtt_next_hop	NextHopScoring
+	kadrtt_rtt_penalty	time.Duration
+	kadrtt_query_rtts	float64
+	kadrtt_peer_timeout_min	time.Duration
+	kadrtt_peer_timeout_max	time.Duration
+	kadrtt_hedging	bool
 
 	routingTable struct {
 		refreshQueryTimeout time.Duration
@@ -67,6 +88,7 @@ type config struct {
 		checkInterval   time.Duration
 		peerFilter      RouteTableFilterFunc
 		diversityFilter peerdiversity.PeerIPGroupFilter
+		persist         bool
 	}
 
 	bootstrapPeers []peer.AddrInfo
@@ -134,10 +156,21 @@ var defaults = func(o *config) error {
 	o.bucketSize = defaultBucketSize
 	o.concurrency = 10
 	o.resiliency = 3
+	o.disjointPaths = 1
 	//Added by Kanemitsu
 	o.isKadRTT = false
 	o.kadrtt_interval = 180
 	o.kadrtt_ex_interval = time.Duration(o.kadrtt_interval) * time.Second
+	o.kadrtt_store_rate = 0.5
+	o.kadrtt_prob_exchange = 0.5
+	// 0 means the pool size follows the bucket size
+	o.kadrtt_pool_size = 0
+	o.kadrtt_min_k = kb.DefaultMinBucketK
+	o.kadrtt_next_hop = NextHopXOR
+	o.kadrtt_rtt_penalty = DefaultRTTPenalty
+	o.kadrtt_query_rtts = DefaultQueryRTTs
+	o.rttProvider = PingPongRTT{}
+	o.clock = clock.New()
 
 	return nil
 }
@@ -210,6 +243,18 @@ func RoutingTableRefreshPeriod(period time.Duration) Option {
 	}
 }
 
+// RoutingTablePersistence configures the DHT to save the routing table, with the RTT statistics and
+// the KadRTT parameters it learned, to the datastore when it is closed and to restore it when it starts.
+// This lets KadRTT nodes warm-start with a tuned table; it only makes sense with a persistent Datastore.
+//
+// Defaults to false.
+func RoutingTablePersistence(enable bool) Option {
+	return func(c *config) error {
+		c.routingTable.persist = enable
+		return nil
+	}
+}
+
 // Datastore configures the DHT to use the specified datastore.
 //
 // Defaults to an in-memory (temporary) map.
@@ -329,6 +374,22 @@ func Resiliency(beta int) Option {
 	}
 }
 
+// DisjointPaths configures the number of disjoint paths (d in the S/Kademlia paper) a lookup takes. The seed
+// peers are dealt between the paths, which run concurrently and never query the same peer, so that a lookup
+// still reaches the closest peers to the target as long as one of its paths avoids adversarial peers. The
+// result merges the closest peers of every path.
+//
+// The default value is 1.
+func DisjointPaths(d int) Option {
+	return func(c *config) error {
+		if d < 1 {
+			return fmt.Errorf("disjoint paths must be at least 1, got %d", d)
+		}
+		c.disjointPaths = d
+		return nil
+	}
+}
+
 // MaxRecordAge specifies the maximum time that any node will hold onto a record ("PutValue record")
 // from the time its received. This does not apply to any other forms of validity that
 // the record may contain.
@@ -342,6 +403,18 @@ func MaxRecordAge(maxAge time.Duration) Option {
 	}
 }
 
+// LookupTracing makes every lookup record its path: the peers it queried, who referred them, their RTT,
+// how long their query took and their depth, and why the lookup ended. The trace is published as a
+// LookupEvent once the last query of the lookup returned. GetClosestPeersWithTrace traces its lookup anyway.
+//
+// Defaults to false.
+func LookupTracing(enable bool) Option {
+	return func(c *config) error {
+		c.lookupTracing = enable
+		return nil
+	}
+}
+
 // DisableAutoRefresh completely disables 'auto-refresh' on the DHT routing
 // table. This means that we will neither refresh the routing table periodically
 // nor when the routing table size goes below the minimum threshold.
@@ -391,6 +464,23 @@ func ProvidersOptions(opts []providers.Option) Option {
 	}
 }
 
+// Clock sets the clock the routing table, the routing table refresh manager and the provider manager
+// tell the time with, so that their interval-driven behaviour can be tested with a clock.Mock. Lookups
+// time their stragglers and hedges with it too, while the RTTs of their queries are always measured
+// with the wall clock.
+// A clock passed to the provider manager with ProvidersOptions takes precedence.
+//
+// The default value is the wall clock.
+func Clock(clk clock.Clock) Option {
+	return func(c *config) error {
+		if clk == nil {
+			return fmt.Errorf("clock must not be nil")
+		}
+		c.clock = clk
+		return nil
+	}
+}
+
 // QueryFilter sets a function that approves which peers may be dialed in a query
 func QueryFilter(filter QueryFilterFunc) Option {
 	return func(c *config) error {
@@ -459,7 +549,69 @@ func IsKadRTT(flg bool) Option {
 func KadRTT_Interval(value int) Option {
 	return func(c *config) error {
 		c.kadrtt_interval = value
-		c.kadrtt_ex_interval = c.kadrtt_ex_interval * time.Second
+		c.kadrtt_ex_interval = time.Duration(value) * time.Second
+		return nil
+	}
+}
+
+// KadRTT_StoreRate configures the STORE arrival rate the KadRTT routing table
+// assumes before it has measured one.
+//
+// The default value is 0.5.
+func KadRTT_StoreRate(rate float64) Option {
+	return func(c *config) error {
+		c.kadrtt_store_rate = rate
+		return nil
+	}
+}
+
+// KadRTT_ExchangeProb configures the k-bucket entry exchange probability the
+// KadRTT routing table assumes before it has measured one.
+//
+// The default value is 0.5.
+func KadRTT_ExchangeProb(prob float64) Option {
+	return func(c *config) error {
+		c.kadrtt_prob_exchange = prob
+		return nil
+	}
+}
+
+// KadRTT_PoolSize configures the pool size used by the KadRTT routing table to
+// derive the optimal alpha and beta of each bucket.
+//
+// The default value is the bucket size.
+func KadRTT_PoolSize(size int) Option {
+	return func(c *config) error {
+		c.kadrtt_pool_size = size
+		return nil
+	}
+}
+
+// KadRTT_BucketKRange bounds the k the KadRTT routing table derives for every bucket to [minK, maxK].
+// A maxK of 0 leaves k unbounded.
+//
+// The default value is [1, 0].
+func KadRTT_BucketKRange(minK, maxK int) Option {
+	return func(c *config) error {
+		if minK < 1 || (maxK != 0 && maxK < minK) {
+			return fmt.Errorf("invalid bucket k range [%d, %d]", minK, maxK)
+		}
+		c.kadrtt_min_k, c.kadrtt_max_k = minK, maxK
+		return nil
+	}
+}
+
+// KadRTT_MaxEntries bounds the number of peers in the KadRTT routing table. When the k derived for the
+// buckets add up to more, they are scaled down together, keeping their ratios but none below the minimum
+// of KadRTT_BucketKRange. 0 leaves the table unbounded.
+//
+// The default value is 0.
+func KadRTT_MaxEntries(n int) Option {
+	return func(c *config) error {
+		if n < 0 {
+			return fmt.Errorf("max entries must not be negative, got %d", n)
+		}
+		c.kadrtt_max_entries = n
 		return nil
 	}
 }
@@ -467,3 +619,131 @@ func KadRTT_Interval(value int) Option {
 func (c *config) GetRTTInterval() time.Duration{
 	return c.kadrtt_ex_interval
 }
+
+// KadRTT_RTTProvider configures where the RTTs of the peers added to the KadRTT routing table come from.
+// See RTTProviderByName for the available providers.
+//
+// The default value is PingPongRTT.
+func KadRTT_RTTProvider(p RTTProvider) Option {
+	return func(c *config) error {
+		if p == nil {
+			return fmt.Errorf("rtt provider must not be nil")
+		}
+		c.rttProvider = p
+		c.rttProviderSet = true
+		return nil
+	}
+}
+
+// KadRTT_NetworkCoordinates enables Vivaldi network coordinates, which the DHT maintains from its RTT samples
+// and exchanges with its peers over the /kadrtt/vivaldi protocol. The routing table and the lookups then
+// estimate the RTT to peers that were never measured, and lookups query the closest peers by estimated RTT.
+// Unless KadRTT_RTTProvider is set, RTTs are measured with coordinate exchanges (VivaldiRTT).
+// It only has an effect in KadRTT mode.
+//
+// The default value is false.
+func KadRTT_NetworkCoordinates(enable bool) Option {
+	return func(c *config) error {
+		c.kadrtt_coordinates = enable
+		return nil
+	}
+}
+
+// KadRTT_DiversityScoring makes the routing table diversity filter score peers instead of rejecting them:
+// a peer sharing its IP groups with other peers of its bucket still gets in, but the KadRTT admission
+// policy inflates its RTT by weight times how crowded its groups are, so that the buckets keep low-latency
+// peers without being taken over by a few networks. It requires RoutingTablePeerDiversityFilter and only
+// has an effect in KadRTT mode. A weight of 0 keeps the filter rejecting peers.
+//
+// The default value is 0.
+func KadRTT_DiversityScoring(weight float64) Option {
+	return func(c *config) error {
+		if weight < 0 {
+			return fmt.Errorf("diversity weight must not be negative, got %v", weight)
+		}
+		c.kadrtt_diversity_weight = weight
+		return nil
+	}
+}
+
+// KadRTT_NextHopScoring configures how the lookups pick the peers they query next among the peers they heard
+// of: by distance to the target only (NextHopXOR), by distance with a penalty for their RTT
+// (NextHopRTTPenalty), or by proximity neighbour selection (NextHopPNS). The RTTs are the ones the routing
+// table measured, or else estimates from network coordinates or the peerstore.
+// With NextHopXOR and network coordinates, the closest peers are still queried by increasing estimated RTT.
+//
+// The default value is NextHopXOR.
+func KadRTT_NextHopScoring(s NextHopScoring) Option {
+	return func(c *config) error {
+		switch s {
+		case NextHopXOR, NextHopRTTPenalty, NextHopPNS:
+		default:
+			return fmt.Errorf("unknown next hop scoring %d", int(s))
+		}
+		c.kadrtt_next_hop = s
+		return nil
+	}
+}
+
+// KadRTT_RTTPenalty configures how much RTT costs as much as doubling the distance to the target with
+// NextHopRTTPenalty.
+//
+// The default value is DefaultRTTPenalty.
+func KadRTT_RTTPenalty(per time.Duration) Option {
+	return func(c *config) error {
+		if per <= 0 {
+			return fmt.Errorf("rtt penalty must be positive, got %s", per)
+		}
+		c.kadrtt_rtt_penalty = per
+		return nil
+	}
+}
+
+// KadRTT_PeerTimeouts gives every lookup query a deadline derived from the RTT samples of the queried peer in
+// the routing table: their RFC 6298 RTO times the number of RTTs of a query (see KadRTT_QueryRTTs), bounded
+// by min and max. Peers without samples get max. A peer that misses its deadline is unreachable for the
+// lookup, which moves on without waiting for the whole lookup to time out, but it stays in the routing table.
+// It only has an effect in KadRTT mode. A max of 0 disables the deadlines.
+//
+// The default value is 0.
+func KadRTT_PeerTimeouts(min, max time.Duration) Option {
+	return func(c *config) error {
+		if min < 0 || max < 0 {
+			return fmt.Errorf("peer timeouts must not be negative, got [%s, %s]", min, max)
+		}
+		if max > 0 && min > max {
+			return fmt.Errorf("min peer timeout %s exceeds max peer timeout %s", min, max)
+		}
+		c.kadrtt_peer_timeout_min = min
+		c.kadrtt_peer_timeout_max = max
+		return nil
+	}
+}
+
+// KadRTT_Hedging hedges the lookup queries: when a peer doesn't answer within the 95th percentile of its RTT
+// times the number of RTTs of a query (see KadRTT_QueryRTTs), its query no longer counts against the
+// concurrency of the lookup, which queries the next candidate right away. Only the peers with RTT samples in
+// the routing table are hedged. The hedges sent and the ones answered before the query they hedge are
+// recorded by the metrics.QueryHedges and metrics.QueryHedgesWon measures. It only has an effect in KadRTT mode.
+//
+// The default value is false.
+func KadRTT_Hedging(enable bool) Option {
+	return func(c *config) error {
+		c.kadrtt_hedging = enable
+		return nil
+	}
+}
+
+// KadRTT_QueryRTTs configures how many RTTs a lookup query to a peer takes, dial included, which scales the
+// deadlines of KadRTT_PeerTimeouts and the hedge delays of KadRTT_Hedging.
+//
+// The default value is DefaultQueryRTTs.
+func KadRTT_QueryRTTs(n float64) Option {
+	return func(c *config) error {
+		if n <= 0 {
+			return fmt.Errorf("query rtts must be positive, got %v", n)
+		}
+		c.kadrtt_query_rtts = n
+		return nil
+	}
+}
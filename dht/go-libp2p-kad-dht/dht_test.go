@@ -2089,3 +2089,28 @@ func TestPreconnectedNodes(t *testing.T) {
 		t.Fatal("test hung")
 	}
 }
+
+func TestKadRTTCloserPeersAreXORClosest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := setupDHT(ctx, t, false, IsKadRTT(true))
+	defer d.Close()
+	for i := 0; i < 50; i++ {
+		p, err := d.routingTable.GenRandPeerID(uint(i % 5))
+		require.NoError(t, err)
+		_, _ = d.routingTable.TryAddPeerKadRTT(p, true, false, time.Duration(1+i)*time.Millisecond)
+	}
+	count := 5
+	require.Greater(t, d.routingTable.Size(), count)
+
+	// FIND_NODE replies carry the count XOR closest peers, whatever the beta of the bucket
+	key, err := d.routingTable.GenRandPeerID(3)
+	require.NoError(t, err)
+	pmes := pb.NewMessage(pb.Message_FIND_NODE, []byte(key), 0)
+	require.Equal(t, d.routingTable.NearestPeers(kb.ConvertPeerID(key), count), d.nearestPeersToQuery(pmes, count))
+
+	// only the lookups start from the beta closest peers ranked by RTT
+	beta := d.routingTable.BucketParamsForCpl(kb.CommonPrefixLen(d.selfKey, kb.ConvertPeerID(key))).Beta
+	require.Len(t, d.lookupSeeds(kb.ConvertPeerID(key)), beta)
+}
@@ -0,0 +1,97 @@
+package dht
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+// pathClaims records which disjoint path of a lookup queries which peer, so that no peer is queried by two
+// paths, as in S/Kademlia. It is shared by the paths of a lookup, which run concurrently.
+type pathClaims struct {
+	mu    sync.Mutex
+	paths map[peer.ID]int
+}
+
+func newPathClaims() *pathClaims {
+	return &pathClaims{paths: make(map[peer.ID]int)}
+}
+
+// claim reserves the peer for the path of the query, and returns false if another path already did.
+// It always succeeds if the lookup takes a single path.
+func (q *query) claim(p peer.ID) bool {
+	if q.claims == nil {
+		return true
+	}
+	q.claims.mu.Lock()
+	defer q.claims.mu.Unlock()
+
+	if path, ok := q.claims.paths[p]; ok {
+		return path == q.path
+	}
+	q.claims.paths[p] = q.path
+	return true
+}
+
+// claimedByOther returns true if another path reserved the peer.
+func (q *query) claimedByOther(p peer.ID) bool {
+	if q.claims == nil {
+		return false
+	}
+	q.claims.mu.Lock()
+	defer q.claims.mu.Unlock()
+
+	path, ok := q.claims.paths[p]
+	return ok && path != q.path
+}
+
+// dropClaimed claims the peers for the path of the query, and marks the ones another path reserved as
+// unreachable, so that the path never queries them nor waits on them. It returns true if any was dropped.
+func (q *query) dropClaimed(peers []peer.ID) bool {
+	dropped := false
+	for _, p := range peers {
+		if !q.claim(p) {
+			q.queryPeers.SetState(p, qpeerset.PeerUnreachable)
+			dropped = true
+		}
+	}
+	return dropped
+}
+
+// disjointSeeds completes the seed peers with the closest peers of the routing table until there are enough
+// for every path to start from one, if the table holds that many.
+func (dht *IpfsDHT) disjointSeeds(target kb.ID, seeds []peer.ID, paths int) []peer.ID {
+	if len(seeds) >= paths {
+		return seeds
+	}
+	seen := make(map[peer.ID]struct{}, len(seeds))
+	for _, p := range seeds {
+		seen[p] = struct{}{}
+	}
+	for _, p := range dht.routingTable.NearestPeers(target, dht.bucketSize) {
+		if len(seeds) >= paths {
+			break
+		}
+		if _, ok := seen[p]; !ok {
+			seeds = append(seeds, p)
+		}
+	}
+	return seeds
+}
+
+// mergeTraces gathers the hops the paths of a lookup traced into its trace, in the order they were sent.
+// The termination reason of the lookup is the first of stopped, cancelled, starvation and completed any
+// path ended with.
+func mergeTraces(trace *LookupTrace, queries []*query) {
+	for i, q := range queries {
+		trace.Hops = append(trace.Hops, q.trace.Hops...)
+		if i == 0 || q.trace.Termination < trace.Termination {
+			trace.Termination = q.trace.Termination
+		}
+	}
+	sort.SliceStable(trace.Hops, func(i, j int) bool { return trace.Hops[i].Sent < trace.Hops[j].Sent })
+}
@@ -0,0 +1,91 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathClaims(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := setupDHT(ctx, t, false)
+	defer d.Close()
+
+	// a single path claims every peer
+	single := newTestQuery(t, d)
+	a, b := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+	require.True(t, single.claim(a))
+	require.False(t, single.claimedByOther(a))
+
+	claims := newPathClaims()
+	q0, q1 := newTestQuery(t, d), newTestQuery(t, d)
+	q0.claims, q1.claims = claims, claims
+	q1.path = 1
+	require.True(t, q0.claim(a))
+	require.True(t, q0.claim(a))
+	require.False(t, q1.claim(a))
+	require.True(t, q1.claimedByOther(a))
+	require.False(t, q0.claimedByOther(a))
+
+	// the peers another path claimed are dropped
+	q1.queryPeers.TryAdd(a, d.self)
+	q1.queryPeers.TryAdd(b, d.self)
+	require.True(t, q1.dropClaimed([]peer.ID{a, b}))
+	require.Equal(t, qpeerset.PeerUnreachable, q1.queryPeers.GetState(a))
+	require.Equal(t, qpeerset.PeerHeard, q1.queryPeers.GetState(b))
+	require.False(t, q1.dropClaimed([]peer.ID{b}))
+	require.True(t, q0.claimedByOther(b))
+
+	var c config
+	require.Error(t, c.apply(DisjointPaths(0)))
+}
+
+func TestDisjointPathLookup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dhts := setupDHTS(t, ctx, 6, DisjointPaths(2))
+	defer func() {
+		for _, d := range dhts {
+			d.Close()
+			d.host.Close()
+		}
+	}()
+	connect(t, ctx, dhts[0], dhts[1])
+	connect(t, ctx, dhts[0], dhts[2])
+	connect(t, ctx, dhts[1], dhts[3])
+	connect(t, ctx, dhts[2], dhts[4])
+	connect(t, ctx, dhts[3], dhts[5])
+	connect(t, ctx, dhts[4], dhts[5])
+
+	ctxT, cancelT := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelT()
+	peers, trace, err := dhts[0].GetClosestPeersWithTrace(ctxT, string(dhts[5].PeerID()))
+	require.NoError(t, err)
+	require.Contains(t, peers, dhts[5].PeerID())
+	require.NotNil(t, trace)
+
+	// both paths were taken, and no peer was queried by both
+	paths := make(map[peer.ID]int)
+	taken := make(map[int]bool)
+	for _, h := range trace.Hops {
+		if path, ok := paths[h.Peer.Peer]; ok {
+			require.Equal(t, path, h.Path, "peer %s queried by two paths", h.Peer.Peer)
+		}
+		paths[h.Peer.Peer] = h.Path
+		taken[h.Path] = true
+	}
+	require.Len(t, taken, 2)
+	for i := 1; i < len(trace.Hops); i++ {
+		require.LessOrEqual(t, trace.Hops[i-1].Sent, trace.Hops[i].Sent)
+	}
+}
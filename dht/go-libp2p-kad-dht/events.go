@@ -91,6 +91,8 @@ type LookupEvent struct {
 	Response *LookupUpdateEvent
 	// Terminate, if not nil, describe a termination event.
 	Terminate *LookupTerminateEvent
+	// Trace, if not nil, is the trace of the lookup, published once its last query returned.
+	Trace *LookupTrace
 }
 
 // NewLookupUpdateEvent creates a new lookup update event, automatically converting the passed peer IDs to peer Kad IDs.
@@ -127,6 +129,14 @@ type LookupUpdateEvent struct {
 	Queried []*PeerKadID
 	// Unreachable is a set of peers whose state in the lookup's peerset is being set to "unreachable".
 	Unreachable []*PeerKadID
+	// Alpha is the concurrency of the lookup when the query was sent. It is only set in Request events.
+	Alpha int
+}
+
+// withAlpha sets the concurrency of the lookup the update event was sent with.
+func (e *LookupUpdateEvent) withAlpha(alpha int) *LookupUpdateEvent {
+	e.Alpha = alpha
+	return e
 }
 
 // LookupTerminateEvent describes a lookup termination event.
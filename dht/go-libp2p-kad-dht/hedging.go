@@ -0,0 +1,73 @@
+package dht
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"go.opencensus.io/stats"
+
+	"github.com/libp2p/go-libp2p-kad-dht/metrics"
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+)
+
+// DefaultQueryRTTs is the default number of RTTs a lookup query to a peer takes, dial included.
+const DefaultQueryRTTs = 2.0
+
+// peerTimeout returns the deadline of a lookup query to the peer, dial included: the RTO of the RTT
+// samples of the peer in the routing table times queryRTTs, bounded by the range set with
+// KadRTT_PeerTimeouts. Peers without samples get the upper bound. It returns 0, i.e. the query only
+// ends with the lookup, if per-peer deadlines are disabled.
+func (dht *IpfsDHT) peerTimeout(p peer.ID) time.Duration {
+	if !dht.isKadRTT || dht.peerTimeoutMax <= 0 {
+		return 0
+	}
+	rtts, ok := dht.routingTable.RTTStats(p)
+	if !ok || rtts.Samples() == 0 {
+		return dht.peerTimeoutMax
+	}
+	timeout := time.Duration(dht.queryRTTs * float64(rtts.RTO()))
+	if timeout < dht.peerTimeoutMin {
+		return dht.peerTimeoutMin
+	}
+	if timeout > dht.peerTimeoutMax {
+		return dht.peerTimeoutMax
+	}
+	return timeout
+}
+
+// hedgeDelay returns how long a lookup query to the peer may go unanswered before it is hedged: the 95th
+// percentile of the RTT samples of the peer in the routing table times queryRTTs. It returns 0, i.e. the
+// query isn't hedged, if hedging is disabled or the peer has no samples.
+func (dht *IpfsDHT) hedgeDelay(p peer.ID) time.Duration {
+	if !dht.isKadRTT || !dht.hedging {
+		return 0
+	}
+	rtts, ok := dht.routingTable.RTTStats(p)
+	if !ok || rtts.Samples() == 0 {
+		return 0
+	}
+	return time.Duration(dht.queryRTTs * float64(rtts.P95()))
+}
+
+// hedge records that the query to the peer h was sent because the query to the peer slow exceeded its
+// hedge delay.
+func (q *query) hedge(ctx context.Context, slow, h peer.ID) {
+	q.hedged[slow] = true
+	q.hedges[h] = slow
+	q.traceHedge(h)
+	stats.Record(q.dht.newContextWithLocalTags(ctx), metrics.QueryHedges.M(1))
+}
+
+// hedgeDone forgets the hedge query to the peer, if it is one, and records whether it won, i.e. it was
+// answered while the query it hedged is still outstanding.
+func (q *query) hedgeDone(ctx context.Context, p peer.ID, answered bool) {
+	slow, ok := q.hedges[p]
+	if !ok {
+		return
+	}
+	delete(q.hedges, p)
+	if answered && q.queryPeers.GetState(slow) == qpeerset.PeerWaiting {
+		stats.Record(q.dht.newContextWithLocalTags(ctx), metrics.QueryHedgesWon.M(1))
+	}
+}
@@ -0,0 +1,107 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+	"go.opencensus.io/stats/view"
+
+	"github.com/libp2p/go-libp2p-kad-dht/metrics"
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+	"github.com/libp2p/go-libp2p-kbucket/clock"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerTimeoutAndHedgeDelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	plain := setupDHT(ctx, t, false, IsKadRTT(true))
+	defer plain.Close()
+	measured, unknown := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+	_, err := plain.routingTable.TryAddPeerKadRTT(measured, true, false, 100*time.Millisecond)
+	require.NoError(t, err)
+	require.Zero(t, plain.peerTimeout(measured))
+	require.Zero(t, plain.hedgeDelay(measured))
+
+	d := setupDHT(ctx, t, false, IsKadRTT(true), KadRTT_PeerTimeouts(100*time.Millisecond, 2*time.Second), KadRTT_Hedging(true))
+	defer d.Close()
+	added, err := d.routingTable.TryAddPeerKadRTT(measured, true, false, 100*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, added)
+
+	// a single 100ms sample: RTO = 100ms + 4*50ms, p95 = 100ms + 1.645*50ms, and a query takes 2 RTTs
+	require.Equal(t, 600*time.Millisecond, d.peerTimeout(measured))
+	require.Equal(t, 364500*time.Microsecond, d.hedgeDelay(measured))
+	// peers without samples get the longest deadline and aren't hedged
+	require.Equal(t, 2*time.Second, d.peerTimeout(unknown))
+	require.Zero(t, d.hedgeDelay(unknown))
+
+	var c config
+	require.Error(t, c.apply(KadRTT_PeerTimeouts(time.Second, time.Millisecond)))
+	require.Error(t, c.apply(KadRTT_QueryRTTs(0)))
+}
+
+func TestQueryHedging(t *testing.T) {
+	require.NoError(t, view.Register(metrics.DefaultViews...))
+	defer view.Unregister(metrics.DefaultViews...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := clock.NewMock()
+	d := setupDHT(ctx, t, false, IsKadRTT(true), KadRTT_Hedging(true), Clock(clk))
+	defer d.Close()
+	q := newTestQuery(t, d)
+	for i := 0; i < 3; i++ {
+		q.queryPeers.TryAdd(test.RandPeerIDFatal(t), d.self)
+	}
+	peers := q.queryPeers.GetClosestNInStates(3, qpeerset.PeerHeard)
+	slow, fast, late := peers[0], peers[1], peers[2]
+
+	// the lookup wakes up when the slow peer exceeds its hedge delay on the clock of the DHT
+	q.queryPeers.SetState(slow, qpeerset.PeerWaiting)
+	q.outstanding[slow] = outstandingQuery{sent: d.clock.Now(), hedgeAfter: 100 * time.Millisecond}
+	base, due, wake := q.stepAlpha(d.clock.Now(), q.maxAlpha())
+	require.Empty(t, due)
+	require.Equal(t, 100*time.Millisecond, wake)
+
+	// which frees its slot for a hedge
+	clk.Add(time.Second)
+	now := d.clock.Now()
+	alpha, due, _ := q.stepAlpha(now, q.maxAlpha())
+	require.Equal(t, base+1, alpha)
+	require.Equal(t, []peer.ID{slow}, due)
+
+	// once hedged, it still frees its slot, but isn't due anymore
+	q.queryPeers.SetState(fast, qpeerset.PeerWaiting)
+	q.hedge(ctx, slow, fast)
+	alpha, due, _ = q.stepAlpha(now, q.maxAlpha())
+	require.Equal(t, base+1, alpha)
+	require.Empty(t, due)
+
+	// the hedge answered first
+	q.queryPeers.SetState(fast, qpeerset.PeerQueried)
+	q.hedgeDone(ctx, fast, true)
+
+	// this one didn't: the query it hedged was already answered
+	q.queryPeers.SetState(late, qpeerset.PeerWaiting)
+	q.hedges[late] = slow
+	q.queryPeers.SetState(slow, qpeerset.PeerQueried)
+	q.hedgeDone(ctx, late, true)
+	require.Empty(t, q.hedges)
+
+	count := func(v *view.View) int64 {
+		var n int64
+		for _, data := range rowsOf(t, v, d, metrics.KeyInstanceID) {
+			n += data.(*view.CountData).Value
+		}
+		return n
+	}
+	require.Equal(t, int64(1), count(metrics.QueryHedgesView))
+	require.Equal(t, int64(1), count(metrics.QueryHedgesWonView))
+}
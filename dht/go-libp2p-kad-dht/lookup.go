@@ -18,6 +18,32 @@ import (
 // If the context is canceled, this function will return the context error along
 // with the closest K peers it has found so far.
 func (dht *IpfsDHT) GetClosestPeers(ctx context.Context, key string) (<-chan peer.ID, error) {
+	lookupRes, err := dht.getClosestPeers(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan peer.ID, dht.bucketSize)
+	defer close(out)
+
+	for _, p := range lookupRes.peers {
+		out <- p
+	}
+
+	return out, ctx.Err()
+}
+
+// GetClosestPeersWithTrace is GetClosestPeers, which also returns the trace of the lookup, whether or not
+// LookupTracing is enabled. The trace is nil if the lookup didn't start, i.e. the error isn't a context error.
+func (dht *IpfsDHT) GetClosestPeersWithTrace(ctx context.Context, key string) ([]peer.ID, *LookupTrace, error) {
+	lookupRes, err := dht.getClosestPeers(withLookupTrace(ctx), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return lookupRes.peers, lookupRes.trace, ctx.Err()
+}
+
+func (dht *IpfsDHT) getClosestPeers(ctx context.Context, key string) (*lookupWithFollowupResult, error) {
 	if key == "" {
 		return nil, fmt.Errorf("can't lookup empty key")
 	}
@@ -53,18 +79,10 @@ func (dht *IpfsDHT) GetClosestPeers(ctx context.Context, key string) (<-chan pee
 		return nil, err
 	}
 
-
-	out := make(chan peer.ID, dht.bucketSize)
-	defer close(out)
-
-	for _, p := range lookupRes.peers {
-		out <- p
-	}
-
 	if ctx.Err() == nil && lookupRes.completed {
 		// refresh the cpl for this key as the query was successful
 		dht.routingTable.ResetCplRefreshedAtForID(kb.ConvertKey(key), time.Now())
 	}
 
-	return out, ctx.Err()
+	return lookupRes, nil
 }
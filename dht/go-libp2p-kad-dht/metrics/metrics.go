@@ -19,6 +19,10 @@ var (
 	// KeyInstanceID identifies a dht instance by the pointer address.
 	// Useful for differentiating between different dhts that have the same peer id.
 	KeyInstanceID, _ = tag.NewKey("instance_id")
+	// KeyCpl is the common prefix length with the local ID served by a routing table bucket.
+	KeyCpl, _ = tag.NewKey("cpl")
+	// KeyEvictionReason is the reason a peer left the routing table.
+	KeyEvictionReason, _ = tag.NewKey("eviction_reason")
 )
 
 // UpsertMessageType is a convenience upserts the message type
@@ -39,6 +43,18 @@ var (
 	SentRequests           = stats.Int64("libp2p.io/dht/kad/sent_requests", "Total number of requests sent per RPC", stats.UnitDimensionless)
 	SentRequestErrors      = stats.Int64("libp2p.io/dht/kad/sent_request_errors", "Total number of errors for requests sent per RPC", stats.UnitDimensionless)
 	SentBytes              = stats.Int64("libp2p.io/dht/kad/sent_bytes", "Total sent bytes per RPC", stats.UnitBytes)
+
+	RoutingTableSize          = stats.Int64("libp2p.io/dht/kad/routing_table_size", "Number of peers in the routing table per CPL", stats.UnitDimensionless)
+	RoutingTableEvictions     = stats.Int64("libp2p.io/dht/kad/routing_table_evictions", "Total number of peers evicted from the routing table per reason", stats.UnitDimensionless)
+	BucketK                   = stats.Int64("libp2p.io/dht/kad/bucket_k", "Capacity of the routing table bucket per CPL", stats.UnitDimensionless)
+	BucketAlpha               = stats.Int64("libp2p.io/dht/kad/bucket_alpha", "Lookup concurrency for the targets of the routing table bucket per CPL", stats.UnitDimensionless)
+	BucketBeta                = stats.Int64("libp2p.io/dht/kad/bucket_beta", "Number of next hops returned by each queried peer for the targets of the routing table bucket per CPL", stats.UnitDimensionless)
+	BucketMeanRTT             = stats.Float64("libp2p.io/dht/kad/bucket_mean_rtt", "Mean RTT of the peers in the routing table per CPL", stats.UnitMilliseconds)
+	BucketP95RTT              = stats.Float64("libp2p.io/dht/kad/bucket_p95_rtt", "95th percentile of the RTT of the peers in the routing table per CPL", stats.UnitMilliseconds)
+	KadRTTArrivalRate         = stats.Float64("libp2p.io/dht/kad/kadrtt_arrival_rate", "STORE arrival rate per nanosecond measured by KadRTT", stats.UnitDimensionless)
+	KadRTTExchangeProbability = stats.Float64("libp2p.io/dht/kad/kadrtt_exchange_probability", "k-bucket entry exchange probability measured by KadRTT", stats.UnitDimensionless)
+	QueryHedges               = stats.Int64("libp2p.io/dht/kad/query_hedges", "Total number of lookup queries sent because a queried peer exceeded its 95th percentile RTT", stats.UnitDimensionless)
+	QueryHedgesWon            = stats.Int64("libp2p.io/dht/kad/query_hedges_won", "Total number of hedge queries answered before the query they hedged", stats.UnitDimensionless)
 )
 
 // Views
@@ -93,6 +109,61 @@ var (
 		TagKeys:     []tag.Key{KeyMessageType, KeyPeerID, KeyInstanceID},
 		Aggregation: defaultBytesDistribution,
 	}
+	RoutingTableSizeView = &view.View{
+		Measure:     RoutingTableSize,
+		TagKeys:     []tag.Key{KeyCpl, KeyPeerID, KeyInstanceID},
+		Aggregation: view.LastValue(),
+	}
+	RoutingTableEvictionsView = &view.View{
+		Measure:     RoutingTableEvictions,
+		TagKeys:     []tag.Key{KeyEvictionReason, KeyPeerID, KeyInstanceID},
+		Aggregation: view.Count(),
+	}
+	BucketKView = &view.View{
+		Measure:     BucketK,
+		TagKeys:     []tag.Key{KeyCpl, KeyPeerID, KeyInstanceID},
+		Aggregation: view.LastValue(),
+	}
+	BucketAlphaView = &view.View{
+		Measure:     BucketAlpha,
+		TagKeys:     []tag.Key{KeyCpl, KeyPeerID, KeyInstanceID},
+		Aggregation: view.LastValue(),
+	}
+	BucketBetaView = &view.View{
+		Measure:     BucketBeta,
+		TagKeys:     []tag.Key{KeyCpl, KeyPeerID, KeyInstanceID},
+		Aggregation: view.LastValue(),
+	}
+	BucketMeanRTTView = &view.View{
+		Measure:     BucketMeanRTT,
+		TagKeys:     []tag.Key{KeyCpl, KeyPeerID, KeyInstanceID},
+		Aggregation: view.LastValue(),
+	}
+	BucketP95RTTView = &view.View{
+		Measure:     BucketP95RTT,
+		TagKeys:     []tag.Key{KeyCpl, KeyPeerID, KeyInstanceID},
+		Aggregation: view.LastValue(),
+	}
+	KadRTTArrivalRateView = &view.View{
+		Measure:     KadRTTArrivalRate,
+		TagKeys:     []tag.Key{KeyPeerID, KeyInstanceID},
+		Aggregation: view.LastValue(),
+	}
+	KadRTTExchangeProbabilityView = &view.View{
+		Measure:     KadRTTExchangeProbability,
+		TagKeys:     []tag.Key{KeyPeerID, KeyInstanceID},
+		Aggregation: view.LastValue(),
+	}
+	QueryHedgesView = &view.View{
+		Measure:     QueryHedges,
+		TagKeys:     []tag.Key{KeyPeerID, KeyInstanceID},
+		Aggregation: view.Count(),
+	}
+	QueryHedgesWonView = &view.View{
+		Measure:     QueryHedgesWon,
+		TagKeys:     []tag.Key{KeyPeerID, KeyInstanceID},
+		Aggregation: view.Count(),
+	}
 )
 
 // DefaultViews with all views in it.
@@ -107,4 +178,15 @@ var DefaultViews = []*view.View{
 	SentRequestsView,
 	SentRequestErrorsView,
 	SentBytesView,
+	RoutingTableSizeView,
+	RoutingTableEvictionsView,
+	BucketKView,
+	BucketAlphaView,
+	BucketBetaView,
+	BucketMeanRTTView,
+	BucketP95RTTView,
+	KadRTTArrivalRateView,
+	KadRTTExchangeProbabilityView,
+	QueryHedgesView,
+	QueryHedgesWonView,
 }
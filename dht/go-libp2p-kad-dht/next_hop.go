@@ -0,0 +1,64 @@
+package dht
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+)
+
+// NextHopScoring selects how the lookups order the peers they heard of to pick the ones they query next.
+type NextHopScoring int
+
+const (
+	// NextHopXOR queries the closest peers to the target first.
+	NextHopXOR NextHopScoring = iota
+	// NextHopRTTPenalty trades distance for RTT, see qpeerset.RTTPenaltyScore.
+	NextHopRTTPenalty
+	// NextHopPNS queries the fastest of the peers with the longest common prefix with the target first,
+	// see qpeerset.PNSScore.
+	NextHopPNS
+)
+
+// DefaultRTTPenalty is the default RTT worth one bit of distance with NextHopRTTPenalty.
+const DefaultRTTPenalty = 100 * time.Millisecond
+
+// NextHopScoringByName returns the next hop scoring with the given name: xor, rtt-penalty or pns.
+func NextHopScoringByName(name string) (NextHopScoring, error) {
+	switch name {
+	case "xor":
+		return NextHopXOR, nil
+	case "rtt-penalty":
+		return NextHopRTTPenalty, nil
+	case "pns":
+		return NextHopPNS, nil
+	default:
+		return NextHopXOR, fmt.Errorf("unknown next hop scoring %q", name)
+	}
+}
+
+func (s NextHopScoring) String() string {
+	switch s {
+	case NextHopXOR:
+		return "xor"
+	case NextHopRTTPenalty:
+		return "rtt-penalty"
+	case NextHopPNS:
+		return "pns"
+	default:
+		return fmt.Sprintf("NextHopScoring(%d)", int(s))
+	}
+}
+
+// nextHopScoreFunc returns the function the lookups order the heard peers with, fed by the RTTs the
+// routing table knows or estimates. It returns nil for NextHopXOR, which keeps the peers ordered by distance.
+func (dht *IpfsDHT) nextHopScoreFunc(s NextHopScoring, per time.Duration) qpeerset.ScoreFunc {
+	switch s {
+	case NextHopRTTPenalty:
+		return qpeerset.RTTPenaltyScore(dht.routingTable.EstimateRTT, per)
+	case NextHopPNS:
+		return qpeerset.PNSScore(dht.routingTable.EstimateRTT)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,59 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextHopScoringByName(t *testing.T) {
+	for _, s := range []NextHopScoring{NextHopXOR, NextHopRTTPenalty, NextHopPNS} {
+		byName, err := NextHopScoringByName(s.String())
+		require.NoError(t, err)
+		require.Equal(t, s, byName)
+	}
+	_, err := NextHopScoringByName("closest")
+	require.Error(t, err)
+
+	var c config
+	require.Error(t, c.apply(KadRTT_NextHopScoring(NextHopScoring(42))))
+	require.Error(t, c.apply(KadRTT_RTTPenalty(0)))
+}
+
+func TestQueryOrdersByNextHopScore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	plain := setupDHT(ctx, t, false, IsKadRTT(true))
+	defer plain.Close()
+	require.Nil(t, plain.nextHopScore)
+
+	d := setupDHT(ctx, t, false, IsKadRTT(true), KadRTT_NextHopScoring(NextHopRTTPenalty), KadRTT_RTTPenalty(time.Millisecond))
+	defer d.Close()
+	require.NotNil(t, d.nextHopScore)
+
+	q := newTestQuery(t, d)
+	q.stopFn = func() bool { return false }
+	q.queryPeers.SetScoreFunc(d.nextHopScore)
+	for i := 0; i < 4; i++ {
+		q.queryPeers.TryAdd(test.RandPeerIDFatal(t), d.self)
+	}
+	closest := q.queryPeers.GetClosestNInStates(4, qpeerset.PeerHeard)
+
+	// the farthest peer is so much faster that it makes up for its distance
+	for _, p := range closest[:3] {
+		d.peerstore.RecordLatency(p, time.Second)
+	}
+	d.peerstore.RecordLatency(closest[3], time.Millisecond)
+
+	ready, _, next := q.isReadyToTerminate(ctx, 2)
+	require.False(t, ready)
+	require.Equal(t, []peer.ID{closest[3], closest[0]}, next)
+}
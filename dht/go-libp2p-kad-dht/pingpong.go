@@ -43,20 +43,18 @@ func (pp *pingPong) Handler(s network.Stream) {
 	}
 }
 
-func playPingPong(h host.Host, id peer.ID) {
-	s, err := h.NewStream(context.Background(), id, protoPingPong)
+// playPingPong opens a pingpong stream to the peer and waits for the pong.
+func playPingPong(ctx context.Context, h host.Host, id peer.ID) error {
+	s, err := h.NewStream(ctx, id, protoPingPong)
 	if err != nil {
-		//log.Errorf("error when creating stream to play pingpong: %v", err)
-		return
+		return err
 	}
 	defer s.Close()
-	//log.Infof("--> Ping: to %s", s.Conn().RemoteMultiaddr())
-	_, err = ioutil.ReadAll(s)
-	if err != nil {
-		s.Reset()
-		//log.Errorf("error when reading from pingpong stream: %v", err)
-		return
-	}
 	// Is important to read the stream until EOF to not leak the stream.
 	// In our case is true by ioutil.ReadAll().
-}
\ No newline at end of file
+	if _, err := ioutil.ReadAll(s); err != nil {
+		s.Reset()
+		return err
+	}
+	return nil
+}
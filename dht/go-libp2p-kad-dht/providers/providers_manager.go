@@ -16,6 +16,7 @@ import (
 	logging "github.com/ipfs/go-log"
 	goprocess "github.com/jbenet/goprocess"
 	goprocessctx "github.com/jbenet/goprocess/context"
+	"github.com/libp2p/go-libp2p-kbucket/clock"
 	base32 "github.com/multiformats/go-base32"
 )
 
@@ -43,6 +44,7 @@ type ProviderManager struct {
 	proc     goprocess.Process
 
 	cleanupInterval time.Duration
+	clock           clock.Clock
 }
 
 // Option is a function that sets a provider manager option.
@@ -66,6 +68,18 @@ func CleanupInterval(d time.Duration) Option {
 	}
 }
 
+// Clock sets the clock the provider records are timestamped, expired and garbage collected with.
+// Defaults to the wall clock.
+func Clock(c clock.Clock) Option {
+	return func(pm *ProviderManager) error {
+		if c == nil {
+			return fmt.Errorf("clock must not be nil")
+		}
+		pm.clock = c
+		return nil
+	}
+}
+
 // Cache sets the LRU cache implementation.
 // Defaults to a simple LRU cache.
 func Cache(c lru.LRUCache) Option {
@@ -97,6 +111,7 @@ func NewProviderManager(ctx context.Context, local peer.ID, dstore ds.Batching,
 	}
 	pm.cache = cache
 	pm.cleanupInterval = defaultCleanupInterval
+	pm.clock = clock.New()
 	if err := pm.applyOptions(opts...); err != nil {
 		return nil, err
 	}
@@ -116,7 +131,7 @@ func (pm *ProviderManager) run(proc goprocess.Process) {
 		gcQueryRes <-chan dsq.Result
 		gcSkip     map[string]struct{}
 		gcTime     time.Time
-		gcTimer    = time.NewTimer(pm.cleanupInterval)
+		gcTimer    = pm.clock.NewTimer(pm.cleanupInterval)
 	)
 
 	defer func() {
@@ -227,7 +242,7 @@ func (pm *ProviderManager) AddProvider(ctx context.Context, k []byte, val peer.I
 
 // addProv updates the cache if needed
 func (pm *ProviderManager) addProv(k []byte, p peer.ID) error {
-	now := time.Now()
+	now := pm.clock.Now()
 	if provs, ok := pm.cache.Get(string(k)); ok {
 		provs.(*providerSet).setVal(p, now)
 	} // else not cached, just write through
@@ -288,7 +303,7 @@ func (pm *ProviderManager) getProviderSetForKey(k []byte) (*providerSet, error)
 		return cached.(*providerSet), nil
 	}
 
-	pset, err := loadProviderSet(pm.dstore, k)
+	pset, err := loadProviderSet(pm.dstore, k, pm.clock.Now())
 	if err != nil {
 		return nil, err
 	}
@@ -300,15 +315,14 @@ func (pm *ProviderManager) getProviderSetForKey(k []byte) (*providerSet, error)
 	return pset, nil
 }
 
-// loads the ProviderSet out of the datastore
-func loadProviderSet(dstore ds.Datastore, k []byte) (*providerSet, error) {
+// loads the ProviderSet out of the datastore, dropping the records that have expired by now
+func loadProviderSet(dstore ds.Datastore, k []byte, now time.Time) (*providerSet, error) {
 	res, err := dstore.Query(dsq.Query{Prefix: mkProvKey(k)})
 	if err != nil {
 		return nil, err
 	}
 	defer res.Close()
 
-	now := time.Now()
 	out := newProviderSet()
 	for {
 		e, ok := res.NextSync()
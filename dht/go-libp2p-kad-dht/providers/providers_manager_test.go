@@ -16,6 +16,7 @@ import (
 	dsq "github.com/ipfs/go-datastore/query"
 	dssync "github.com/ipfs/go-datastore/sync"
 	u "github.com/ipfs/go-ipfs-util"
+	"github.com/libp2p/go-libp2p-kbucket/clock"
 	//
 	// used by TestLargeProvidersSet: do not remove
 	// lds "github.com/ipfs/go-ds-leveldb"
@@ -111,7 +112,7 @@ func TestProvidersSerialization(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	pset, err := loadProviderSet(dstore, k)
+	pset, err := loadProviderSet(dstore, k, time.Now())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -219,6 +220,88 @@ func TestProvidesExpire(t *testing.T) {
 	}
 }
 
+func TestProvidesExpireWithMockClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := clock.NewMock()
+	ds := dssync.MutexWrap(ds.NewMapDatastore())
+	mid := peer.ID("testing")
+	p, err := NewProviderManager(ctx, mid, ds, CleanupInterval(time.Hour), Clock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Process().Close()
+
+	// advance moves the clock once the garbage collection timer is armed, i.e. no collection is running,
+	// and waits for the collections it triggers to be done.
+	advance := func(d time.Duration) {
+		clk.BlockUntil(1)
+		clk.Add(d)
+		clk.BlockUntil(1)
+	}
+	expectProviders := func(hs []mh.Multihash, n int) {
+		t.Helper()
+		for _, h := range hs {
+			if out := p.GetProviders(ctx, h); len(out) != n {
+				t.Fatalf("expected %d providers, got: %v", n, out)
+			}
+		}
+	}
+
+	peers := []peer.ID{"a", "b"}
+	var mhs []mh.Multihash
+	for i := 0; i < 10; i++ {
+		h := u.Hash([]byte(fmt.Sprint(i)))
+		mhs = append(mhs, h)
+	}
+
+	for _, h := range mhs[:5] {
+		p.AddProvider(ctx, h, peers[0])
+		p.AddProvider(ctx, h, peers[1])
+	}
+	expectProviders(mhs[:5], 2)
+
+	advance(ProvideValidity / 2)
+
+	for _, h := range mhs[5:] {
+		p.AddProvider(ctx, h, peers[0])
+		p.AddProvider(ctx, h, peers[1])
+	}
+	expectProviders(mhs, 2)
+
+	// the first records expire a full validity period after they were added, not a moment before
+	advance(ProvideValidity / 2)
+	expectProviders(mhs[:5], 2)
+
+	// the cache is purged when the garbage collection timer, moved by the clock, fires
+	advance(time.Hour)
+	expectProviders(mhs[:5], 0)
+	expectProviders(mhs[5:], 2)
+
+	// a long move may fire the collection timer before the last records expired, but the timer is re-armed
+	// at the end of the move, so the next round collects them
+	advance(ProvideValidity)
+	advance(time.Hour)
+	expectProviders(mhs, 0)
+
+	// the deletions of the last round are flushed when the manager stops
+	if err := p.Process().Close(); err != nil {
+		t.Fatal(err)
+	}
+	res, err := ds.Query(dsq.Query{Prefix: ProvidersKeyPrefix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) > 0 {
+		t.Fatal("expected everything to be cleaned out of the datastore")
+	}
+}
+
 var _ = ioutil.NopCloser
 var _ = os.DevNull
 
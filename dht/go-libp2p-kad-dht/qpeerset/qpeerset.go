@@ -1,6 +1,8 @@
 package qpeerset
 
 import (
+	"encoding/json"
+	"fmt"
 	"math/big"
 	"sort"
 
@@ -22,6 +24,25 @@ const (
 	PeerUnreachable
 )
 
+func (s PeerState) String() string {
+	switch s {
+	case PeerHeard:
+		return "heard"
+	case PeerWaiting:
+		return "waiting"
+	case PeerQueried:
+		return "queried"
+	case PeerUnreachable:
+		return "unreachable"
+	}
+	return fmt.Sprintf("PeerState(%d)", int(s))
+}
+
+// MarshalJSON returns the JSON encoding of the peer state.
+func (s PeerState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
 // QueryPeerset maintains the state of a Kademlia asynchronous lookup.
 // The lookup state is a set of peers, each labeled with a peer state.
 type QueryPeerset struct {
@@ -33,6 +54,9 @@ type QueryPeerset struct {
 
 	// sorted is true if all is currently in sorted order
 	sorted bool
+
+	// score orders the peers returned by GetBestNInStates, nil orders them by distance
+	score ScoreFunc
 }
 
 type queryPeerState struct {
@@ -0,0 +1,81 @@
+package qpeerset
+
+import (
+	"math"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ScoreFunc scores a peer of the lookup from its distance to the key. The lower the score, the sooner
+// the peer is queried; peers with equal scores are ordered by distance.
+type ScoreFunc func(p peer.ID, distance *big.Int) float64
+
+// RTTFunc returns the RTT to a peer, and false if it isn't known.
+type RTTFunc func(p peer.ID) (time.Duration, bool)
+
+// XORScore scores every peer the same, which orders the peers by distance only.
+func XORScore(peer.ID, *big.Int) float64 {
+	return 0
+}
+
+// RTTPenaltyScore scores a peer by the logarithm of its distance plus a penalty of one bit every per of
+// RTT: a peer twice as far is preferred if it answers per faster. Peers whose RTT isn't known are
+// penalized as if their RTT was per.
+func RTTPenaltyScore(rtt RTTFunc, per time.Duration) ScoreFunc {
+	return func(p peer.ID, distance *big.Int) float64 {
+		d, ok := rtt(p)
+		if !ok {
+			d = per
+		}
+		return log2(distance) + float64(d)/float64(per)
+	}
+}
+
+// PNSScore implements proximity neighbour selection: the peers are ordered by the length of their distance
+// to the key, i.e. by decreasing common prefix length, and the peers at the same distance by increasing RTT.
+// Peers whose RTT isn't known come last among them.
+func PNSScore(rtt RTTFunc) ScoreFunc {
+	return func(p peer.ID, distance *big.Int) float64 {
+		// map the RTT to [0, 1) so that it never outweighs a bit of distance
+		frac := math.Nextafter(1, 0)
+		if d, ok := rtt(p); ok {
+			frac = float64(d) / float64(d+time.Second)
+		}
+		return float64(distance.BitLen()) + frac
+	}
+}
+
+func log2(x *big.Int) float64 {
+	f, _ := new(big.Float).SetInt(x).Float64()
+	return math.Log2(1 + f)
+}
+
+// SetScoreFunc sets the function GetBestNInStates orders the peers with. nil stands for XORScore.
+func (qp *QueryPeerset) SetScoreFunc(score ScoreFunc) {
+	qp.score = score
+}
+
+// GetBestNInStates returns the peers with the lowest score, which are in one of the given states.
+// It returns n peers or less, if fewer peers meet the condition.
+// The returned peers are sorted in ascending order by their score, then by their distance to the key.
+func (qp *QueryPeerset) GetBestNInStates(n int, states ...PeerState) []peer.ID {
+	if qp.score == nil {
+		return qp.GetClosestNInStates(n, states...)
+	}
+	peers := qp.GetClosestInStates(states...)
+	scores := make(map[peer.ID]float64, len(peers))
+	for _, p := range peers {
+		scores[p] = qp.score(p, qp.all[qp.find(p)].distance)
+	}
+	// peers are sorted by distance, which the stable sort keeps for equal scores
+	sort.SliceStable(peers, func(i, j int) bool {
+		return scores[peers[i]] < scores[peers[j]]
+	})
+	if len(peers) > n {
+		peers = peers[:n]
+	}
+	return peers
+}
@@ -0,0 +1,73 @@
+package qpeerset
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetBestNInStates(t *testing.T) {
+	key := "test"
+	qp := NewQueryPeerset(key)
+	for i := 0; i < 4; i++ {
+		require.True(t, qp.TryAdd(test.RandPeerIDFatal(t), ""))
+	}
+	closest := qp.GetClosestNInStates(4, PeerHeard)
+
+	// without a score function, the best peers are the closest
+	require.Equal(t, closest[:2], qp.GetBestNInStates(2, PeerHeard))
+	qp.SetScoreFunc(XORScore)
+	require.Equal(t, closest, qp.GetBestNInStates(4, PeerHeard))
+
+	// the farthest peer scores best, the others tie and keep their order
+	qp.SetScoreFunc(func(p peer.ID, _ *big.Int) float64 {
+		if p == closest[3] {
+			return -1
+		}
+		return 0
+	})
+	require.Equal(t, []peer.ID{closest[3], closest[0], closest[1]}, qp.GetBestNInStates(3, PeerHeard))
+
+	// only the peers in the given states are candidates
+	qp.SetState(closest[3], PeerWaiting)
+	require.Equal(t, []peer.ID{closest[0], closest[1], closest[2]}, qp.GetBestNInStates(4, PeerHeard))
+}
+
+func TestRTTPenaltyScore(t *testing.T) {
+	a, b := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+	rtts := map[peer.ID]time.Duration{a: 10 * time.Millisecond, b: 210 * time.Millisecond}
+	rtt := func(p peer.ID) (time.Duration, bool) {
+		d, ok := rtts[p]
+		return d, ok
+	}
+	score := RTTPenaltyScore(rtt, 100*time.Millisecond)
+
+	// a is twice as far as b, but answers 200ms faster, which is worth two bits
+	near, far := big.NewInt(1023), big.NewInt(2047)
+	require.Less(t, score(a, far), score(b, near))
+	require.InDelta(t, 1, score(b, near)-score(a, far), 1e-9)
+
+	// an unknown RTT costs as much as per
+	c := test.RandPeerIDFatal(t)
+	require.InDelta(t, score(a, near)+0.9, score(c, near), 1e-9)
+}
+
+func TestPNSScore(t *testing.T) {
+	a, b, c := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+	rtts := map[peer.ID]time.Duration{a: 500 * time.Millisecond, b: 10 * time.Millisecond}
+	score := PNSScore(func(p peer.ID) (time.Duration, bool) {
+		d, ok := rtts[p]
+		return d, ok
+	})
+
+	// the RTT only orders the peers at the same distance length
+	require.Less(t, score(a, big.NewInt(1000)), score(b, big.NewInt(1024)))
+	require.Less(t, score(b, big.NewInt(1000)), score(a, big.NewInt(600)))
+	require.Less(t, score(a, big.NewInt(600)), score(c, big.NewInt(1000)))
+	require.Less(t, score(c, big.NewInt(1000)), score(b, big.NewInt(1024)))
+}
@@ -46,6 +46,26 @@ type query struct {
 	// queryPeers is the set of peers known by this query and their respective states.
 	queryPeers *qpeerset.QueryPeerset
 
+	// alpha is the concurrency of the current step of the lookup.
+	alpha int
+
+	// outstanding contains the queries the lookup is waiting on.
+	outstanding map[peer.ID]outstandingQuery
+
+	// hedged contains the outstanding queries that were hedged, and hedges maps the peers queried as
+	// hedges to the peers whose query they hedge.
+	hedged map[peer.ID]bool
+	hedges map[peer.ID]peer.ID
+
+	// path is the index of the disjoint path of the query, and claims tells which path queries which peer.
+	// claims is nil if the lookup takes a single path.
+	path   int
+	claims *pathClaims
+
+	// trace is the trace of the lookup, nil if it isn't traced, and traceHops are its hops by peer.
+	trace     *LookupTrace
+	traceHops map[peer.ID]*LookupHop
+
 	// terminated is set when the first worker thread encounters the termination condition.
 	// Its role is to make sure that once termination is determined, it is sticky.
 	terminated bool
@@ -67,6 +87,9 @@ type lookupWithFollowupResult struct {
 	// indicates that neither the lookup nor the followup has been prematurely terminated by an external condition such
 	// as context cancellation or the stop function being called.
 	completed bool
+
+	// the trace of the lookup, nil unless it is traced
+	trace *LookupTrace
 }
 
 // runLookupWithFollowup executes the lookup on the target using the given query function and stopping when either the
@@ -148,7 +171,7 @@ processFollowUp:
 func (dht *IpfsDHT) runQuery(ctx context.Context, target string, queryFn queryFn, stopFn stopFn) (*lookupWithFollowupResult, error) {
 	// pick the K closest peers to the key in our Routing table.
 	targetKadID := kb.ConvertKey(target)
-	seedPeers := dht.routingTable.NearestPeers(targetKadID, dht.bucketSize)
+	seedPeers := dht.lookupSeeds(targetKadID)
 	if len(seedPeers) == 0 {
 		routing.PublishQueryEvent(ctx, &routing.QueryEvent{
 			Type:  routing.QueryError,
@@ -157,27 +180,84 @@ func (dht *IpfsDHT) runQuery(ctx context.Context, target string, queryFn queryFn
 		return nil, kb.ErrLookupFailure
 	}
 
-	q := &query{
-		id:         uuid.New(),
-		key:        target,
-		ctx:        ctx,
-		dht:        dht,
-		queryPeers: qpeerset.NewQueryPeerset(target),
-		seedPeers:  seedPeers,
-		peerTimes:  make(map[peer.ID]time.Duration),
-		terminated: false,
-		queryFn:    queryFn,
-		stopFn:     stopFn,
+	// with disjoint paths, every path starts from its share of the seed peers
+	paths := dht.disjointPaths
+	var claims *pathClaims
+	if paths > 1 {
+		seedPeers = dht.disjointSeeds(targetKadID, seedPeers, paths)
+		if paths > len(seedPeers) {
+			paths = len(seedPeers)
+		}
+		claims = newPathClaims()
+	}
+
+	id := uuid.New()
+	trace := dht.newLookupTrace(ctx, id, target)
+	queries := make([]*query, paths)
+	for i := range queries {
+		q := &query{
+			id:         id,
+			key:        target,
+			ctx:        ctx,
+			dht:        dht,
+			queryPeers: qpeerset.NewQueryPeerset(target),
+			peerTimes:  make(map[peer.ID]time.Duration),
+			terminated: false,
+			queryFn:    queryFn,
+			stopFn:     stopFn,
+
+			outstanding: make(map[peer.ID]outstandingQuery),
+			hedged:      make(map[peer.ID]bool),
+			hedges:      make(map[peer.ID]peer.ID),
+
+			path:   i,
+			claims: claims,
+
+			traceHops: make(map[peer.ID]*LookupHop),
+		}
+		if trace != nil {
+			q.trace = &LookupTrace{ID: trace.ID, Key: trace.Key, Start: trace.Start}
+		}
+		q.queryPeers.SetScoreFunc(dht.nextHopScore)
+		queries[i] = q
+	}
+	// the seed peers are dealt round-robin in the order they are ranked, so that every path gets some of the best
+	for i, p := range seedPeers {
+		q := queries[i%paths]
+		q.seedPeers = append(q.seedPeers, p)
+		q.claim(p)
 	}
 
 	// run the query
-	q.run()
+	if paths == 1 {
+		queries[0].run()
+	} else {
+		var wg sync.WaitGroup
+		for _, q := range queries {
+			wg.Add(1)
+			go func(q *query) {
+				defer wg.Done()
+				q.run()
+			}(q)
+		}
+		wg.Wait()
+	}
 
 	if ctx.Err() == nil {
-		q.recordValuablePeers()
+		for _, q := range queries {
+			q.recordValuablePeers()
+		}
 	}
 
-	res := q.constructLookupResult(targetKadID)
+	res := constructLookupResult(queries, targetKadID)
+	if trace != nil {
+		mergeTraces(trace, queries)
+		trace.Duration = time.Since(trace.Start)
+		ev := NewLookupEvent(dht.self, id, target, nil, nil, nil)
+		ev.Trace = trace
+		PublishLookupEvent(ctx, ev)
+		res.trace = trace
+	}
 	return res, nil
 }
 
@@ -207,39 +287,49 @@ func (q *query) recordValuablePeers() {
 	}
 }
 
-// constructLookupResult takes the query information and uses it to construct the lookup result
-func (q *query) constructLookupResult(target kb.ID) *lookupWithFollowupResult {
-	// determine if the query terminated early
+// constructLookupResult takes the information of the queries of every path and uses it to construct the lookup result
+func constructLookupResult(queries []*query, target kb.ID) *lookupWithFollowupResult {
+	// determine if any path terminated early
 	completed := true
-
-	// Lookup and starvation are both valid ways for a lookup to complete. (Starvation does not imply failure.)
-	// Lookup termination (as defined in isLookupTermination) is not possible in small networks.
-	// Starvation is a successful query termination in small networks.
-	if(q.dht.isKadRTT){
-		if !(q.isLookupTerminationKadRTT(target) || q.isStarvationTermination()) {
-			completed = false
-		}
-	}else{
-		if !(q.isLookupTermination() || q.isStarvationTermination()) {
-			completed = false
+	for _, q := range queries {
+		// Lookup and starvation are both valid ways for a lookup to complete. (Starvation does not imply failure.)
+		// Lookup termination (as defined in isLookupTermination) is not possible in small networks.
+		// Starvation is a successful query termination in small networks.
+		if q.dht.isKadRTT {
+			if !(q.isLookupTerminationKadRTT(target) || q.isStarvationTermination()) {
+				completed = false
+			}
+		} else {
+			if !(q.isLookupTermination() || q.isStarvationTermination()) {
+				completed = false
+			}
 		}
 	}
 
-
-	// extract the top K not unreachable peers
+	// extract the top K not unreachable peers of every path
+	bucketSize := queries[0].dht.bucketSize
 	var peers []peer.ID
 	peerState := make(map[peer.ID]qpeerset.PeerState)
-	qp := q.queryPeers.GetClosestNInStates(q.dht.bucketSize, qpeerset.PeerHeard, qpeerset.PeerWaiting, qpeerset.PeerQueried)
-	for _, p := range qp {
-		state := q.queryPeers.GetState(p)
-		peerState[p] = state
-		peers = append(peers, p)
+	for _, q := range queries {
+		qp := q.queryPeers.GetClosestNInStates(bucketSize, qpeerset.PeerHeard, qpeerset.PeerWaiting, qpeerset.PeerQueried)
+		for _, p := range qp {
+			state := q.queryPeers.GetState(p)
+			// a peer only heard of by a path may have been queried by another one
+			if prev, ok := peerState[p]; ok {
+				if state > prev {
+					peerState[p] = state
+				}
+				continue
+			}
+			peerState[p] = state
+			peers = append(peers, p)
+		}
 	}
 
 	// get the top K overall peers
 	sortedPeers := kb.SortClosestPeers(peers, target)
-	if len(sortedPeers) > q.dht.bucketSize {
-		sortedPeers = sortedPeers[:q.dht.bucketSize]
+	if len(sortedPeers) > bucketSize {
+		sortedPeers = sortedPeers[:bucketSize]
 	}
 
 	// return the top K not unreachable peers as well as their states at the end of the query
@@ -269,33 +359,16 @@ func (q *query) run() {
 	pathCtx, cancelPath := context.WithCancel(q.ctx)
 	defer cancelPath()
 
-//Added by Kanemitsu.
-	targetKadID := kb.ConvertKey(q.key)
-
-	//seedPeers := q.dht.routingTable.NearestPeers(targetKadID, q.dht.bucketSize)
-	cpl := kb.CommonPrefixLen( q.dht.selfKey,targetKadID)
-
-	alpha := q.dht.alpha
-	//fmt.Println("Org Alpha: %d", alpha)
-	if(q.dht.isKadRTT){
-		rt := q.dht.RoutingTable()
-		if cpl >= len(rt.GetBuckets()) {
-			cpl = len(rt.GetBuckets()) - 1
-		}
-
-		//cpl_idx := math.Min(float64(len(rt.GetBuckets())-1), float64(cpl))
-
-		b := rt.GetBucket(cpl)
-		alpha = b.GetAlpha()
-		//
-		alpha = int(math.Max(float64(q.dht.alpha), float64(alpha)))
-	}
-//Kanemitsu END
-
-
-	ch := make(chan *queryUpdate, alpha)
+	// the channel must hold the updates of all the outstanding queries, which keep coming after termination.
+	maxAlpha := q.maxAlpha()
+	ch := make(chan *queryUpdate, maxAlpha)
 	ch <- &queryUpdate{cause: q.dht.self, heard: q.seedPeers}
 
+	// wake re-evaluates the concurrency when an outstanding query becomes a straggler.
+	wake := q.dht.clock.NewTimer(time.Hour)
+	wake.Stop()
+	defer wake.Stop()
+
 	// return only once all outstanding queries have completed.
 	defer q.waitGroup.Wait()
 	for {
@@ -304,17 +377,28 @@ func (q *query) run() {
 		case update := <-ch:
 			q.updateState(pathCtx, update)
 			cause = update.cause
+		case <-wake.C:
+			cause = q.dht.self
 		case <-pathCtx.Done():
 			q.terminate(pathCtx, cancelPath, LookupCancelled)
 		}
 
+		// the concurrency follows the lookup as it gets closer to the target.
+		alpha, due, next := q.stepAlpha(q.dht.clock.Now(), maxAlpha)
+		q.alpha = alpha
+		resetTimer(wake, next)
+
 		// calculate the maximum number of queries we could be spawning.
 		// Note: NumWaiting will be updated in spawnQuery
-		maxNumQueriesToSpawn := alpha - q.queryPeers.NumWaiting()
+		maxNumQueriesToSpawn := q.alpha - q.queryPeers.NumWaiting()
 
 		// termination is triggered on end-of-lookup conditions or starvation of unused peers
 		// it also returns the peers we should query next for a maximum of `maxNumQueriesToSpawn` peers.
 		ready, reason, qPeers := q.isReadyToTerminate(pathCtx, maxNumQueriesToSpawn)
+		// with disjoint paths, the peers another path queries are dropped and replaced
+		for !ready && q.dropClaimed(qPeers) {
+			ready, reason, qPeers = q.isReadyToTerminate(pathCtx, maxNumQueriesToSpawn)
+		}
 		if ready {
 			q.terminate(pathCtx, cancelPath, reason)
 		}
@@ -327,6 +411,16 @@ func (q *query) run() {
 		for _, p := range qPeers {
 			q.spawnQuery(pathCtx, cause, p, ch)
 		}
+
+		// the queries due for a hedge each freed a slot. The peers fill the slots that were free anyway
+		// first, so the ones beyond those are the hedges of the due queries, in order.
+		free := maxNumQueriesToSpawn - len(due)
+		if free < 0 {
+			free = 0
+		}
+		for i := free; i < len(qPeers) && i-free < len(due); i++ {
+			q.hedge(pathCtx, due[i-free], qPeers[i])
+		}
 	}
 }
 
@@ -344,12 +438,14 @@ func (q *query) spawnQuery(ctx context.Context, cause peer.ID, queryPeer peer.ID
 				[]peer.ID{queryPeer}, // waiting
 				nil,                  // queried
 				nil,                  // unreachable
-			),
+			).withAlpha(q.alpha),
 			nil,
 			nil,
 		),
 	)
 	q.queryPeers.SetState(queryPeer, qpeerset.PeerWaiting)
+	q.outstanding[queryPeer] = outstandingQuery{sent: q.dht.clock.Now(), hedgeAfter: q.dht.hedgeDelay(queryPeer)}
+	q.traceQuery(queryPeer)
 	q.waitGroup.Add(1)
 	go q.queryPeer(ctx, ch, queryPeer)
 }
@@ -366,6 +462,24 @@ func (q *query) isReadyToTerminate(ctx context.Context, nPeersToQuery int) (bool
 		return true, LookupCompleted, nil
 	}
 
+	// With a next hop scoring, the heard peers are queried by increasing score.
+	if q.dht.nextHopScore != nil {
+		if nPeersToQuery <= 0 {
+			return false, -1, nil
+		}
+		return false, -1, q.queryPeers.GetBestNInStates(nPeersToQuery, qpeerset.PeerHeard)
+	}
+
+	// With network coordinates, the closest heard peers are queried by increasing estimated RTT.
+	if q.dht.coords != nil {
+		if nPeersToQuery <= 0 {
+			return false, -1, nil
+		}
+		cpl := kb.CommonPrefixLen(q.dht.selfKey, kb.ConvertKey(q.key))
+		beta := int(math.Max(float64(q.dht.RoutingTable().BucketParamsForCpl(cpl).Beta), float64(q.dht.beta)))
+		return false, -1, q.closestHeardByRTT(int(math.Max(float64(beta), float64(nPeersToQuery))), nPeersToQuery)
+	}
+
 	// The peers we query next should be ones that we have only Heard about.
 	var peersToQuery []peer.ID
 	peers := q.queryPeers.GetClosestInStates(qpeerset.PeerHeard)
@@ -397,12 +511,8 @@ func (q *query) isLookupTermination() bool {
 func (q *query) isLookupTerminationKadRTT(targetKadID kb.ID) bool {
 
 	cpl := kb.CommonPrefixLen( q.dht.selfKey,targetKadID)
-	rt := q.dht.RoutingTable()
-	if cpl >= len(rt.GetBuckets()) {
-		cpl = len(rt.GetBuckets()) - 1
-	}
-	b := rt.GetBucket(cpl)
-	peers := q.queryPeers.GetClosestNInStates(int(math.Max(float64(b.GetBeta()),float64(q.dht.beta))), qpeerset.PeerHeard, qpeerset.PeerWaiting, qpeerset.PeerQueried)
+	beta := q.dht.RoutingTable().BucketParamsForCpl(cpl).Beta
+	peers := q.queryPeers.GetClosestNInStates(int(math.Max(float64(beta),float64(q.dht.beta))), qpeerset.PeerHeard, qpeerset.PeerWaiting, qpeerset.PeerQueried)
 	for _, p := range peers {
 		if q.queryPeers.GetState(p) != qpeerset.PeerQueried {
 			return false
@@ -434,12 +544,18 @@ func (q *query) terminate(ctx context.Context, cancel context.CancelFunc, reason
 	)
 	cancel() // abort outstanding queries
 	q.terminated = true
+	q.traceEnd(reason)
 }
 
 // queryPeer queries a single peer and reports its findings on the channel.
 // queryPeer does not access the query state in queryPeers!
 func (q *query) queryPeer(ctx context.Context, ch chan<- *queryUpdate, p peer.ID) {
 	defer q.waitGroup.Done()
+	if timeout := q.dht.peerTimeout(p); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 	dialCtx, queryCtx := ctx, ctx
 
 	startQuery := time.Now()
@@ -520,6 +636,9 @@ func (q *query) updateState(ctx context.Context, up *queryUpdate) {
 		if p == q.dht.self { // don't add self.
 			continue
 		}
+		if q.claimedByOther(p) { // another disjoint path queries it.
+			continue
+		}
 		q.queryPeers.TryAdd(p, up.cause)
 	}
 	for _, p := range up.queried {
@@ -529,6 +648,9 @@ func (q *query) updateState(ctx context.Context, up *queryUpdate) {
 		if st := q.queryPeers.GetState(p); st == qpeerset.PeerWaiting {
 			q.queryPeers.SetState(p, qpeerset.PeerQueried)
 			q.peerTimes[p] = up.queryDuration
+			q.hedgeDone(ctx, p, true)
+			q.traceResponse(p, qpeerset.PeerQueried, up.queryDuration)
+			q.doneWaiting(p)
 		} else {
 			panic(fmt.Errorf("kademlia protocol error: tried to transition to the queried state from state %v", st))
 		}
@@ -540,6 +662,9 @@ func (q *query) updateState(ctx context.Context, up *queryUpdate) {
 
 		if st := q.queryPeers.GetState(p); st == qpeerset.PeerWaiting {
 			q.queryPeers.SetState(p, qpeerset.PeerUnreachable)
+			q.hedgeDone(ctx, p, false)
+			q.traceResponse(p, qpeerset.PeerUnreachable, 0)
+			q.doneWaiting(p)
 		} else {
 			panic(fmt.Errorf("kademlia protocol error: tried to transition to the unreachable state from state %v", st))
 		}
@@ -0,0 +1,70 @@
+package dht
+
+import (
+	"encoding/json"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+
+	ds "github.com/ipfs/go-datastore"
+)
+
+// rtCheckpointKey is the datastore key the routing table is saved under.
+var rtCheckpointKey = ds.NewKey("/routing-table/checkpoint")
+
+// rtCheckpoint is the routing table as saved in the datastore, along with the addresses of its peers,
+// without which they couldn't be dialed after a restart.
+type rtCheckpoint struct {
+	Table *kb.Checkpoint  `json:"table"`
+	Addrs []peer.AddrInfo `json:"addrs"`
+}
+
+// saveRoutingTable saves the routing table to the datastore.
+func (dht *IpfsDHT) saveRoutingTable() error {
+	cp := rtCheckpoint{Table: dht.routingTable.Checkpoint()}
+	for _, p := range cp.Table.Peers {
+		if addrs := dht.peerstore.Addrs(p.Id); len(addrs) > 0 {
+			cp.Addrs = append(cp.Addrs, peer.AddrInfo{ID: p.Id, Addrs: addrs})
+		}
+	}
+
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	if err := dht.datastore.Put(rtCheckpointKey, b); err != nil {
+		return err
+	}
+	return dht.datastore.Sync(rtCheckpointKey)
+}
+
+// restoreRoutingTable warm-starts the routing table from the checkpoint saved in the datastore, if any.
+// Restored peers that turn out to be gone are evicted by the routing table refresh like any other.
+func (dht *IpfsDHT) restoreRoutingTable() error {
+	b, err := dht.datastore.Get(rtCheckpointKey)
+	if err == ds.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var cp rtCheckpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return err
+	}
+	if cp.Table == nil {
+		return nil
+	}
+
+	for _, ai := range cp.Addrs {
+		dht.peerstore.AddAddrs(ai.ID, ai.Addrs, peerstore.RecentlyConnectedAddrTTL)
+	}
+	n, err := dht.routingTable.Restore(cp.Table)
+	if err != nil {
+		return err
+	}
+	logger.Infow("restored routing table", "peers", n, "saved", cp.Table.Time)
+	return nil
+}
@@ -0,0 +1,55 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	swarmt "github.com/libp2p/go-libp2p-swarm/testing"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutingTablePersistence(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	opts := []Option{
+		testPrefix,
+		Mode(ModeServer),
+		NamespacedValidator("v", blankValidator{}),
+		DisableAutoRefresh(),
+		Datastore(dstore),
+		RoutingTablePersistence(true),
+	}
+
+	dhtA, err := New(ctx, bhost.New(swarmt.GenSwarm(t, ctx, swarmt.OptDisableReuseport)), opts...)
+	require.NoError(t, err)
+	dhtB := setupDHT(ctx, t, false)
+	defer dhtB.Close()
+
+	connect(t, ctx, dhtA, dhtB)
+	dhtA.routingTable.SetRTT(dhtB.self, 42*time.Millisecond)
+	require.NoError(t, dhtA.Close())
+
+	// a node started on the same datastore knows B, its address and its RTT right away
+	dhtA2, err := New(ctx, bhost.New(swarmt.GenSwarm(t, ctx, swarmt.OptDisableReuseport)), opts...)
+	require.NoError(t, err)
+	defer dhtA2.Close()
+
+	require.Equal(t, dhtB.self, dhtA2.routingTable.Find(dhtB.self))
+	require.NotEmpty(t, dhtA2.peerstore.Addrs(dhtB.self))
+	stats, ok := dhtA2.routingTable.RTTStats(dhtB.self)
+	require.True(t, ok)
+	require.NotZero(t, stats.Samples())
+
+	// without persistence, the table starts empty
+	dhtC, err := New(ctx, bhost.New(swarmt.GenSwarm(t, ctx, swarmt.OptDisableReuseport)), opts[:len(opts)-1]...)
+	require.NoError(t, err)
+	defer dhtC.Close()
+	require.Zero(t, dhtC.routingTable.Size())
+}
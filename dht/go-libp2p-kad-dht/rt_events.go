@@ -0,0 +1,95 @@
+package dht
+
+import (
+	"context"
+	"sync"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+// RoutingTableEvent is emitted for every change of the routing table of a DHT.
+// RoutingTableEvent supports JSON marshalling because all of its fields do, recursively.
+type RoutingTableEvent struct {
+	// Node is the ID of the node owning the routing table.
+	Node *PeerKadID
+	// Event describes the change.
+	Event kb.Event
+}
+
+type routingTableEventsKey struct{}
+
+// rtEventChannel is the routing table counterpart of lookupEventChannel.
+type rtEventChannel struct {
+	mu  sync.Mutex
+	ctx context.Context
+	ch  chan<- *RoutingTableEvent
+}
+
+// waitThenClose is spawned in a goroutine when the channel is registered. This
+// safely cleans up the channel when the context has been canceled.
+func (e *rtEventChannel) waitThenClose() {
+	<-e.ctx.Done()
+	e.mu.Lock()
+	close(e.ch)
+	e.ch = nil
+	e.mu.Unlock()
+}
+
+// send sends an event on the event channel, aborting if the context expires.
+func (e *rtEventChannel) send(ev *RoutingTableEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// Closed.
+	if e.ch == nil {
+		return
+	}
+	select {
+	case e.ch <- ev:
+	case <-e.ctx.Done():
+	}
+}
+
+// RegisterForRoutingTableEvents registers a routing table event channel with the given context.
+// The routing tables of the DHTs created with the returned context send all their events,
+// from the moment the DHT is created, on the returned channel.
+//
+// The passed context MUST be canceled when the caller is no longer interested
+// in routing table events.
+func RegisterForRoutingTableEvents(ctx context.Context) (context.Context, <-chan *RoutingTableEvent) {
+	ch := make(chan *RoutingTableEvent, RoutingTableEventBufferSize)
+	ech := &rtEventChannel{ch: ch, ctx: ctx}
+	go ech.waitThenClose()
+	return context.WithValue(ctx, routingTableEventsKey{}, ech), ch
+}
+
+// RoutingTableEventBufferSize is the number of events to buffer.
+var RoutingTableEventBufferSize = 16
+
+// forwardRoutingTableEvents sends the events of the routing table to the channel registered
+// with the context the DHT was created with, if any.
+func (dht *IpfsDHT) forwardRoutingTableEvents(ctx context.Context) {
+	ich := ctx.Value(routingTableEventsKey{})
+	if ich == nil {
+		return
+	}
+	ech := ich.(*rtEventChannel)
+
+	sub := dht.routingTable.Subscribe()
+	node := NewPeerKadID(dht.self)
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case e, ok := <-sub.Events():
+				if !ok {
+					return
+				}
+				ech.send(&RoutingTableEvent{Node: node, Event: e})
+			case <-ech.ctx.Done():
+				return
+			case <-dht.ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,45 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutingTableEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ectx, events := RegisterForRoutingTableEvents(ctx)
+	dhtA := setupDHT(ectx, t, false)
+	defer dhtA.Close()
+	dhtB := setupDHT(ctx, t, false)
+	defer dhtB.Close()
+
+	connect(t, ctx, dhtA, dhtB)
+
+	select {
+	case e := <-events:
+		require.Equal(t, dhtA.self, e.Node.Peer)
+		require.Equal(t, kb.EventPeerAdded, e.Event.Type)
+		require.Equal(t, dhtB.self, e.Event.Peer)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no routing table event")
+	}
+
+	// B wasn't created with the registered context
+	dhtB.routingTable.RemovePeer(dhtA.self)
+	dhtA.routingTable.RemovePeer(dhtB.self)
+	e := <-events
+	require.Equal(t, kb.EventPeerRemoved, e.Event.Type)
+	require.Equal(t, dhtB.self, e.Event.Peer)
+
+	// the channel is closed with the context
+	cancel()
+	for range events {
+	}
+}
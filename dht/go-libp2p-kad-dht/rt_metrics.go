@@ -0,0 +1,103 @@
+package dht
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/jbenet/goprocess"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+
+	"github.com/libp2p/go-libp2p-kad-dht/metrics"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+// rtMetricsInterval is the interval at which the routing table gauges are recorded.
+const rtMetricsInterval = 10 * time.Second
+
+// rtMetricsLoop records the routing table measures: the evictions as they happen, and the size,
+// the KadRTT parameters and the RTTs of the buckets every rtMetricsInterval and whenever KadRTT
+// changes the parameters of a bucket. The interval is timed with the clock of the DHT.
+func (dht *IpfsDHT) rtMetricsLoop(proc goprocess.Process) {
+	sub := dht.routingTable.Subscribe()
+	defer sub.Close()
+
+	ticker := dht.clock.NewTicker(rtMetricsInterval)
+	defer ticker.Stop()
+
+	ctx := dht.newContextWithLocalTags(dht.ctx)
+	cpls := dht.recordRoutingTableGauges(ctx, 0)
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			recordRoutingTableEvent(ctx, e)
+			if e.Type != kb.EventParamsChanged {
+				continue
+			}
+		case <-ticker.C:
+		case <-proc.Closing():
+			return
+		}
+		cpls = dht.recordRoutingTableGauges(ctx, cpls)
+	}
+}
+
+// recordRoutingTableEvent records the eviction measure for the events of peers leaving the table.
+func recordRoutingTableEvent(ctx context.Context, e kb.Event) {
+	var reason string
+	switch e.Type {
+	case kb.EventPeerRemoved:
+		reason = "removed"
+	case kb.EventPeerEvicted:
+		reason = "id-variance"
+	case kb.EventPeerReplaced:
+		reason = "replaced"
+	default:
+		return
+	}
+	_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyEvictionReason, reason)},
+		metrics.RoutingTableEvictions.M(1))
+}
+
+// recordRoutingTableGauges records the size and the RTTs of the table per CPL, the parameters of its
+// buckets and the KadRTT adaptation stats. prevCpls is the number of CPLs whose size was recorded the
+// previous time, which is reset to 0 for the CPLs the table no longer has; the number of CPLs recorded
+// this time is returned.
+func (dht *IpfsDHT) recordRoutingTableGauges(ctx context.Context, prevCpls int) int {
+	health := dht.routingTable.Health(nil)
+	snap := dht.routingTable.Snapshot()
+
+	cpls := len(health.Cpls)
+	for cpl := 0; cpl < cpls || cpl < prevCpls; cpl++ {
+		var h kb.CplHealth
+		if cpl < cpls {
+			h = health.Cpls[cpl]
+		}
+		_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyCpl, strconv.Itoa(cpl))},
+			metrics.RoutingTableSize.M(int64(h.Actual)),
+			metrics.BucketMeanRTT.M(float64(h.RTT.Mean)/float64(time.Millisecond)),
+			metrics.BucketP95RTT.M(float64(h.RTT.P95)/float64(time.Millisecond)),
+		)
+	}
+
+	// the last bucket serves all the longer CPLs
+	for _, b := range snap.Buckets {
+		_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.KeyCpl, strconv.Itoa(b.Cpl))},
+			metrics.BucketK.M(int64(b.K)),
+			metrics.BucketAlpha.M(int64(b.Alpha)),
+			metrics.BucketBeta.M(int64(b.Beta)),
+		)
+	}
+
+	if snap.KadRTT {
+		stats.Record(ctx,
+			metrics.KadRTTArrivalRate.M(snap.Stats.StoreRate),
+			metrics.KadRTTExchangeProbability.M(snap.Stats.ExchangeProbability),
+		)
+	}
+	return cpls
+}
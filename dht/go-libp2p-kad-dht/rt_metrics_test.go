@@ -0,0 +1,80 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	"github.com/libp2p/go-libp2p-kad-dht/metrics"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p-kbucket/clock"
+
+	"github.com/stretchr/testify/require"
+)
+
+// rowsOf returns the rows of the view recorded by the DHT, keyed by the value of the given tag.
+func rowsOf(t *testing.T, v *view.View, dht *IpfsDHT, key tag.Key) map[string]view.AggregationData {
+	rows, err := view.RetrieveData(v.Name)
+	require.NoError(t, err)
+
+	instance := fmt.Sprintf("%p", dht)
+	data := make(map[string]view.AggregationData)
+	for _, r := range rows {
+		var value string
+		mine := false
+		for _, tg := range r.Tags {
+			switch tg.Key {
+			case metrics.KeyInstanceID:
+				mine = tg.Value == instance
+			case key:
+				value = tg.Value
+			}
+		}
+		if mine {
+			data[value] = r.Data
+		}
+	}
+	return data
+}
+
+func TestRoutingTableMetrics(t *testing.T) {
+	require.NoError(t, view.Register(metrics.DefaultViews...))
+	defer view.Unregister(metrics.DefaultViews...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := clock.NewMock()
+	dhtA := setupDHT(ctx, t, false, Clock(clk))
+	defer dhtA.Close()
+	dhtB := setupDHT(ctx, t, false)
+	defer dhtB.Close()
+
+	connect(t, ctx, dhtA, dhtB)
+	cpl := fmt.Sprint(kb.CommonPrefixLen(dhtA.selfKey, kb.ConvertPeerID(dhtB.self)))
+
+	// the gauges are recorded when the clock ticks
+	require.Eventually(t, func() bool {
+		size, ok := rowsOf(t, metrics.RoutingTableSizeView, dhtA, metrics.KeyCpl)[cpl]
+		if ok && size.(*view.LastValueData).Value == 1 {
+			return true
+		}
+		clk.Add(rtMetricsInterval)
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+	k := rowsOf(t, metrics.BucketKView, dhtA, metrics.KeyCpl)
+	require.NotEmpty(t, k)
+	for _, d := range k {
+		require.Equal(t, float64(dhtA.bucketSize), d.(*view.LastValueData).Value)
+	}
+
+	dhtA.routingTable.RemovePeer(dhtB.self)
+	require.Eventually(t, func() bool {
+		evictions, ok := rowsOf(t, metrics.RoutingTableEvictionsView, dhtA, metrics.KeyEvictionReason)["removed"]
+		return ok && evictions.(*view.CountData).Value >= 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 
 	kbucket "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p-kbucket/clock"
 
 	logging "github.com/ipfs/go-log"
 )
@@ -52,6 +53,23 @@ type RtRefreshManager struct {
 	triggerRefresh chan *triggerRefreshReq // channel to write refresh requests to.
 
 	refreshDoneCh chan struct{} // write to this channel after every refresh
+
+	clock clock.Clock // times the periodic refreshes and the liveliness checks
+}
+
+// Option is a function that sets a refresh manager option.
+type Option func(*RtRefreshManager) error
+
+// Clock sets the clock the periodic refreshes and the liveliness checks are timed with.
+// Defaults to the wall clock.
+func Clock(c clock.Clock) Option {
+	return func(r *RtRefreshManager) error {
+		if c == nil {
+			return fmt.Errorf("clock must not be nil")
+		}
+		r.clock = c
+		return nil
+	}
 }
 
 func NewRtRefreshManager(h host.Host, rt *kbucket.RoutingTable, autoRefresh bool,
@@ -60,10 +78,11 @@ func NewRtRefreshManager(h host.Host, rt *kbucket.RoutingTable, autoRefresh bool
 	refreshQueryTimeout time.Duration,
 	refreshInterval time.Duration,
 	successfulOutboundQueryGracePeriod time.Duration,
-	refreshDoneCh chan struct{}) (*RtRefreshManager, error) {
+	refreshDoneCh chan struct{},
+	opts ...Option) (*RtRefreshManager, error) {
 
 	ctx, cancel := context.WithCancel(context.Background())
-	return &RtRefreshManager{
+	r := &RtRefreshManager{
 		ctx:       ctx,
 		cancel:    cancel,
 		h:         h,
@@ -80,7 +99,16 @@ func NewRtRefreshManager(h host.Host, rt *kbucket.RoutingTable, autoRefresh bool
 
 		triggerRefresh: make(chan *triggerRefreshReq),
 		refreshDoneCh:  refreshDoneCh,
-	}, nil
+
+		clock: clock.New(),
+	}
+	for i, opt := range opts {
+		if err := opt(r); err != nil {
+			cancel()
+			return nil, fmt.Errorf("refresh manager option %d failed: %s", i, err)
+		}
+	}
+	return r, nil
 }
 
 func (r *RtRefreshManager) Start() error {
@@ -132,7 +160,7 @@ func (r *RtRefreshManager) loop() {
 		if err != nil {
 			logger.Warn("failed when refreshing routing table", err)
 		}
-		t := time.NewTicker(r.refreshInterval)
+		t := r.clock.NewTicker(r.refreshInterval)
 		defer t.Stop()
 		refreshTickrCh = t.C
 	}
@@ -171,7 +199,7 @@ func (r *RtRefreshManager) loop() {
 		// and evict them if they don't reply.
 		var wg sync.WaitGroup
 		for _, ps := range r.rt.GetPeerInfos() {
-			if time.Since(ps.LastSuccessfulOutboundQueryAt) > r.successfulOutboundQueryGracePeriod {
+			if r.clock.Since(ps.LastSuccessfulOutboundQueryAt) > r.successfulOutboundQueryGracePeriod {
 				wg.Add(1)
 				go func(ps kbucket.PeerInfo) {
 					defer wg.Done()
@@ -258,7 +286,7 @@ func min(a int, b int) int {
 }
 
 func (r *RtRefreshManager) refreshCplIfEligible(cpl uint, lastRefreshedAt time.Time) error {
-	if time.Since(lastRefreshedAt) <= r.refreshInterval {
+	if r.clock.Since(lastRefreshedAt) <= r.refreshInterval {
 		logger.Debugf("not running refresh for cpl %d as time since last refresh not above interval", cpl)
 		return nil
 	}
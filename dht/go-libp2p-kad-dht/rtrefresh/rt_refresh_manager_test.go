@@ -9,6 +9,7 @@ import (
 	"github.com/libp2p/go-libp2p-core/test"
 
 	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p-kbucket/clock"
 	pstore "github.com/libp2p/go-libp2p-peerstore"
 
 	"github.com/stretchr/testify/require"
@@ -98,3 +99,94 @@ func TestSkipRefreshOnGapCpls(t *testing.T) {
 	}
 	require.Equal(t, 2, rt.NPeersForCpl(10))
 }
+
+func TestPeriodicRefreshWithMockClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	local := test.RandPeerIDFatal(t)
+	clk := clock.NewMock()
+
+	rt, err := kb.NewRoutingTable(2, kb.ConvertPeerID(local), time.Hour, pstore.NewMetrics(), 100*time.Hour, nil, kb.Clock(clk))
+	require.NoError(t, err)
+	// a refresh stops at the first empty cpl without reporting
+	p, err := rt.GenRandPeerID(0)
+	require.NoError(t, err)
+	b, err := rt.TryAddPeer(p, true, false)
+	require.NoError(t, err)
+	require.True(t, b)
+
+	// the queries mark the cpl of their key as refreshed, as lookups do
+	queries := make(chan string, 16)
+	r := &RtRefreshManager{
+		ctx:               ctx,
+		cancel:            cancel,
+		rt:                rt,
+		dhtPeerId:         local,
+		enableAutoRefresh: true,
+		refreshKeyGenFnc: func(cpl uint) (string, error) {
+			p, err := rt.GenRandPeerID(cpl)
+			return string(p), err
+		},
+		refreshQueryFnc: func(_ context.Context, key string) error {
+			rt.ResetCplRefreshedAtForID(kb.ConvertKey(key), clk.Now())
+			queries <- key
+			return nil
+		},
+		refreshQueryTimeout:                time.Minute,
+		refreshInterval:                    10 * time.Minute,
+		successfulOutboundQueryGracePeriod: time.Hour,
+		triggerRefresh:                     make(chan *triggerRefreshReq),
+		refreshDoneCh:                      make(chan struct{}),
+	}
+	require.NoError(t, Clock(clk)(r))
+	require.Error(t, Clock(nil)(r))
+
+	// waitRefresh returns the number of queries of the next refresh, and whether the cpl 0 was refreshed.
+	waitRefresh := func() (int, bool) {
+		select {
+		case <-r.refreshDoneCh:
+		case <-time.After(5 * time.Second):
+			t.Fatal("refresh didn't happen")
+		}
+		n, cpl0 := 0, false
+		for {
+			select {
+			case key := <-queries:
+				n++
+				cpl0 = cpl0 || key != string(local)
+			default:
+				return n, cpl0
+			}
+		}
+	}
+	noRefresh := func() {
+		select {
+		case <-r.refreshDoneCh:
+			t.Fatal("unexpected refresh")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	require.NoError(t, r.Start())
+	defer r.Close()
+
+	// the first refresh is forced, and the periodic ones are timed from its end
+	n, cpl0 := waitRefresh()
+	require.Equal(t, 2, n)
+	require.True(t, cpl0)
+	clk.BlockUntil(1)
+
+	clk.Add(9 * time.Minute)
+	noRefresh()
+
+	// one interval after its refresh, the cpl isn't due yet, only self is queried
+	clk.Add(time.Minute)
+	n, cpl0 = waitRefresh()
+	require.Equal(t, 1, n)
+	require.False(t, cpl0)
+
+	// two intervals after, it is
+	clk.Add(10 * time.Minute)
+	n, cpl0 = waitRefresh()
+	require.Equal(t, 2, n)
+	require.True(t, cpl0)
+}
@@ -0,0 +1,175 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+)
+
+// ErrRTTUnknown is returned by an RTTProvider that has no RTT for a peer.
+var ErrRTTUnknown = errors.New("rtt unknown")
+
+// RTTProvider is a source of round-trip times to peers. The DHT asks it for the RTT of every peer
+// it tries to add to a KadRTT routing table, and the routing table admits and ranks peers by it.
+//
+// RTT may measure the RTT on the spot or look up a previous measurement. If it has no RTT for the peer,
+// it returns ErrRTTUnknown or the error that prevented the measurement, and the DHT uses the peerstore
+// latency of the peer instead.
+type RTTProvider interface {
+	RTT(ctx context.Context, dht *IpfsDHT, p peer.ID) (time.Duration, error)
+}
+
+// PingPongRTT measures the RTT as the time it takes to read the one byte response of the
+// /pingpong protocol, which KadRTT DHTs serve.
+type PingPongRTT struct{}
+
+var _ RTTProvider = PingPongRTT{}
+
+// RTT implements RTTProvider.
+func (PingPongRTT) RTT(ctx context.Context, dht *IpfsDHT, p peer.ID) (time.Duration, error) {
+	start := time.Now()
+	if err := playPingPong(ctx, dht.host, p); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// DHTPingRTT measures the RTT as the time it takes to answer a DHT PING message.
+type DHTPingRTT struct{}
+
+var _ RTTProvider = DHTPingRTT{}
+
+// RTT implements RTTProvider.
+func (DHTPingRTT) RTT(ctx context.Context, dht *IpfsDHT, p peer.ID) (time.Duration, error) {
+	start := time.Now()
+	resp, err := dht.sendRequest(ctx, p, pb.NewMessage(pb.Message_PING, nil, 0))
+	if err != nil {
+		return 0, err
+	}
+	if resp.Type != pb.Message_PING {
+		return 0, fmt.Errorf("got unexpected response type: %v", resp.Type)
+	}
+	return time.Since(start), nil
+}
+
+// DialRTT returns the time it took to connect to the peer when the DHT last dialed it.
+// Peers that dialed us, or that we were already connected to, have no such RTT.
+type DialRTT struct{}
+
+var _ RTTProvider = DialRTT{}
+
+// RTT implements RTTProvider.
+func (DialRTT) RTT(_ context.Context, dht *IpfsDHT, p peer.ID) (time.Duration, error) {
+	dht.smlk.Lock()
+	defer dht.smlk.Unlock()
+
+	if dur, ok := dht.rttMap[p]; ok && dur > 0 {
+		return dur, nil
+	}
+	return 0, ErrRTTUnknown
+}
+
+// PeerstoreRTT returns the latency EWMA the peerstore keeps for the peer.
+type PeerstoreRTT struct{}
+
+var _ RTTProvider = PeerstoreRTT{}
+
+// RTT implements RTTProvider.
+func (PeerstoreRTT) RTT(_ context.Context, dht *IpfsDHT, p peer.ID) (time.Duration, error) {
+	if dur := dht.peerstore.LatencyEWMA(p); dur > 0 {
+		return dur, nil
+	}
+	return 0, ErrRTTUnknown
+}
+
+// RTTMerge selects how a CompositeRTT merges the RTTs of its providers.
+type RTTMerge int
+
+const (
+	// RTTMergeFirst returns the RTT of the first provider that has one; the following providers
+	// are not asked.
+	RTTMergeFirst RTTMerge = iota
+	// RTTMergeMin returns the lowest RTT of all the providers.
+	RTTMergeMin
+	// RTTMergeMedian returns the median RTT of all the providers, the lower one if there is an even number of them.
+	RTTMergeMedian
+)
+
+func (m RTTMerge) String() string {
+	switch m {
+	case RTTMergeFirst:
+		return "first"
+	case RTTMergeMin:
+		return "min"
+	case RTTMergeMedian:
+		return "median"
+	default:
+		return "unknown"
+	}
+}
+
+// CompositeRTT merges the RTTs of several providers, asked in order.
+// Providers failing for a peer are skipped; it returns the last error if none of them has an RTT.
+type CompositeRTT struct {
+	Providers []RTTProvider
+	Merge     RTTMerge
+}
+
+var _ RTTProvider = CompositeRTT{}
+
+// RTT implements RTTProvider.
+func (c CompositeRTT) RTT(ctx context.Context, dht *IpfsDHT, p peer.ID) (time.Duration, error) {
+	err := ErrRTTUnknown
+	var rtts []time.Duration
+	for _, prov := range c.Providers {
+		rtt, perr := prov.RTT(ctx, dht, p)
+		if perr != nil {
+			err = perr
+			continue
+		}
+		if c.Merge == RTTMergeFirst {
+			return rtt, nil
+		}
+		rtts = append(rtts, rtt)
+	}
+	if len(rtts) == 0 {
+		return 0, err
+	}
+
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	if c.Merge == RTTMergeMedian {
+		return rtts[(len(rtts)-1)/2], nil
+	}
+	return rtts[0], nil
+}
+
+// RTTProviderByName returns the provider with the given name: "pingpong", "ping", "dial", "peerstore",
+// "composite", which asks the dial time and the peerstore before measuring with /pingpong,
+// "vivaldi", which measures with a coordinate exchange, or "coordinates", which estimates from the network coordinates.
+// It is meant for configuring experiments from strings.
+func RTTProviderByName(name string) (RTTProvider, error) {
+	switch name {
+	case "pingpong":
+		return PingPongRTT{}, nil
+	case "ping":
+		return DHTPingRTT{}, nil
+	case "dial":
+		return DialRTT{}, nil
+	case "peerstore":
+		return PeerstoreRTT{}, nil
+	case "composite":
+		return CompositeRTT{Providers: []RTTProvider{DialRTT{}, PeerstoreRTT{}, PingPongRTT{}}}, nil
+	case "vivaldi":
+		return VivaldiRTT{}, nil
+	case "coordinates":
+		return CoordinateRTT{}, nil
+	default:
+		return nil, fmt.Errorf("unknown rtt provider %q", name)
+	}
+}
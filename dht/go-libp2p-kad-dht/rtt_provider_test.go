@@ -0,0 +1,110 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fixedRTT struct {
+	rtt time.Duration
+	err error
+}
+
+func (f fixedRTT) RTT(context.Context, *IpfsDHT, peer.ID) (time.Duration, error) {
+	return f.rtt, f.err
+}
+
+func TestRTTProviders(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dhtA := setupDHT(ctx, t, false, IsKadRTT(true))
+	defer dhtA.Close()
+	dhtB := setupDHT(ctx, t, false, IsKadRTT(true))
+	defer dhtB.Close()
+	connect(t, ctx, dhtA, dhtB)
+
+	for _, name := range []string{"pingpong", "ping", "composite"} {
+		prov, err := RTTProviderByName(name)
+		require.NoError(t, err)
+		rtt, err := prov.RTT(ctx, dhtA, dhtB.self)
+		require.NoError(t, err, name)
+		require.NotZero(t, rtt, name)
+	}
+	require.NotZero(t, dhtA.KadRTTPing(ctx, dhtB.self))
+
+	// we didn't dial B through the DHT
+	_, err := DialRTT{}.RTT(ctx, dhtA, dhtB.self)
+	require.Equal(t, ErrRTTUnknown, err)
+	dhtA.smlk.Lock()
+	dhtA.rttMap[dhtB.self] = 5 * time.Millisecond
+	dhtA.smlk.Unlock()
+	rtt, err := DialRTT{}.RTT(ctx, dhtA, dhtB.self)
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Millisecond, rtt)
+
+	// the pings above already fed the latency of B to the peerstore, so use a peer it knows nothing about
+	other := test.RandPeerIDFatal(t)
+	_, err = PeerstoreRTT{}.RTT(ctx, dhtA, other)
+	require.Equal(t, ErrRTTUnknown, err)
+	dhtA.peerstore.RecordLatency(other, 7*time.Millisecond)
+	rtt, err = PeerstoreRTT{}.RTT(ctx, dhtA, other)
+	require.NoError(t, err)
+	require.Equal(t, 7*time.Millisecond, rtt)
+
+	_, err = RTTProviderByName("carrier-pigeon")
+	require.Error(t, err)
+}
+
+func TestCompositeRTT(t *testing.T) {
+	ctx := context.Background()
+	failed := errors.New("failed")
+	providers := []RTTProvider{
+		fixedRTT{err: failed},
+		fixedRTT{rtt: 30 * time.Millisecond},
+		fixedRTT{rtt: 10 * time.Millisecond},
+		fixedRTT{rtt: 20 * time.Millisecond},
+	}
+
+	for merge, expected := range map[RTTMerge]time.Duration{
+		RTTMergeFirst:  30 * time.Millisecond,
+		RTTMergeMin:    10 * time.Millisecond,
+		RTTMergeMedian: 20 * time.Millisecond,
+	} {
+		rtt, err := CompositeRTT{Providers: providers, Merge: merge}.RTT(ctx, nil, "")
+		require.NoError(t, err, merge)
+		require.Equal(t, expected, rtt, merge)
+	}
+
+	_, err := CompositeRTT{Providers: providers[:1], Merge: RTTMergeMin}.RTT(ctx, nil, "")
+	require.Equal(t, failed, err)
+	_, err = CompositeRTT{}.RTT(ctx, nil, "")
+	require.Equal(t, ErrRTTUnknown, err)
+}
+
+func TestKadRTTRTTProviderOption(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := setupDHT(ctx, t, false, IsKadRTT(true), KadRTT_RTTProvider(fixedRTT{rtt: 42 * time.Millisecond}))
+	defer d.Close()
+	other := setupDHT(ctx, t, false, IsKadRTT(true))
+	defer other.Close()
+	connect(t, ctx, d, other)
+
+	// peers are added with the RTT of the configured provider
+	require.Eventually(t, func() bool {
+		stats, ok := d.routingTable.RTTStats(other.self)
+		return ok && stats.EWMA() == 42*time.Millisecond
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err := New(ctx, d.host, KadRTT_RTTProvider(nil))
+	require.Error(t, err)
+}
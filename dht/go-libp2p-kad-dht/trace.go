@@ -0,0 +1,144 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+)
+
+// LookupTrace is the path a lookup took: every peer it queried, and how it ended.
+// The queries of the follow-up of a lookup aren't part of it.
+// LookupTrace supports JSON marshalling because all of its fields do, recursively.
+type LookupTrace struct {
+	// ID is the unique identifier of the lookup instance, as in its lookup events.
+	ID uuid.UUID
+	// Key is the target of the lookup.
+	Key *KeyKadID
+	// Start is when the lookup started.
+	Start time.Time
+	// Duration is how long the lookup took, until its last query returned.
+	Duration time.Duration
+	// Hops are the queries of the lookup, in the order they were sent.
+	Hops []*LookupHop
+	// Termination is the reason the lookup ended. With disjoint paths, it is the first of stopped, cancelled,
+	// starvation and completed any path ended with.
+	Termination LookupTerminationReason
+}
+
+// LookupHop describes a query of a lookup.
+type LookupHop struct {
+	// Peer is the queried peer.
+	Peer *PeerKadID
+	// ReferredBy is the peer that told the lookup about Peer, nil for the peers the lookup started from.
+	ReferredBy *PeerKadID
+	// Depth is the number of hops from the local node to Peer: 1 for the peers the lookup started from,
+	// and one more than the depth of ReferredBy for the others.
+	Depth int
+	// RTT is the RTT to Peer known when it was queried, 0 if it was unknown.
+	RTT time.Duration
+	// Sent is when Peer was queried, since the start of the lookup.
+	Sent time.Duration
+	// Duration is how long the query took, dial included, 0 if the lookup ended first.
+	Duration time.Duration
+	// State is the state of Peer when the lookup ended: queried, unreachable, or waiting if the lookup
+	// ended first.
+	State qpeerset.PeerState
+	// Alpha is the concurrency of the lookup when Peer was queried.
+	Alpha int
+	// Hedge is true if the query hedged a slower one.
+	Hedge bool
+	// Path is the index of the disjoint path that queried Peer, 0 if the lookup takes a single path.
+	Path int
+}
+
+// Depth returns the number of hops of the lookup: the depth of its deepest answered query.
+func (t *LookupTrace) Depth() int {
+	depth := 0
+	for _, h := range t.Hops {
+		if h.State == qpeerset.PeerQueried && h.Depth > depth {
+			depth = h.Depth
+		}
+	}
+	return depth
+}
+
+func (t *LookupTrace) String() string {
+	return fmt.Sprintf("lookup %s: %d queries, %d hops in %s, %s", t.ID, len(t.Hops), t.Depth(), t.Duration, t.Termination)
+}
+
+type lookupTraceKey struct{}
+
+// withLookupTrace returns a context whose lookups are traced whether or not LookupTracing is enabled.
+func withLookupTrace(ctx context.Context) context.Context {
+	return context.WithValue(ctx, lookupTraceKey{}, struct{}{})
+}
+
+// newLookupTrace returns the trace of the lookup of the query, or nil if it isn't traced.
+func (dht *IpfsDHT) newLookupTrace(ctx context.Context, id uuid.UUID, key string) *LookupTrace {
+	if !dht.lookupTracing && ctx.Value(lookupTraceKey{}) == nil {
+		return nil
+	}
+	return &LookupTrace{ID: id, Key: NewKeyKadID(key), Start: time.Now()}
+}
+
+// traceQuery records that the peer was queried.
+func (q *query) traceQuery(p peer.ID) {
+	if q.trace == nil {
+		return
+	}
+	hop := &LookupHop{
+		Peer:  NewPeerKadID(p),
+		Depth: 1,
+		Sent:  time.Since(q.trace.Start),
+		State: qpeerset.PeerWaiting,
+		Alpha: q.alpha,
+		Path:  q.path,
+	}
+	if referrer := q.queryPeers.GetReferrer(p); referrer != q.dht.self {
+		hop.ReferredBy = OptPeerKadID(referrer)
+		if r, ok := q.traceHops[referrer]; ok {
+			hop.Depth = r.Depth + 1
+		}
+	}
+	if rtt, ok := q.dht.routingTable.EstimateRTT(p); ok {
+		hop.RTT = rtt
+	}
+	q.trace.Hops = append(q.trace.Hops, hop)
+	q.traceHops[p] = hop
+}
+
+// traceResponse records the outcome of the query to the peer. d is the query duration if it was answered.
+func (q *query) traceResponse(p peer.ID, state qpeerset.PeerState, d time.Duration) {
+	if q.trace == nil {
+		return
+	}
+	hop, ok := q.traceHops[p]
+	if !ok {
+		return
+	}
+	hop.State = state
+	if state != qpeerset.PeerQueried {
+		d = time.Since(q.trace.Start) - hop.Sent
+	}
+	hop.Duration = d
+}
+
+// traceHedge records that the query to the peer hedged a slower one.
+func (q *query) traceHedge(p peer.ID) {
+	if hop, ok := q.traceHops[p]; ok {
+		hop.Hedge = true
+	}
+}
+
+// traceEnd records why the lookup ended.
+func (q *query) traceEnd(reason LookupTerminationReason) {
+	if q.trace == nil {
+		return
+	}
+	q.trace.Termination = reason
+}
@@ -0,0 +1,103 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/libp2p/go-libp2p-kad-dht/qpeerset"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetClosestPeersWithTrace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dhts := setupDHTS(t, ctx, 4)
+	defer func() {
+		for _, d := range dhts {
+			d.Close()
+			d.host.Close()
+		}
+	}()
+	connect(t, ctx, dhts[0], dhts[1])
+	connect(t, ctx, dhts[1], dhts[2])
+	connect(t, ctx, dhts[1], dhts[3])
+
+	// only the lookups asking for it are traced
+	require.Nil(t, dhts[0].newLookupTrace(ctx, uuid.New(), "key"))
+	require.NotNil(t, dhts[0].newLookupTrace(withLookupTrace(ctx), uuid.New(), "key"))
+
+	ctxT, cancelT := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelT()
+	peers, trace, err := dhts[0].GetClosestPeersWithTrace(ctxT, string(dhts[2].PeerID()))
+	require.NoError(t, err)
+	require.Contains(t, peers, dhts[2].PeerID())
+	require.NotNil(t, trace)
+	require.Equal(t, string(dhts[2].PeerID()), trace.Key.Key)
+	require.Contains(t, []LookupTerminationReason{LookupCompleted, LookupStarvation}, trace.Termination)
+
+	// the lookup starts from the only peer of the table, which refers it to the others
+	require.NotEmpty(t, trace.Hops)
+	first := trace.Hops[0]
+	require.Equal(t, dhts[1].PeerID(), first.Peer.Peer)
+	require.Nil(t, first.ReferredBy)
+	require.Equal(t, 1, first.Depth)
+	require.Equal(t, qpeerset.PeerQueried, first.State)
+	require.NotZero(t, first.Duration)
+
+	hops := make(map[peer.ID]*LookupHop)
+	for _, h := range trace.Hops {
+		hops[h.Peer.Peer] = h
+	}
+	require.Contains(t, hops, dhts[2].PeerID())
+	for _, h := range trace.Hops[1:] {
+		require.NotNil(t, h.ReferredBy)
+		require.Equal(t, hops[h.ReferredBy.Peer].Depth+1, h.Depth)
+		require.GreaterOrEqual(t, h.Sent, first.Sent)
+	}
+	require.Equal(t, 2, trace.Depth())
+	require.LessOrEqual(t, trace.Hops[len(trace.Hops)-1].Sent, trace.Duration)
+}
+
+func TestLookupTracing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dhts := setupDHTS(t, ctx, 2, LookupTracing(true))
+	defer func() {
+		for _, d := range dhts {
+			d.Close()
+			d.host.Close()
+		}
+	}()
+	connect(t, ctx, dhts[0], dhts[1])
+
+	ectx, ecancel := context.WithCancel(ctx)
+	defer ecancel()
+	ectx, events := RegisterForLookupEvents(ectx)
+	traces := make(chan *LookupTrace, 1)
+	go func() {
+		for e := range events {
+			if e.Trace != nil {
+				traces <- e.Trace
+			}
+		}
+	}()
+
+	_, err := dhts[0].GetClosestPeers(ectx, "key")
+	require.NoError(t, err)
+
+	select {
+	case trace := <-traces:
+		require.Equal(t, "key", trace.Key.Key)
+		require.Len(t, trace.Hops, 1)
+		require.Equal(t, dhts[1].PeerID(), trace.Hops[0].Peer.Peer)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no lookup trace was published")
+	}
+}
@@ -0,0 +1,133 @@
+package kbucket
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// AdmissionDecision is the outcome of an AdmissionPolicy.
+type AdmissionDecision int
+
+const (
+	// AdmissionReject leaves the bucket untouched and rejects the candidate.
+	AdmissionReject AdmissionDecision = iota
+	// AdmissionAccept adds the candidate without evicting anybody.
+	AdmissionAccept
+	// AdmissionReplace evicts the returned peer and adds the candidate in its place.
+	AdmissionReplace
+)
+
+func (d AdmissionDecision) String() string {
+	switch d {
+	case AdmissionReject:
+		return "reject"
+	case AdmissionAccept:
+		return "accept"
+	case AdmissionReplace:
+		return "replace"
+	default:
+		return "unknown"
+	}
+}
+
+// AdmissionPolicy decides what happens to a candidate peer whose bucket is full.
+//
+// Admit is called with the current contents of the bucket, front (most recently added) first,
+// the candidate and the RTT measured for it. Peers whose RTT is unknown or stale are annotated with the
+// peerstore latency before the call, and so is a candidate added without an RTT, e.g. with TryAddPeer:
+// rtt is then its peerstore latency, or 0 if the peerstore has none. If the diversity filter of the table
+// is soft, the candidate and the peers are annotated with their DiversityScore. When the decision is
+// AdmissionReplace, the returned peer ID must be one of the peers in the bucket; it is ignored otherwise.
+//
+// Admit is called with the routing table lock held and must not call back into the table.
+type AdmissionPolicy interface {
+	Admit(peers []PeerInfo, candidate PeerInfo, rtt time.Duration) (AdmissionDecision, peer.ID)
+}
+
+// ReplaceablePolicy is the classic Kademlia admission policy: if the bucket is full, the least
+// recently added peer that is still marked as replaceable is evicted to make place for the candidate.
+// If there is no such peer, the candidate is rejected.
+type ReplaceablePolicy struct{}
+
+var _ AdmissionPolicy = ReplaceablePolicy{}
+
+// Admit implements AdmissionPolicy.
+func (ReplaceablePolicy) Admit(peers []PeerInfo, candidate PeerInfo, rtt time.Duration) (AdmissionDecision, peer.ID) {
+	for i := len(peers) - 1; i >= 0; i-- {
+		if peers[i].replaceable {
+			return AdmissionReplace, peers[i].Id
+		}
+	}
+	return AdmissionReject, ""
+}
+
+// IDVariancePolicy is the KadRTT admission policy. A replaceable peer is only evicted if its RTT
+// is higher than the candidate's and swapping it for the candidate does not make the IDs in the bucket
+// less evenly spaced, as given by Measure. Among all such peers, the one yielding the lowest dispersion
+// is evicted. A candidate whose RTT is unknown, i.e. 0, can't be compared and is rejected.
+//
+// With a positive DiversityWeight, the RTTs are compared after being inflated by how crowded the IP groups
+// of the peers are: a peer whose DiversityScore is s costs rtt*(1+DiversityWeight*(1-s)). This keeps fast
+// peers from the same network from taking over a bucket.
+type IDVariancePolicy struct {
+	Measure         Dispersion
+	DiversityWeight float64
+}
+
+var _ AdmissionPolicy = IDVariancePolicy{}
+var _ spacingAdmissionPolicy = IDVariancePolicy{}
+
+// spacingAdmissionPolicy is an AdmissionPolicy that measures the spacing of the IDs in the bucket.
+// The routing table calls admit with the spacing it maintains incrementally for the bucket instead of Admit,
+// so that the policy doesn't rebuild it from the peers on every candidate.
+type spacingAdmissionPolicy interface {
+	AdmissionPolicy
+	admit(peers []PeerInfo, spacing *idSpacing, candidate PeerInfo, rtt time.Duration) (AdmissionDecision, peer.ID)
+}
+
+// Admit implements AdmissionPolicy.
+func (pol IDVariancePolicy) Admit(peers []PeerInfo, candidate PeerInfo, rtt time.Duration) (AdmissionDecision, peer.ID) {
+	spacing := newIDSpacing()
+	for i := range peers {
+		spacing.add(peers[i].dhtId)
+	}
+	return pol.admit(peers, spacing, candidate, rtt)
+}
+
+// admit is Admit given the spacing of the IDs of the peers, which it doesn't change. With the variance
+// measure, it runs in O(k log k) for a bucket of k peers.
+func (pol IDVariancePolicy) admit(peers []PeerInfo, spacing *idSpacing, candidate PeerInfo, rtt time.Duration) (AdmissionDecision, peer.ID) {
+	if len(peers) == 0 {
+		return AdmissionAccept, ""
+	}
+	if rtt <= 0 {
+		return AdmissionReject, ""
+	}
+
+	best := spacing.measure(pol.Measure)
+	cost := pol.cost(rtt, candidate.crowding)
+	var victim peer.ID
+	for i := range peers {
+		if !peers[i].replaceable || pol.cost(peers[i].GetRTT(), peers[i].crowding) <= cost {
+			continue
+		}
+		if d := spacing.measureAfter(pol.Measure, peers[i].dhtId, candidate.dhtId); d.Cmp(best) <= 0 {
+			best = d
+			victim = peers[i].Id
+		}
+	}
+
+	if victim == "" {
+		return AdmissionReject, ""
+	}
+	return AdmissionReplace, victim
+}
+
+// cost returns the RTT of a peer inflated by the crowding of its IP groups.
+func (pol IDVariancePolicy) cost(rtt time.Duration, crowding float64) float64 {
+	if pol.DiversityWeight <= 0 {
+		return float64(rtt)
+	}
+	return float64(rtt) * (1 + pol.DiversityWeight*crowding)
+}
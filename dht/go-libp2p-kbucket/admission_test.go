@@ -0,0 +1,211 @@
+package kbucket
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaceablePolicy(t *testing.T) {
+	t.Parallel()
+
+	p1, p2, p3 := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+	candidate := PeerInfo{Id: test.RandPeerIDFatal(t)}
+
+	// the least recently added replaceable peer is evicted
+	peers := []PeerInfo{{Id: p1, replaceable: true}, {Id: p2, replaceable: true}, {Id: p3}}
+	d, victim := ReplaceablePolicy{}.Admit(peers, candidate, 0)
+	require.Equal(t, AdmissionReplace, d)
+	require.Equal(t, p2, victim)
+
+	peers = []PeerInfo{{Id: p1}, {Id: p2}, {Id: p3}}
+	d, _ = ReplaceablePolicy{}.Admit(peers, candidate, 0)
+	require.Equal(t, AdmissionReject, d)
+}
+
+func randPeerInfo(t *testing.T, replaceable bool, rtt time.Duration) PeerInfo {
+	p := test.RandPeerIDFatal(t)
+	pi := PeerInfo{Id: p, dhtId: ConvertPeerID(p), replaceable: replaceable}
+	pi.rtt.AddSample(rtt, time.Now())
+	return pi
+}
+
+func TestIDVariancePolicy(t *testing.T) {
+	t.Parallel()
+
+	candidate := randPeerInfo(t, true, time.Millisecond)
+
+	d, _ := IDVariancePolicy{}.Admit(nil, candidate, time.Millisecond)
+	require.Equal(t, AdmissionAccept, d)
+
+	slow := randPeerInfo(t, true, time.Second)
+	d, victim := IDVariancePolicy{}.Admit([]PeerInfo{slow}, candidate, time.Millisecond)
+	require.Equal(t, AdmissionReplace, d)
+	require.Equal(t, slow.Id, victim)
+
+	// a candidate whose RTT isn't measured yet doesn't push out a measured peer
+	d, _ = IDVariancePolicy{}.Admit([]PeerInfo{slow}, candidate, 0)
+	require.Equal(t, AdmissionReject, d)
+
+	// faster and irreplaceable peers are never evicted
+	peers := []PeerInfo{
+		randPeerInfo(t, true, time.Microsecond),
+		randPeerInfo(t, false, time.Second),
+	}
+	d, _ = IDVariancePolicy{}.Admit(peers, candidate, time.Millisecond)
+	require.Equal(t, AdmissionReject, d)
+
+	// among slower peers, the candidate replaces the one leaving the IDs most evenly spaced,
+	// as long as the spacing doesn't get worse
+	for _, m := range []Dispersion{DispersionVariance, DispersionMaxGap} {
+		peers = make([]PeerInfo, 8)
+		for i := range peers {
+			peers[i] = randPeerInfo(t, true, time.Second)
+		}
+
+		measure := func(ps []PeerInfo) *big.Int {
+			s := newIDSpacing()
+			for _, p := range ps {
+				s.add(p.dhtId)
+			}
+			return s.measure(m)
+		}
+		before := measure(peers)
+		var best *big.Int
+		for i := range peers {
+			swapped := append(append(append([]PeerInfo{}, peers[:i]...), peers[i+1:]...), candidate)
+			if d := measure(swapped); best == nil || d.Cmp(best) < 0 {
+				best = d
+			}
+		}
+
+		d, victim = IDVariancePolicy{Measure: m}.Admit(peers, candidate, time.Millisecond)
+		if best.Cmp(before) > 0 {
+			require.Equal(t, AdmissionReject, d)
+			continue
+		}
+		require.Equal(t, AdmissionReplace, d)
+		var swapped []PeerInfo
+		for _, p := range peers {
+			if p.Id != victim {
+				swapped = append(swapped, p)
+			}
+		}
+		require.Len(t, swapped, len(peers)-1)
+		require.Zero(t, best.Cmp(measure(append(swapped, candidate))), m.String())
+	}
+}
+
+func TestIDVariancePolicyGivenSpacing(t *testing.T) {
+	t.Parallel()
+
+	peers := make([]PeerInfo, 32)
+	spacing := newIDSpacing()
+	for i := range peers {
+		peers[i] = randPeerInfo(t, true, time.Second)
+		spacing.add(peers[i].dhtId)
+	}
+	before := spacing.variance()
+
+	// the table passes the spacing it maintains for the bucket, which the policy only reads
+	for _, m := range []Dispersion{DispersionVariance, DispersionMaxGap} {
+		for i := 0; i < 16; i++ {
+			candidate := randPeerInfo(t, true, time.Millisecond)
+			d, victim := IDVariancePolicy{Measure: m}.Admit(peers, candidate, time.Millisecond)
+			dg, victimg := IDVariancePolicy{Measure: m}.admit(peers, spacing, candidate, time.Millisecond)
+			require.Equal(t, d, dg)
+			require.Equal(t, victim, victimg)
+		}
+	}
+	require.Zero(t, before.Cmp(spacing.variance()))
+	require.Len(t, spacing.ids, len(peers))
+}
+
+func BenchmarkIDVariancePolicyAdmit(b *testing.B) {
+	peers := make([]PeerInfo, 1000)
+	spacing := newIDSpacing()
+	for i := range peers {
+		p := test.RandPeerIDFatal(b)
+		peers[i] = PeerInfo{Id: p, dhtId: ConvertPeerID(p), replaceable: true}
+		peers[i].rtt.AddSample(time.Second, time.Now())
+		spacing.add(peers[i].dhtId)
+	}
+	p := test.RandPeerIDFatal(b)
+	candidate := PeerInfo{Id: p, dhtId: ConvertPeerID(p)}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		IDVariancePolicy{}.admit(peers, spacing, candidate, time.Millisecond)
+	}
+}
+
+type rejectAllPolicy struct {
+	calls int
+}
+
+func (r *rejectAllPolicy) Admit(peers []PeerInfo, candidate PeerInfo, rtt time.Duration) (AdmissionDecision, peer.ID) {
+	r.calls++
+	return AdmissionReject, ""
+}
+
+func TestCustomAdmissionPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy := &rejectAllPolicy{}
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(1, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil, Admission(policy))
+	require.NoError(t, err)
+
+	p1, _ := rt.GenRandPeerID(0)
+	b, err := rt.TryAddPeer(p1, true, true)
+	require.NoError(t, err)
+	require.True(t, b)
+	require.Zero(t, policy.calls)
+
+	// the bucket is full, p1 is replaceable but the policy says no
+	p2, _ := rt.GenRandPeerID(0)
+	b, err = rt.TryAddPeer(p2, true, true)
+	require.Equal(t, ErrPeerRejectedNoCapacity, err)
+	require.False(t, b)
+	require.Equal(t, 1, policy.calls)
+	require.Equal(t, p1, rt.Find(p1))
+	require.Empty(t, rt.Find(p2))
+}
+
+func TestTryAddPeerAdmittedOnPeerstoreLatency(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	m := pstore.NewMetrics()
+	rt, err := NewRoutingTable(1, ConvertPeerID(local), time.Hour, m, NoOpThreshold, nil,
+		KadRTT(true), RTTInterval(time.Hour), BucketKRange(1, 1))
+	require.NoError(t, err)
+	defer rt.Close()
+
+	slow, _ := rt.GenRandPeerID(0)
+	b, err := rt.TryAddPeerKadRTT(slow, true, true, 50*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, b)
+
+	// TryAddPeer has no RTT to hand over: without a peerstore latency the candidate can't be compared
+	unknown, _ := rt.GenRandPeerID(0)
+	b, err = rt.TryAddPeer(unknown, true, true)
+	require.Equal(t, ErrPeerRejectedNoCapacity, err)
+	require.False(t, b)
+
+	// with one, it replaces the slower peer
+	fast, _ := rt.GenRandPeerID(0)
+	m.RecordLatency(fast, 5*time.Millisecond)
+	b, err = rt.TryAddPeer(fast, true, true)
+	require.NoError(t, err)
+	require.True(t, b)
+	require.Equal(t, fast, rt.Find(fast))
+	require.Empty(t, rt.Find(slow))
+}
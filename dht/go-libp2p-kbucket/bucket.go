@@ -4,7 +4,6 @@ package kbucket
 
 import (
 	"container/list"
-	"math/big"
 	"time"
 
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -32,16 +31,34 @@ type PeerInfo struct {
 	replaceable bool
 
 	//Added by Kanemitsu
-	rtt time.Duration
+	rtt RTTStats
+
+	// how much the IP groups of the peer are shared with the other peers of its Cpl, between 0 and 1.
+	// Only set for the admission policy when the diversity filter is soft.
+	crowding float64
 }
 
 //Added by Kanemitsu START
+
+// GetRTT returns the smoothed RTT of the peer, or 0 if it has never been measured.
 func (p *PeerInfo) GetRTT() time.Duration {
+	return p.rtt.EWMA()
+}
+
+// SetRTT records an RTT sample for the peer, measured at the given time of the clock of the table.
+func (p *PeerInfo) SetRTT(t time.Duration, at time.Time) {
+	p.rtt.AddSample(t, at)
+}
+
+// RTTStats returns the RTT statistics of the peer.
+func (p *PeerInfo) RTTStats() RTTStats {
 	return p.rtt
 }
 
-func (p *PeerInfo) SetRTT(t time.Duration) {
-	p.rtt = t
+// DiversityScore returns the score of the peer in the soft diversity filter of the table,
+// between 0 (crowded) and 1 (diverse). It is only meaningful in an AdmissionPolicy, and is 1 otherwise.
+func (p *PeerInfo) DiversityScore() float64 {
+	return 1 - p.crowding
 }
 
 //Added by Kanemitsu END
@@ -82,9 +99,17 @@ type bucket struct {
 	p_not float64
 
 	/**
-	The current variance in terms of ID distance
+	Spacing of the peer IDs in the keyspace,
+	kept in sync with list
 	*/
-	idVariance *big.Int
+	spacing *idSpacing
+
+	// peers rejected because the bucket was full, that may take the place of a removed peer
+	replacements *replacementCache
+
+	// the k the KadRTT optimizer derived for the bucket, before the entry budget of the table is applied.
+	// 0 until the optimizer has run.
+	kPref int
 }
 
 func newBucket() *bucket {
@@ -96,7 +121,8 @@ func newBucket() *bucket {
 	b.beta = 1.0
 	b.p_not = 1.0
 	b.k = 1.0
-	b.idVariance = big.NewInt(int64(-1))
+	b.spacing = newIDSpacing()
+	b.replacements = &replacementCache{}
 
 	return b
 }
@@ -193,8 +219,9 @@ func (b *bucket) getPeer(p peer.ID) *PeerInfo {
 // returns true if successful, false otherwise.
 func (b *bucket) remove(id peer.ID) bool {
 	for e := b.list.Front(); e != nil; e = e.Next() {
-		if e.Value.(*PeerInfo).Id == id {
+		if pi := e.Value.(*PeerInfo); pi.Id == id {
 			b.list.Remove(e)
+			b.spacing.remove(pi.dhtId)
 			return true
 		}
 	}
@@ -212,6 +239,7 @@ func (b *bucket) moveToFront(id peer.ID) {
 
 func (b *bucket) pushFront(p *PeerInfo) {
 	b.list.PushFront(p)
+	b.spacing.add(p.dhtId)
 
 }
 
@@ -233,12 +261,15 @@ func (b *bucket) split(cpl int, target ID) *bucket {
 		if peerCPL > cpl {
 			cur := e
 			out.PushBack(e.Value)
+			newbuck.spacing.add(pDhtId)
 			e = e.Next()
 			b.list.Remove(cur)
+			b.spacing.remove(pDhtId)
 			continue
 		}
 		e = e.Next()
 	}
+	newbuck.replacements = b.replacements.split(cpl, target)
 	return newbuck
 }
 
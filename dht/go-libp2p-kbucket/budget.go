@@ -0,0 +1,149 @@
+package kbucket
+
+import (
+	"math/big"
+	"sort"
+)
+
+// DefaultMinBucketK is the default lower bound of the k of a KadRTT bucket.
+const DefaultMinBucketK = 1
+
+// clampK bounds k to the range configured with BucketKRange.
+func (rt *RoutingTable) clampK(k int) int {
+	if rt.maxBucketK > 0 && k > rt.maxBucketK {
+		k = rt.maxBucketK
+	}
+	if k < rt.minBucketK {
+		k = rt.minBucketK
+	}
+	return k
+}
+
+// allotK applies the entry budget of the table to the k the optimizer prefers for every bucket, and
+// returns the k of the bucket at idx. The other buckets whose k changes get it right away; setOptValues
+// then evicts the peers they hold beyond their new k with shrinkBucket.
+// locking is the responsibility of the caller
+func (rt *RoutingTable) allotK(idx int) int {
+	prefs := make([]int, len(rt.buckets))
+	for i, b := range rt.buckets {
+		prefs[i] = b.kPref
+		if prefs[i] == 0 {
+			prefs[i] = rt.clampK(b.k)
+		}
+	}
+	ks := distributeK(prefs, rt.maxEntries, rt.minBucketK)
+
+	for i, b := range rt.buckets {
+		if i == idx || ks[i] == b.k {
+			continue
+		}
+		prevK, prevAlpha, prevBeta := b.k, b.alpha, b.beta
+		b.SetK(ks[i])
+		if b.beta > b.k {
+			b.SetBeta(b.k)
+		}
+		rt.emitParamsChanged(i, prevK, prevAlpha, prevBeta)
+	}
+	return ks[idx]
+}
+
+// distributeK returns the k of every bucket given the k the optimizer prefers for them, so that they sum
+// to at most budget. If the preferences don't fit, they are scaled down by the same factor, which keeps
+// their ratios, and the entries left over by the rounding go to the buckets that lost the largest fraction
+// of an entry, the lowest CPL first. No k goes below minK: the buckets that would are set to minK and the
+// others share the rest of the budget. If even minK per bucket exceeds the budget, every bucket gets minK.
+// A budget of 0 or less leaves the preferences as they are.
+func distributeK(prefs []int, budget, minK int) []int {
+	ks := append([]int(nil), prefs...)
+	total := 0
+	for _, k := range prefs {
+		total += k
+	}
+	if budget <= 0 || total <= budget {
+		return ks
+	}
+
+	pinned := make([]bool, len(prefs))
+	for {
+		// share what's left after the pinned buckets among the others, in proportion to their preference
+		left, free := budget, 0
+		for i, k := range prefs {
+			if pinned[i] {
+				left -= minK
+			} else {
+				free += k
+			}
+		}
+		if free == 0 || left <= 0 {
+			for i := range ks {
+				ks[i] = minK
+			}
+			return ks
+		}
+
+		scale := float64(left) / float64(free)
+		repin := false
+		for i, k := range prefs {
+			if !pinned[i] && float64(k)*scale < float64(minK) {
+				pinned[i], repin = true, true
+			}
+		}
+		if repin {
+			continue
+		}
+
+		type remainder struct {
+			idx  int
+			frac float64
+		}
+		var rems []remainder
+		for i, k := range prefs {
+			if pinned[i] {
+				ks[i] = minK
+				continue
+			}
+			exact := float64(k) * scale
+			ks[i] = int(exact)
+			left -= ks[i]
+			rems = append(rems, remainder{i, exact - float64(ks[i])})
+		}
+		sort.SliceStable(rems, func(i, j int) bool { return rems[i].frac > rems[j].frac })
+		for _, r := range rems {
+			if left <= 0 {
+				break
+			}
+			// never exceed the preference, which would happen if it fits exactly
+			if ks[r.idx] < prefs[r.idx] {
+				ks[r.idx]++
+				left--
+			}
+		}
+		return ks
+	}
+}
+
+// shrinkBucket evicts the peers of the bucket beyond its k, one at a time, picking each time the peer
+// whose removal leaves the IDs most evenly spaced. The evicted peers go to the replacement cache, as the
+// candidates rejected for lack of capacity do.
+// locking is the responsibility of the caller
+func (rt *RoutingTable) shrinkBucket(bucketID int) {
+	bucket := rt.buckets[bucketID]
+	now := rt.clock.Now()
+	for bucket.len() > bucket.k {
+		var victim *PeerInfo
+		var best *big.Int
+		for _, p := range bucket.peers() {
+			if d := bucket.spacing.measureAfter(rt.dispersion, p.dhtId, nil); best == nil || d.Cmp(best) < 0 {
+				p := p
+				best, victim = d, &p
+			}
+		}
+		if victim == nil || !rt.removePeer(victim.Id) {
+			log.Warnf("failed to evict a peer from bucket %d holding %d peers beyond its k of %d", bucketID, bucket.len(), bucket.k)
+			return
+		}
+		// the removal may have collapsed empty buckets before this one
+		rt.emit(Event{Type: EventPeerEvicted, Time: now, Cpl: rt.bucketIdForPeer(victim.Id), Peer: victim.Id})
+		rt.cacheReplacement(victim, now)
+	}
+}
@@ -0,0 +1,107 @@
+package kbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/test"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDistributeK(t *testing.T) {
+	t.Parallel()
+
+	tcs := map[string]struct {
+		prefs  []int
+		budget int
+		minK   int
+		ks     []int
+	}{
+		"unbounded":     {prefs: []int{40, 20, 20}, budget: 0, minK: 1, ks: []int{40, 20, 20}},
+		"within budget": {prefs: []int{40, 20, 20}, budget: 80, minK: 1, ks: []int{40, 20, 20}},
+		"scaled":        {prefs: []int{40, 20, 20}, budget: 40, minK: 1, ks: []int{20, 10, 10}},
+		// 1*45/81=0.6 is pinned to 2, and the others share 43: 60*43/80=32.25 and 20*43/80=10.75,
+		// which gets the entry left over
+		"pinned": {prefs: []int{60, 20, 1}, budget: 45, minK: 2, ks: []int{32, 11, 2}},
+		// 10*20/30=6.67 each, and the 2 entries left over go to the lowest CPLs
+		"remainders":    {prefs: []int{10, 10, 10}, budget: 20, minK: 1, ks: []int{7, 7, 6}},
+		"below minimum": {prefs: []int{10, 10, 10}, budget: 5, minK: 2, ks: []int{2, 2, 2}},
+	}
+
+	for name, tc := range tcs {
+		ks := distributeK(tc.prefs, tc.budget, tc.minK)
+		require.Equal(t, tc.ks, ks, name)
+
+		sum := 0
+		for i, k := range ks {
+			require.LessOrEqual(t, k, tc.prefs[i]+tc.minK, name)
+			require.GreaterOrEqual(t, k, tc.minK, name)
+			sum += k
+		}
+		if tc.budget > 0 && tc.budget >= tc.minK*len(tc.prefs) {
+			require.LessOrEqual(t, sum, tc.budget, name)
+		}
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(20, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil,
+		KadRTT(true), RTTInterval(time.Hour), InitialStoreRate(4), InitialExchangeProbability(1),
+		BucketKRange(2, 30), MaxEntries(40))
+	require.NoError(t, err)
+	defer rt.Close()
+
+	// no rttInterval window closes, so only the splits re-derive the parameters: the budget must
+	// hold between windows too
+	for i := 0; i < 500; i++ {
+		_, _ = rt.TryAddPeerKadRTT(test.RandPeerIDFatal(t), true, false, time.Duration(1+i%50)*time.Millisecond)
+		require.LessOrEqual(t, rt.Size(), 40)
+	}
+
+	total := 0
+	for _, b := range rt.BucketParams() {
+		require.GreaterOrEqual(t, b.K, 2)
+		require.LessOrEqual(t, b.K, 30)
+		require.LessOrEqual(t, b.K, b.PreferredK)
+		require.LessOrEqual(t, b.Peers, b.K)
+		total += b.K
+	}
+	require.LessOrEqual(t, total, 40)
+
+	require.Error(t, BucketKRange(0, 10)(rt))
+	require.Error(t, BucketKRange(5, 4)(rt))
+	require.Error(t, MaxEntries(-1)(rt))
+}
+
+func TestShrinkBucketCachesEvictedPeers(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(4, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil,
+		KadRTT(true), RTTInterval(time.Hour), ReplacementCacheSize(4))
+	require.NoError(t, err)
+	defer rt.Close()
+
+	for i := 0; i < 4; i++ {
+		p, err := rt.GenRandPeerID(0)
+		require.NoError(t, err)
+		_, err = rt.TryAddPeerKadRTT(p, true, false, time.Duration(1+i)*time.Millisecond)
+		require.NoError(t, err)
+	}
+	n := rt.NPeersForCpl(0)
+	require.Greater(t, n, 1)
+
+	// the peers beyond the new k are evicted and kept as replacements
+	rt.tabLock.Lock()
+	rt.buckets[0].k = 1
+	rt.shrinkBucket(0)
+	rt.tabLock.Unlock()
+	require.Equal(t, 1, rt.NPeersForCpl(0))
+	require.Len(t, rt.BucketParamsForCpl(0).Replacements, n-1)
+}
@@ -0,0 +1,157 @@
+package kbucket
+
+import (
+	"errors"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// CheckpointVersion is the version of the checkpoints written by this package.
+const CheckpointVersion = 1
+
+// ErrCheckpointVersion is returned when restoring a checkpoint written by an unknown version.
+var ErrCheckpointVersion = errors.New("unsupported routing table checkpoint version")
+
+// PeerCheckpoint is the saved state of a peer in the routing table.
+type PeerCheckpoint struct {
+	Id peer.ID `json:"id"`
+	// Cpl is the index of the bucket the peer was in.
+	Cpl int `json:"cpl"`
+
+	AddedAt                       time.Time `json:"added_at"`
+	LastUsefulAt                  time.Time `json:"last_useful_at"`
+	LastSuccessfulOutboundQueryAt time.Time `json:"last_successful_outbound_query_at"`
+	Replaceable                   bool      `json:"replaceable"`
+
+	RTT RTTStats `json:"rtt"`
+}
+
+// Checkpoint is the saved state of a routing table, from which a new table can be warm-started
+// with Restore. It can be encoded as JSON.
+type Checkpoint struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	KadRTT  bool      `json:"kadrtt"`
+
+	Stats   AdaptationSnapshot `json:"stats"`
+	Buckets []BucketParams     `json:"buckets"`
+	// Peers are ordered by bucket, most recently added first.
+	Peers []PeerCheckpoint `json:"peers"`
+}
+
+// Checkpoint returns the current state of the routing table.
+func (rt *RoutingTable) Checkpoint() *Checkpoint {
+	rt.tabLock.RLock()
+	defer rt.tabLock.RUnlock()
+
+	cp := &Checkpoint{
+		Version: CheckpointVersion,
+		Time:    rt.clock.Now(),
+		KadRTT:  rt.isKadRTT,
+		Stats:   rt.stats.Snapshot(),
+		Buckets: rt.bucketParams(),
+	}
+	for cpl, b := range rt.buckets {
+		for _, pi := range b.peers() {
+			cp.Peers = append(cp.Peers, PeerCheckpoint{
+				Id:                            pi.Id,
+				Cpl:                           cpl,
+				AddedAt:                       pi.AddedAt,
+				LastUsefulAt:                  pi.LastUsefulAt,
+				LastSuccessfulOutboundQueryAt: pi.LastSuccessfulOutboundQueryAt,
+				Replaceable:                   pi.replaceable,
+				RTT:                           pi.rtt,
+			})
+		}
+	}
+	return cp
+}
+
+// Restore adds the peers of the checkpoint to the routing table, keeping the times and RTT statistics
+// they were saved with. Peers already in the table are left untouched, and peers are subject to the
+// same admission rules as when they are added with TryAddPeer.
+//
+// If both the checkpoint and the table are in KadRTT mode, the adaptation statistics and the
+// parameters of the buckets are restored as well. The window of the statistics that was open when
+// the checkpoint was taken is discarded, since the node was down for part of it.
+//
+// It returns the number of peers restored.
+func (rt *RoutingTable) Restore(cp *Checkpoint) (int, error) {
+	if cp.Version != CheckpointVersion {
+		return 0, ErrCheckpointVersion
+	}
+
+	rt.tabLock.Lock()
+	defer rt.tabLock.Unlock()
+
+	kadRTT := rt.isKadRTT && cp.KadRTT
+	if kadRTT {
+		// bucket sizes are derived from the statistics as buckets are unfolded
+		rt.stats.restore(cp.Stats)
+	}
+
+	// unfold the table as it was, so that the peers end up in the same buckets
+	for len(rt.buckets) < len(cp.Buckets) {
+		rt.nextBucket()
+	}
+	if kadRTT {
+		for _, bp := range cp.Buckets {
+			if bp.Cpl < 0 || bp.Cpl >= len(rt.buckets) {
+				continue
+			}
+			b := rt.buckets[bp.Cpl]
+			prevK, prevAlpha, prevBeta := b.k, b.alpha, b.beta
+			if bp.K > 0 {
+				b.k = bp.K
+			}
+			if bp.PreferredK > 0 {
+				b.kPref = bp.PreferredK
+			}
+			if bp.Alpha > 0 {
+				b.alpha = bp.Alpha
+			}
+			if bp.Beta > 0 {
+				b.beta = bp.Beta
+			}
+			b.p_query = bp.PQuery
+			b.p_not = bp.PNot
+			rt.emitParamsChanged(bp.Cpl, prevK, prevAlpha, prevBeta)
+		}
+	}
+
+	restored := 0
+	// peers are pushed to the front of their bucket, so walk them backwards to keep their order.
+	for i := len(cp.Peers) - 1; i >= 0; i-- {
+		pc := cp.Peers[i]
+		if pc.Id == "" || ConvertPeerID(pc.Id).equal(rt.local) {
+			continue
+		}
+		if rt.buckets[rt.bucketIdForPeer(pc.Id)].getPeer(pc.Id) != nil {
+			continue
+		}
+		pi := &PeerInfo{
+			Id:                            pc.Id,
+			LastUsefulAt:                  pc.LastUsefulAt,
+			LastSuccessfulOutboundQueryAt: pc.LastSuccessfulOutboundQueryAt,
+			AddedAt:                       pc.AddedAt,
+			dhtId:                         ConvertPeerID(pc.Id),
+			replaceable:                   pc.Replaceable,
+			rtt:                           pc.RTT,
+		}
+		if ok, err := rt.insertPeer(pi, pc.RTT.EWMA(), rt.clock.Now()); ok {
+			restored++
+		} else if err != nil {
+			log.Debugf("not restoring peer %s: %s", pc.Id, err)
+		}
+	}
+	// some of the buckets may have stayed empty
+	rt.collapseEmptyBuckets()
+
+	if kadRTT {
+		// exchanges made room for restored peers, they don't belong to the new window.
+		rt.stats.restore(cp.Stats)
+	}
+
+	return restored, nil
+}
@@ -0,0 +1,119 @@
+package kbucket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointRestore(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	opts := []Option{KadRTT(true), RTTInterval(time.Nanosecond), InitialStoreRate(0.2), PoolSize(4)}
+	rt, err := NewRoutingTable(4, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil, opts...)
+	require.NoError(t, err)
+
+	for i := 0; i < 40; i++ {
+		p := test.RandPeerIDFatal(t)
+		rt.TryAddPeerKadRTT(p, i%2 == 0, i%3 != 0, time.Duration(1+i)*time.Millisecond)
+		rt.SetRTT(p, time.Duration(2+i)*time.Millisecond)
+	}
+	require.Greater(t, rt.Size(), 4)
+
+	// round trip through JSON, as the DHT stores it
+	b, err := json.Marshal(rt.Checkpoint())
+	require.NoError(t, err)
+	cp := new(Checkpoint)
+	require.NoError(t, json.Unmarshal(b, cp))
+	require.Equal(t, CheckpointVersion, cp.Version)
+	require.Len(t, cp.Peers, rt.Size())
+
+	// a restarted node restores the table
+	rt2, err := NewRoutingTable(4, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil, opts...)
+	require.NoError(t, err)
+	n, err := rt2.Restore(cp)
+	require.NoError(t, err)
+	require.Equal(t, rt.Size(), n)
+	require.Equal(t, rt.Size(), rt2.Size())
+
+	infos := make(map[peer.ID]PeerInfo)
+	for _, pi := range rt.GetPeerInfos() {
+		infos[pi.Id] = pi
+	}
+	for _, pi := range rt2.GetPeerInfos() {
+		orig, ok := infos[pi.Id]
+		require.True(t, ok)
+		require.Equal(t, rt.bucketIdForPeer(pi.Id), rt2.bucketIdForPeer(pi.Id))
+		require.True(t, orig.AddedAt.Equal(pi.AddedAt))
+		require.True(t, orig.LastUsefulAt.Equal(pi.LastUsefulAt))
+		require.Equal(t, orig.replaceable, pi.replaceable)
+
+		s1, s2 := orig.RTTStats(), pi.RTTStats()
+		require.Equal(t, s1.EWMA(), s2.EWMA())
+		require.Equal(t, s1.Min(), s2.Min())
+		require.Equal(t, s1.Jitter(), s2.Jitter())
+		require.Equal(t, s1.StdDev(), s2.StdDev())
+		require.Equal(t, s1.Samples(), s2.Samples())
+		require.True(t, s1.LastMeasuredAt().Equal(s2.LastMeasuredAt()))
+	}
+
+	// as well as the order of the peers in the buckets
+	for cpl, b := range rt.GetBuckets() {
+		require.Equal(t, b.peerIds(), rt2.GetBucket(cpl).peerIds())
+	}
+
+	params, restored := rt.BucketParams(), rt2.BucketParams()
+	require.Len(t, restored, len(params))
+	for i := range params {
+		require.Equal(t, params[i].K, restored[i].K)
+		require.Equal(t, params[i].Alpha, restored[i].Alpha)
+		require.Equal(t, params[i].Beta, restored[i].Beta)
+		require.Equal(t, params[i].PQuery, restored[i].PQuery)
+		require.Equal(t, params[i].PNot, restored[i].PNot)
+	}
+
+	stats, restoredStats := rt.AdaptationStats(), rt2.AdaptationStats()
+	require.Equal(t, stats.StoreRate, restoredStats.StoreRate)
+	require.Equal(t, stats.ExchangeProbability, restoredStats.ExchangeProbability)
+	require.Equal(t, stats.Windows, restoredStats.Windows)
+	require.EqualValues(t, 1, restoredStats.Arrivals)
+
+	// restoring again doesn't duplicate anything
+	n, err = rt2.Restore(cp)
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
+
+func TestRestoreClassic(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(2, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil)
+	require.NoError(t, err)
+
+	cp := &Checkpoint{Version: CheckpointVersion, KadRTT: true}
+	for i := 0; i < 3; i++ {
+		p, err := rt.GenRandPeerID(0)
+		require.NoError(t, err)
+		cp.Peers = append(cp.Peers, PeerCheckpoint{Id: p})
+	}
+	cp.Peers = append(cp.Peers, PeerCheckpoint{Id: local})
+
+	// the bucket only holds two peers, and we never add ourselves
+	n, err := rt.Restore(cp)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, 2, rt.Size())
+	require.Empty(t, rt.Find(local))
+
+	_, err = rt.Restore(&Checkpoint{Version: CheckpointVersion + 1})
+	require.Equal(t, ErrCheckpointVersion, err)
+}
@@ -0,0 +1,263 @@
+// Package clock abstracts the passing of time so that the interval-driven behaviour of the routing
+// table and of the DHT can be tested deterministically.
+//
+// New returns the wall clock. NewMock returns a clock that only moves when told to, firing the
+// timers and tickers created from it on the way. Tests synchronise with the goroutines those wake up
+// with BlockUntil, never with sleeps.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates timers.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) *Timer
+	NewTicker(d time.Duration) *Ticker
+}
+
+// New returns the wall clock.
+func New() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (realClock) NewTimer(d time.Duration) *Timer {
+	t := time.NewTimer(d)
+	return &Timer{C: t.C, timer: t}
+}
+
+func (realClock) NewTicker(d time.Duration) *Ticker {
+	t := time.NewTicker(d)
+	return &Ticker{C: t.C, ticker: t}
+}
+
+// Timer is the counterpart of time.Timer.
+type Timer struct {
+	C <-chan time.Time
+
+	timer *time.Timer
+
+	mock *Mock
+	c    chan time.Time
+	at   time.Time
+}
+
+// Stop prevents the timer from firing. It returns false if the timer had already expired or been stopped.
+func (t *Timer) Stop() bool {
+	if t.timer != nil {
+		return t.timer.Stop()
+	}
+	return t.mock.remove(t)
+}
+
+// Reset changes the timer to expire after d. It returns true if the timer had been active.
+func (t *Timer) Reset(d time.Duration) bool {
+	if t.timer != nil {
+		return t.timer.Reset(d)
+	}
+	t.mock.mu.Lock()
+	defer t.mock.mu.Unlock()
+
+	active := t.mock.removeLocked(t)
+	t.at = t.mock.now.Add(d)
+	t.mock.addLocked(t)
+	return active
+}
+
+func (t *Timer) next() time.Time {
+	return t.at
+}
+
+// locking is the responsibility of the caller
+func (t *Timer) fire(now time.Time) {
+	t.mock.removeLocked(t)
+	select {
+	case t.c <- now:
+	default:
+	}
+}
+
+// Ticker is the counterpart of time.Ticker.
+type Ticker struct {
+	C <-chan time.Time
+
+	ticker *time.Ticker
+
+	mock *Mock
+	c    chan time.Time
+	at   time.Time
+	d    time.Duration
+}
+
+// Stop turns off the ticker.
+func (t *Ticker) Stop() {
+	if t.ticker != nil {
+		t.ticker.Stop()
+		return
+	}
+	t.mock.remove(t)
+}
+
+func (t *Ticker) next() time.Time {
+	return t.at
+}
+
+// locking is the responsibility of the caller
+func (t *Ticker) fire(now time.Time) {
+	t.at = t.at.Add(t.d)
+	// like time.Ticker, drop the tick if the previous one wasn't read
+	select {
+	case t.c <- now:
+	default:
+	}
+}
+
+type mockTimer interface {
+	next() time.Time
+	fire(now time.Time)
+}
+
+// Mock is a clock that only moves with Add and Set. It starts at the Unix epoch.
+// It is safe for concurrent use.
+type Mock struct {
+	mu     sync.Mutex
+	armed  *sync.Cond // signalled when a timer or ticker is created or reset
+	now    time.Time
+	timers []mockTimer
+}
+
+var _ Clock = (*Mock)(nil)
+
+// NewMock returns a mock clock set at the Unix epoch.
+func NewMock() *Mock {
+	m := &Mock{now: time.Unix(0, 0)}
+	m.armed = sync.NewCond(&m.mu)
+	return m
+}
+
+// Now returns the time of the mock clock.
+func (m *Mock) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.now
+}
+
+// Since returns the time elapsed on the mock clock since t.
+func (m *Mock) Since(t time.Time) time.Duration {
+	return m.Now().Sub(t)
+}
+
+// After returns a channel on which the time is sent once the mock clock has moved by d.
+func (m *Mock) After(d time.Duration) <-chan time.Time {
+	return m.NewTimer(d).C
+}
+
+// NewTimer returns a timer that fires once the mock clock has moved by d.
+func (m *Mock) NewTimer(d time.Duration) *Timer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := make(chan time.Time, 1)
+	t := &Timer{C: c, mock: m, c: c, at: m.now.Add(d)}
+	m.addLocked(t)
+	return t
+}
+
+// NewTicker returns a ticker that ticks every time the mock clock moves by d.
+func (m *Mock) NewTicker(d time.Duration) *Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := make(chan time.Time, 1)
+	t := &Ticker{C: c, mock: m, c: c, at: m.now.Add(d), d: d}
+	m.addLocked(t)
+	return t
+}
+
+// Add moves the mock clock forward by d, firing the timers and tickers due on the way in order.
+// It doesn't wait for the goroutines they wake up: a timer re-armed by one of them only fires
+// during this call if it was re-armed before the clock got past it. See BlockUntil.
+func (m *Mock) Add(d time.Duration) {
+	m.Set(m.Now().Add(d))
+}
+
+// Set moves the mock clock to t, firing the timers and tickers due on the way in order.
+func (m *Mock) Set(t time.Time) {
+	for m.fireNext(t) {
+	}
+
+	m.mu.Lock()
+	m.now = t
+	m.mu.Unlock()
+}
+
+// BlockUntil waits until at least n timers and tickers are pending. A goroutine that re-arms its
+// timer once it is done with the previous expiry, as a garbage collection loop does, is idle when
+// BlockUntil returns, so that moving the clock afterwards fires its timer deterministically.
+func (m *Mock) BlockUntil(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for len(m.timers) < n {
+		m.armed.Wait()
+	}
+}
+
+// fireNext fires the first timer due at or before end, and returns false if there is none.
+func (m *Mock) fireNext(end time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.timers) == 0 {
+		return false
+	}
+	sort.SliceStable(m.timers, func(i, j int) bool { return m.timers[i].next().Before(m.timers[j].next()) })
+	t := m.timers[0]
+	if t.next().After(end) {
+		return false
+	}
+	if t.next().After(m.now) {
+		m.now = t.next()
+	}
+	t.fire(m.now)
+	return true
+}
+
+// locking is the responsibility of the caller
+func (m *Mock) addLocked(t mockTimer) {
+	m.timers = append(m.timers, t)
+	m.armed.Broadcast()
+}
+
+func (m *Mock) remove(t mockTimer) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.removeLocked(t)
+}
+
+// locking is the responsibility of the caller
+func (m *Mock) removeLocked(t mockTimer) bool {
+	for i, other := range m.timers {
+		if other == t {
+			m.timers = append(m.timers[:i], m.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
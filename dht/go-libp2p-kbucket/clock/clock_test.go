@@ -0,0 +1,96 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMock(t *testing.T) {
+	t.Parallel()
+
+	m := NewMock()
+	start := m.Now()
+	require.Equal(t, time.Unix(0, 0), start)
+
+	timer := m.NewTimer(10 * time.Second)
+	ticker := m.NewTicker(3 * time.Second)
+	after := m.After(5 * time.Second)
+
+	m.Add(2 * time.Second)
+	require.Equal(t, 2*time.Second, m.Since(start))
+	select {
+	case <-timer.C:
+		t.Fatal("timer fired early")
+	case <-ticker.C:
+		t.Fatal("ticker ticked early")
+	default:
+	}
+
+	m.Add(2 * time.Second)
+	require.Equal(t, start.Add(3*time.Second), <-ticker.C)
+
+	m.Add(2 * time.Second)
+	require.Equal(t, start.Add(5*time.Second), <-after)
+	require.Equal(t, start.Add(6*time.Second), <-ticker.C)
+
+	// unread ticks are dropped
+	m.Add(10 * time.Second)
+	require.Equal(t, start.Add(9*time.Second), <-ticker.C)
+	require.Equal(t, start.Add(10*time.Second), <-timer.C)
+	require.False(t, timer.Stop())
+
+	require.False(t, timer.Reset(time.Second))
+	require.True(t, timer.Stop())
+	ticker.Stop()
+	m.Add(time.Minute)
+	select {
+	case <-timer.C:
+		t.Fatal("stopped timer fired")
+	case <-ticker.C:
+		t.Fatal("stopped ticker ticked")
+	default:
+	}
+	require.Empty(t, m.timers)
+}
+
+func TestMockBlockUntil(t *testing.T) {
+	t.Parallel()
+
+	m := NewMock()
+	timer := m.NewTimer(time.Second)
+	m.BlockUntil(1)
+
+	// a goroutine re-arms the timer once it handled its expiry
+	handled := make(chan time.Time)
+	go func() {
+		for i := 0; i < 2; i++ {
+			now := <-timer.C
+			handled <- now
+			timer.Reset(time.Second)
+		}
+	}()
+
+	m.Add(time.Second)
+	require.Equal(t, m.Now(), <-handled)
+	m.BlockUntil(1)
+	m.Add(time.Second)
+	require.Equal(t, m.Now(), <-handled)
+	m.BlockUntil(1)
+	require.Len(t, m.timers, 1)
+}
+
+func TestReal(t *testing.T) {
+	t.Parallel()
+
+	c := New()
+	start := c.Now()
+	timer := c.NewTimer(time.Millisecond)
+	<-timer.C
+	ticker := c.NewTicker(time.Millisecond)
+	<-ticker.C
+	ticker.Stop()
+	<-c.After(time.Millisecond)
+	require.GreaterOrEqual(t, int64(c.Since(start)), int64(3*time.Millisecond))
+}
@@ -0,0 +1,50 @@
+// Command kadrtt-model prints the KadRTT parameters and the lookup predictions of the analytic model
+// for every CPL of a network, to sanity-check parameter choices before a testground run.
+//
+//	kadrtt-model -n 1000 -store-rate 0.5 -exchange-prob 0.5 -pool 20 -k 20
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/libp2p/go-libp2p-kbucket/model"
+)
+
+func main() {
+	var p model.Params
+	flag.IntVar(&p.NetworkSize, "n", 1000, "number of nodes in the network")
+	flag.Float64Var(&p.StoreRate, "store-rate", 0.5, "store requests per second")
+	flag.Float64Var(&p.ExchangeProbability, "exchange-prob", 0.5, "probability that a full bucket exchanges a peer")
+	flag.IntVar(&p.PoolSize, "pool", 20, "lookup pool size")
+	flag.IntVar(&p.BucketSize, "k", 20, "minimum bucket size")
+	asJSON := flag.Bool("json", false, "print the table as JSON")
+	flag.Parse()
+
+	buckets, err := model.Evaluate(p)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(buckets); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "CPL\tPEERS\tK\tALPHA\tBETA\tP_QUERY\tP_NOT\tHOPS\tHIT\t")
+	for _, b := range buckets {
+		fmt.Fprintf(w, "%d\t%.1f\t%d\t%d\t%d\t%.4g\t%.4g\t%.2f\t%.4f\t\n",
+			b.Cpl, b.ExpectedPeers, b.K, b.Alpha, b.Beta, b.PQuery, b.PNot, b.Hops, b.HitProbability)
+	}
+	w.Flush()
+}
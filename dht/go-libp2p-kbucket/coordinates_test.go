@@ -0,0 +1,66 @@
+package kbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/test"
+
+	"github.com/libp2p/go-libp2p-kbucket/vivaldi"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateRTTFromCoordinates(t *testing.T) {
+	t.Parallel()
+
+	coords, err := vivaldi.NewClient(vivaldi.DefaultConfig())
+	require.NoError(t, err)
+	local := test.RandPeerIDFatal(t)
+	m := pstore.NewMetrics()
+	rt, err := NewRoutingTable(20, ConvertPeerID(local), time.Hour, m, NoOpThreshold, nil,
+		KadRTT(true), RTTMaxAge(time.Minute), NetworkCoordinates(coords))
+	require.NoError(t, err)
+	require.Equal(t, coords, rt.Coordinates())
+
+	at := func(x float64) *vivaldi.Coordinate {
+		c := vivaldi.NewCoordinate(coords.Config())
+		c.Vec[0] = x
+		return c
+	}
+
+	// a peer out of the table with neither coordinate nor latency is unknown
+	unknown := test.RandPeerIDFatal(t)
+	_, ok := rt.EstimateRTT(unknown)
+	require.False(t, ok)
+	m.RecordLatency(unknown, 30*time.Millisecond)
+	rtt, ok := rt.EstimateRTT(unknown)
+	require.True(t, ok)
+	require.Equal(t, 30*time.Millisecond, rtt)
+
+	// the coordinate is preferred to the peerstore latency
+	require.NoError(t, coords.SetPeerCoordinate(unknown, at(0.005), time.Now()))
+	rtt, ok = rt.EstimateRTT(unknown)
+	require.True(t, ok)
+	require.Equal(t, coords.Coordinate().DistanceTo(at(0.005)), rtt)
+
+	// a fresh measured RTT is preferred to the coordinate
+	p := test.RandPeerIDFatal(t)
+	_, err = rt.TryAddPeerKadRTT(p, true, true, 40*time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, coords.SetPeerCoordinate(p, at(0.002), time.Now()))
+	rtt, _ = rt.EstimateRTT(p)
+	require.Equal(t, 40*time.Millisecond, rtt)
+
+	// until it gets stale
+	rt.tabLock.Lock()
+	rt.buckets[rt.bucketIdForPeer(p)].getPeer(p).rtt.lastMeasuredAt = time.Now().Add(-time.Hour)
+	rt.tabLock.Unlock()
+	rtt, _ = rt.EstimateRTT(p)
+	require.Equal(t, coords.Coordinate().DistanceTo(at(0.002)), rtt)
+
+	// and the ranking follows
+	ranked := rt.NearestPeersByPolicy(ConvertPeerID(local), 1, RTTRanking{})
+	require.Equal(t, p, ranked[0])
+}
@@ -0,0 +1,274 @@
+package kbucket
+
+import (
+	"math/big"
+	"sort"
+)
+
+// Dispersion selects how the spread of the IDs in a bucket is measured.
+// For every measure, a lower value means the IDs are more evenly spaced.
+type Dispersion int
+
+const (
+	// DispersionVariance is the variance of the gaps between adjacent IDs.
+	DispersionVariance Dispersion = iota
+	// DispersionMaxGap is the largest gap between adjacent IDs.
+	DispersionMaxGap
+)
+
+func (d Dispersion) String() string {
+	switch d {
+	case DispersionVariance:
+		return "variance"
+	case DispersionMaxGap:
+		return "max-gap"
+	default:
+		return "unknown"
+	}
+}
+
+// idSpacing keeps a set of IDs sorted in the keyspace along with the sum of the squared gaps
+// between adjacent IDs, so the variance of the gaps is updated in O(log n) as IDs join and leave,
+// and the variance after removing and/or inserting an ID is known without re-sorting the set.
+// The max-gap measure is computed with a linear scan.
+type idSpacing struct {
+	ids   []*big.Int // ascending
+	sumSq *big.Int
+}
+
+func newIDSpacing() *idSpacing {
+	return &idSpacing{sumSq: new(big.Int)}
+}
+
+func idToInt(id ID) *big.Int {
+	return new(big.Int).SetBytes(id)
+}
+
+func (s *idSpacing) len() int {
+	return len(s.ids)
+}
+
+// search returns the index of the first ID >= x.
+func (s *idSpacing) search(x *big.Int) int {
+	return sort.Search(len(s.ids), func(i int) bool {
+		return s.ids[i].Cmp(x) >= 0
+	})
+}
+
+// index returns the index of x, or -1 if it isn't in the set.
+func (s *idSpacing) index(x *big.Int) int {
+	if i := s.search(x); i < len(s.ids) && s.ids[i].Cmp(x) == 0 {
+		return i
+	}
+	return -1
+}
+
+func (s *idSpacing) add(id ID) {
+	x := idToInt(id)
+	i := s.search(x)
+	if i < len(s.ids) && s.ids[i].Cmp(x) == 0 {
+		return
+	}
+
+	s.sumSq.Add(s.sumSq, s.insertDelta(x, i, -1))
+
+	s.ids = append(s.ids, nil)
+	copy(s.ids[i+1:], s.ids[i:])
+	s.ids[i] = x
+}
+
+func (s *idSpacing) remove(id ID) bool {
+	i := s.index(idToInt(id))
+	if i < 0 {
+		return false
+	}
+
+	s.sumSq.Add(s.sumSq, s.removeDelta(i))
+
+	copy(s.ids[i:], s.ids[i+1:])
+	s.ids[len(s.ids)-1] = nil
+	s.ids = s.ids[:len(s.ids)-1]
+	return true
+}
+
+// removeDelta returns the change of the sum of squared gaps if the ID at index i is removed.
+func (s *idSpacing) removeDelta(i int) *big.Int {
+	d := new(big.Int)
+	var prev, next *big.Int
+	if i > 0 {
+		prev = s.ids[i-1]
+		d.Sub(d, sqGap(prev, s.ids[i]))
+	}
+	if i < len(s.ids)-1 {
+		next = s.ids[i+1]
+		d.Sub(d, sqGap(s.ids[i], next))
+	}
+	if prev != nil && next != nil {
+		d.Add(d, sqGap(prev, next))
+	}
+	return d
+}
+
+// insertDelta returns the change of the sum of squared gaps if x is inserted at index i,
+// ignoring the ID at index skip (which is being removed at the same time, -1 for none).
+func (s *idSpacing) insertDelta(x *big.Int, i int, skip int) *big.Int {
+	d := new(big.Int)
+
+	p := i - 1
+	if p == skip {
+		p--
+	}
+	n := i
+	if n == skip {
+		n++
+	}
+
+	var prev, next *big.Int
+	if p >= 0 {
+		prev = s.ids[p]
+		d.Add(d, sqGap(prev, x))
+	}
+	if n < len(s.ids) {
+		next = s.ids[n]
+		d.Add(d, sqGap(x, next))
+	}
+	if prev != nil && next != nil {
+		d.Sub(d, sqGap(prev, next))
+	}
+	return d
+}
+
+func sqGap(a, b *big.Int) *big.Int {
+	g := new(big.Int).Sub(b, a)
+	return g.Mul(g, g)
+}
+
+// edit returns the IDs remaining after removing rm and inserting add, either of which may be nil,
+// without changing the set. It runs in linear time.
+func (s *idSpacing) edit(rm, add *big.Int) []*big.Int {
+	out := make([]*big.Int, 0, len(s.ids)+1)
+	for _, x := range s.ids {
+		if rm != nil && x.Cmp(rm) == 0 {
+			continue
+		}
+		if add != nil && x.Cmp(add) == 0 {
+			add = nil
+		}
+		if add != nil && x.Cmp(add) > 0 {
+			out = append(out, add)
+			add = nil
+		}
+		out = append(out, x)
+	}
+	if add != nil {
+		out = append(out, add)
+	}
+	return out
+}
+
+// variance returns the variance of the gaps between adjacent IDs in the set.
+func (s *idSpacing) variance() *big.Int {
+	if len(s.ids) < 2 {
+		return new(big.Int)
+	}
+	return gapVariance(s.ids[0], s.ids[len(s.ids)-1], s.sumSq, len(s.ids)-1)
+}
+
+// gapVariance derives the variance of n gaps spanning from first to last from their sum of squares.
+func gapVariance(first, last, sumSq *big.Int, n int) *big.Int {
+	if n < 1 {
+		return new(big.Int)
+	}
+	bn := big.NewInt(int64(n))
+	sum := new(big.Int).Sub(last, first)
+
+	// (n * sumSq - sum^2) / n^2
+	v := new(big.Int).Mul(bn, sumSq)
+	v.Sub(v, sum.Mul(sum, sum))
+	return v.Quo(v, bn.Mul(bn, bn))
+}
+
+// varianceAfter returns the variance of the gaps once rm is removed and add is inserted,
+// either of which may be nil. It runs in O(log n).
+func (s *idSpacing) varianceAfter(rm, add *big.Int) *big.Int {
+	sumSq := new(big.Int).Set(s.sumSq)
+	n := len(s.ids)
+
+	skip := -1
+	if rm != nil {
+		if skip = s.index(rm); skip >= 0 {
+			sumSq.Add(sumSq, s.removeDelta(skip))
+			n--
+		}
+	}
+	if add != nil {
+		if i := s.search(add); (i == len(s.ids) || s.ids[i].Cmp(add) != 0) || i == skip {
+			sumSq.Add(sumSq, s.insertDelta(add, i, skip))
+			n++
+		} else {
+			add = nil
+		}
+	}
+	if n < 2 {
+		return new(big.Int)
+	}
+
+	// the ends of the edited set
+	first, last := s.end(0, 1, skip), s.end(len(s.ids)-1, -1, skip)
+	if add != nil {
+		if first == nil || add.Cmp(first) < 0 {
+			first = add
+		}
+		if last == nil || add.Cmp(last) > 0 {
+			last = add
+		}
+	}
+	return gapVariance(first, last, sumSq, n-1)
+}
+
+// end walks from index i in direction dir and returns the first ID that isn't at index skip.
+func (s *idSpacing) end(i, dir, skip int) *big.Int {
+	for ; i >= 0 && i < len(s.ids); i += dir {
+		if i != skip {
+			return s.ids[i]
+		}
+	}
+	return nil
+}
+
+// maxGap returns the largest gap between adjacent IDs in the given sorted IDs.
+func maxGap(ids []*big.Int) *big.Int {
+	m := new(big.Int)
+	g := new(big.Int)
+	for i := 1; i < len(ids); i++ {
+		if g.Sub(ids[i], ids[i-1]).Cmp(m) > 0 {
+			m.Set(g)
+		}
+	}
+	return m
+}
+
+// measure returns the dispersion of the set.
+func (s *idSpacing) measure(d Dispersion) *big.Int {
+	if d == DispersionMaxGap {
+		return maxGap(s.ids)
+	}
+	return s.variance()
+}
+
+// measureAfter returns the dispersion of the set once rm is removed and add is inserted,
+// either of which may be nil.
+func (s *idSpacing) measureAfter(d Dispersion, rm, add ID) *big.Int {
+	var rmx, addx *big.Int
+	if rm != nil {
+		rmx = idToInt(rm)
+	}
+	if add != nil {
+		addx = idToInt(add)
+	}
+
+	if d == DispersionMaxGap {
+		return maxGap(s.edit(rmx, addx))
+	}
+	return s.varianceAfter(rmx, addx)
+}
@@ -0,0 +1,152 @@
+package kbucket
+
+import (
+	"math/big"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/test"
+
+	"github.com/stretchr/testify/require"
+)
+
+// naiveDispersion sorts the IDs and computes the dispersion from scratch.
+func naiveDispersion(ids []ID, d Dispersion) *big.Int {
+	xs := make([]*big.Int, len(ids))
+	for i, id := range ids {
+		xs[i] = idToInt(id)
+	}
+	sort.Slice(xs, func(i, j int) bool { return xs[i].Cmp(xs[j]) < 0 })
+	if len(xs) < 2 {
+		return new(big.Int)
+	}
+
+	gaps := make([]*big.Int, len(xs)-1)
+	for i := range gaps {
+		gaps[i] = new(big.Int).Sub(xs[i+1], xs[i])
+	}
+	if d == DispersionMaxGap {
+		m := new(big.Int)
+		for _, g := range gaps {
+			if g.Cmp(m) > 0 {
+				m = g
+			}
+		}
+		return m
+	}
+
+	// exact variance as a rational number, truncated
+	n := big.NewRat(int64(len(gaps)), 1)
+	mean := new(big.Rat)
+	for _, g := range gaps {
+		mean.Add(mean, new(big.Rat).SetInt(g))
+	}
+	mean.Quo(mean, n)
+	v := new(big.Rat)
+	for _, g := range gaps {
+		dev := new(big.Rat).Sub(new(big.Rat).SetInt(g), mean)
+		v.Add(v, dev.Mul(dev, dev))
+	}
+	v.Quo(v, n)
+	return new(big.Int).Quo(v.Num(), v.Denom())
+}
+
+func randIDs(t *testing.T, n int) []ID {
+	ids := make([]ID, n)
+	for i := range ids {
+		ids[i] = ConvertPeerID(test.RandPeerIDFatal(t))
+	}
+	return ids
+}
+
+func TestIDSpacingIncremental(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewSource(42))
+	ids := randIDs(t, 64)
+
+	s := newIDSpacing()
+	var in []ID
+	for step := 0; step < 500; step++ {
+		if len(in) > 0 && rng.Intn(3) == 0 {
+			i := rng.Intn(len(in))
+			require.True(t, s.remove(in[i]))
+			in = append(in[:i], in[i+1:]...)
+		} else {
+			id := ids[rng.Intn(len(ids))]
+			if s.index(idToInt(id)) < 0 {
+				in = append(in, id)
+			}
+			s.add(id)
+		}
+
+		require.Equal(t, len(in), s.len())
+		for _, d := range []Dispersion{DispersionVariance, DispersionMaxGap} {
+			require.Zero(t, naiveDispersion(in, d).Cmp(s.measure(d)), "step %d, %s", step, d)
+		}
+	}
+}
+
+func TestIDSpacingWhatIf(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewSource(7))
+	for n := 0; n < 12; n++ {
+		in := randIDs(t, n)
+		s := newIDSpacing()
+		for _, id := range in {
+			s.add(id)
+		}
+		out := randIDs(t, 1)[0]
+
+		for _, d := range []Dispersion{DispersionVariance, DispersionMaxGap} {
+			// insert only
+			require.Zero(t, naiveDispersion(append(append([]ID{}, in...), out), d).Cmp(s.measureAfter(d, nil, out)))
+
+			for i := range in {
+				rest := append(append([]ID{}, in[:i]...), in[i+1:]...)
+
+				// remove only
+				require.Zero(t, naiveDispersion(rest, d).Cmp(s.measureAfter(d, in[i], nil)))
+				// swap
+				require.Zero(t, naiveDispersion(append(rest, out), d).Cmp(s.measureAfter(d, in[i], out)))
+				// swap with a member of the set
+				other := in[rng.Intn(len(in))]
+				expected := rest
+				if other.equal(in[i]) {
+					expected = in
+				}
+				require.Zero(t, naiveDispersion(expected, d).Cmp(s.measureAfter(d, in[i], other)))
+			}
+		}
+
+		// what-if queries don't change the set
+		require.Equal(t, n, s.len())
+		require.Zero(t, naiveDispersion(in, DispersionVariance).Cmp(s.variance()))
+	}
+}
+
+func TestIDSpacingEvenlySpaced(t *testing.T) {
+	t.Parallel()
+
+	s := newIDSpacing()
+	for i := int64(0); i < 10; i++ {
+		s.add(ID(big.NewInt(1000 * i).Bytes()))
+	}
+	require.Zero(t, s.variance().Sign())
+	require.Zero(t, s.measure(DispersionMaxGap).Cmp(big.NewInt(1000)))
+
+	// moving one ID off the grid can only make it worse
+	require.Equal(t, 1, s.measureAfter(DispersionVariance, ID(big.NewInt(5000).Bytes()), ID(big.NewInt(5500).Bytes())).Sign())
+
+	// the order in which IDs are added doesn't matter
+	ids := randIDs(t, 20)
+	a, b := newIDSpacing(), newIDSpacing()
+	for i := range ids {
+		a.add(ids[i])
+		b.add(ids[len(ids)-1-i])
+	}
+	require.Zero(t, a.variance().Cmp(b.variance()))
+	require.Zero(t, a.sumSq.Cmp(b.sumSq))
+}
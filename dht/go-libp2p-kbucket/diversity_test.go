@@ -0,0 +1,121 @@
+package kbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	"github.com/libp2p/go-libp2p-kbucket/peerdiversity"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIDVariancePolicyDiversityWeight(t *testing.T) {
+	t.Parallel()
+
+	diverse := randPeerInfo(t, true, 10*time.Millisecond)
+	candidate := randPeerInfo(t, true, 8*time.Millisecond)
+	candidate.crowding = 0.5
+	require.Equal(t, 0.5, candidate.DiversityScore())
+
+	// the raw RTTs favour the candidate
+	d, victim := IDVariancePolicy{}.Admit([]PeerInfo{diverse}, candidate, 8*time.Millisecond)
+	require.Equal(t, AdmissionReplace, d)
+	require.Equal(t, diverse.Id, victim)
+
+	// but its crowding makes it cost 12ms
+	d, _ = IDVariancePolicy{DiversityWeight: 1}.Admit([]PeerInfo{diverse}, candidate, 8*time.Millisecond)
+	require.Equal(t, AdmissionReject, d)
+
+	// a crowded peer gives way to a diverse candidate that is slower than it
+	crowded := randPeerInfo(t, true, 6*time.Millisecond)
+	crowded.crowding = 1
+	candidate.crowding = 0
+	d, victim = IDVariancePolicy{DiversityWeight: 1}.Admit([]PeerInfo{crowded}, candidate, 8*time.Millisecond)
+	require.Equal(t, AdmissionReplace, d)
+	require.Equal(t, crowded.Id, victim)
+	d, _ = IDVariancePolicy{}.Admit([]PeerInfo{crowded}, candidate, 8*time.Millisecond)
+	require.Equal(t, AdmissionReject, d)
+}
+
+type recordingPolicy struct {
+	peers     []PeerInfo
+	candidate PeerInfo
+}
+
+func (r *recordingPolicy) Admit(peers []PeerInfo, candidate PeerInfo, rtt time.Duration) (AdmissionDecision, peer.ID) {
+	r.peers, r.candidate = peers, candidate
+	return AdmissionReject, ""
+}
+
+func TestSoftDiversityFilterAnnotatesPeers(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	addrs := make(map[peer.ID][]ma.Multiaddr)
+	mg := &mockPeerGroupFilter{}
+	mg.peerAddressFunc = func(p peer.ID) []ma.Multiaddr {
+		return addrs[p]
+	}
+	// the hard filter accepts a single peer per group
+	groups := make(map[peerdiversity.PeerIPGroupKey]int)
+	mg.allowFnc = func(g peerdiversity.PeerGroupInfo) bool {
+		return groups[g.IPGroupKey] == 0
+	}
+	mg.incrementFnc = func(g peerdiversity.PeerGroupInfo) {
+		groups[g.IPGroupKey]++
+	}
+	mg.decrementFnc = func(g peerdiversity.PeerGroupInfo) {
+		groups[g.IPGroupKey]--
+	}
+
+	df, err := peerdiversity.NewFilter(mg, "appname", func(p peer.ID) int {
+		return CommonPrefixLen(ConvertPeerID(local), ConvertPeerID(p))
+	})
+	require.NoError(t, err)
+	df.SetSoft(true)
+
+	pol := &recordingPolicy{}
+	rt, err := NewRoutingTable(2, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, df, Admission(pol))
+	require.NoError(t, err)
+	defer rt.Close()
+
+	alone, _ := rt.GenRandPeerID(0)
+	crowded, _ := rt.GenRandPeerID(0)
+	candidate, _ := rt.GenRandPeerID(0)
+	addrs[alone] = []ma.Multiaddr{ma.StringCast("/ip4/192.168.1.1/tcp/0")}
+	addrs[crowded] = []ma.Multiaddr{ma.StringCast("/ip4/172.16.1.1/tcp/0")}
+	addrs[candidate] = []ma.Multiaddr{ma.StringCast("/ip4/172.16.2.1/tcp/0")}
+
+	_, err = rt.TryAddPeer(alone, true, false)
+	require.NoError(t, err)
+	_, err = rt.TryAddPeer(crowded, true, false)
+	require.NoError(t, err)
+
+	// the candidate shares its group with a peer of the table, and yet gets to the admission policy
+	b, err := rt.TryAddPeer(candidate, true, false)
+	require.Error(t, err)
+	require.False(t, b)
+	require.Equal(t, candidate, pol.candidate.Id)
+	require.Equal(t, 0.5, pol.candidate.DiversityScore())
+	require.Len(t, pol.peers, 2)
+	for _, pi := range pol.peers {
+		switch pi.Id {
+		case alone:
+			require.Equal(t, 1.0, pi.DiversityScore())
+		case crowded:
+			require.Equal(t, 0.5, pi.DiversityScore())
+		}
+	}
+
+	// the annotations stay out of the table
+	for _, pi := range rt.GetPeerInfos() {
+		require.Equal(t, 1.0, pi.DiversityScore())
+	}
+
+	require.Error(t, DiversityWeight(-1)(rt))
+}
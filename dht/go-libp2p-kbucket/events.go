@@ -0,0 +1,262 @@
+package kbucket
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// EventType is the kind of a routing table Event.
+type EventType int
+
+const (
+	// EventPeerAdded is emitted when a peer is added to the table.
+	EventPeerAdded EventType = iota
+	// EventPeerRejected is emitted when a peer can't be added; Reason says why.
+	EventPeerRejected
+	// EventPeerRemoved is emitted when a peer is removed with RemovePeer.
+	EventPeerRemoved
+	// EventPeerEvicted is emitted when KadRTT shrinks a bucket and evicts the peer whose IDs leave
+	// the bucket most evenly spaced.
+	EventPeerEvicted
+	// EventPeerReplaced is emitted when the admission policy evicts Replaced to make place for Peer.
+	EventPeerReplaced
+	// EventBucketSplit is emitted when the last bucket is unfolded; Cpl is the new last bucket.
+	EventBucketSplit
+	// EventBucketCollapsed is emitted when an empty bucket is folded; Cpl is the bucket removed.
+	EventBucketCollapsed
+	// EventParamsChanged is emitted when KadRTT changes the k, alpha or beta of a bucket.
+	EventParamsChanged
+	// EventRTTUpdated is emitted when an RTT sample is recorded for a peer of the table.
+	EventRTTUpdated
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPeerAdded:
+		return "peer-added"
+	case EventPeerRejected:
+		return "peer-rejected"
+	case EventPeerRemoved:
+		return "peer-removed"
+	case EventPeerEvicted:
+		return "peer-evicted"
+	case EventPeerReplaced:
+		return "peer-replaced"
+	case EventBucketSplit:
+		return "bucket-split"
+	case EventBucketCollapsed:
+		return "bucket-collapsed"
+	case EventParamsChanged:
+		return "params-changed"
+	case EventRTTUpdated:
+		return "rtt-updated"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalJSON returns the JSON encoding of the event type.
+func (t EventType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+// RejectReason is the reason a peer wasn't added to the table.
+type RejectReason int
+
+const (
+	// RejectNone is the reason of events that aren't rejections.
+	RejectNone RejectReason = iota
+	// RejectHighLatency means the latency of the peer is above the tolerance of the table.
+	RejectHighLatency
+	// RejectDiversity means the peer diversity filter refused the peer.
+	RejectDiversity
+	// RejectNoCapacity means the bucket of the peer is full and the admission policy kept it as it was.
+	RejectNoCapacity
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectNone:
+		return ""
+	case RejectHighLatency:
+		return "high-latency"
+	case RejectDiversity:
+		return "diversity"
+	case RejectNoCapacity:
+		return "no-capacity"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalJSON returns the JSON encoding of the reject reason.
+func (r RejectReason) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// Event describes a change of the routing table. Only the fields relevant to its Type are set.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Cpl is the index of the bucket concerned.
+	Cpl int `json:"cpl"`
+
+	Peer peer.ID `json:"peer,omitempty"`
+	// RTT is the RTT of Peer when it was added, rejected or updated.
+	RTT time.Duration `json:"rtt,omitempty"`
+	// Reason is set for EventPeerRejected.
+	Reason RejectReason `json:"reason,omitempty"`
+	// Replaced is the peer evicted for Peer, for EventPeerReplaced.
+	Replaced peer.ID `json:"replaced,omitempty"`
+
+	// K, Alpha and Beta are the parameters of the bucket, for EventParamsChanged,
+	// and PrevK, PrevAlpha and PrevBeta the ones it had before.
+	K         int `json:"k,omitempty"`
+	Alpha     int `json:"alpha,omitempty"`
+	Beta      int `json:"beta,omitempty"`
+	PrevK     int `json:"prev_k,omitempty"`
+	PrevAlpha int `json:"prev_alpha,omitempty"`
+	PrevBeta  int `json:"prev_beta,omitempty"`
+}
+
+// Subscription receives the events of a routing table, in the order they happened.
+// Events are queued without bound until they are read, so that the table never waits on a
+// subscriber and none are lost; subscribers must keep reading or Close the subscription.
+type Subscription struct {
+	rt  *RoutingTable
+	out chan Event
+
+	mu     sync.Mutex
+	queue  []Event
+	wake   chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+// Subscribe returns a new subscription to the events of the table.
+func (rt *RoutingTable) Subscribe() *Subscription {
+	s := &Subscription{
+		rt:     rt,
+		out:    make(chan Event),
+		wake:   make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+
+	rt.subsLk.Lock()
+	rt.subs = append(rt.subs, s)
+	rt.subsLk.Unlock()
+
+	go s.loop()
+	return s
+}
+
+// Events returns the channel the events are delivered on. It is closed once the subscription
+// or the routing table is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.out
+}
+
+// Close stops the subscription. Events still queued are dropped.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		close(s.closed)
+	})
+
+	s.rt.subsLk.Lock()
+	defer s.rt.subsLk.Unlock()
+	for i, sub := range s.rt.subs {
+		if sub == s {
+			s.rt.subs = append(s.rt.subs[:i], s.rt.subs[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *Subscription) push(e Event) {
+	s.mu.Lock()
+	s.queue = append(s.queue, e)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Subscription) loop() {
+	defer close(s.out)
+	for {
+		s.mu.Lock()
+		queue := s.queue
+		s.queue = nil
+		s.mu.Unlock()
+
+		for _, e := range queue {
+			select {
+			case s.out <- e:
+			case <-s.closed:
+				return
+			case <-s.rt.ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-s.wake:
+		case <-s.closed:
+			return
+		case <-s.rt.ctx.Done():
+			return
+		}
+	}
+}
+
+// emit sends the event to all the subscribers.
+// It is called with the table lock held, which keeps the events in order.
+func (rt *RoutingTable) emit(e Event) {
+	rt.subsLk.Lock()
+	defer rt.subsLk.Unlock()
+
+	if len(rt.subs) == 0 {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = rt.clock.Now()
+	}
+	for _, s := range rt.subs {
+		s.push(e)
+	}
+}
+
+// locking is the responsibility of the caller
+func (rt *RoutingTable) emitAdded(cpl int, pi *PeerInfo, rtt time.Duration, now time.Time) {
+	rt.emit(Event{Type: EventPeerAdded, Time: now, Cpl: cpl, Peer: pi.Id, RTT: rtt})
+}
+
+// locking is the responsibility of the caller
+func (rt *RoutingTable) emitRejected(cpl int, pi *PeerInfo, rtt time.Duration, reason RejectReason, now time.Time) {
+	rt.emit(Event{Type: EventPeerRejected, Time: now, Cpl: cpl, Peer: pi.Id, RTT: rtt, Reason: reason})
+}
+
+// emitParamsChanged emits an EventParamsChanged if the parameters of the bucket differ from the given ones.
+// locking is the responsibility of the caller
+func (rt *RoutingTable) emitParamsChanged(cpl int, prevK, prevAlpha, prevBeta int) {
+	b := rt.buckets[cpl]
+	// the parameters of the buckets are only used in KadRTT mode
+	if !rt.isKadRTT || (b.k == prevK && b.alpha == prevAlpha && b.beta == prevBeta) {
+		return
+	}
+	rt.emit(Event{
+		Type:      EventParamsChanged,
+		Cpl:       cpl,
+		K:         b.k,
+		Alpha:     b.alpha,
+		Beta:      b.beta,
+		PrevK:     prevK,
+		PrevAlpha: prevAlpha,
+		PrevBeta:  prevBeta,
+	})
+}
@@ -0,0 +1,141 @@
+package kbucket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+
+	"github.com/stretchr/testify/require"
+)
+
+// drain reads the events of the subscription until none have come for a while.
+func drain(t *testing.T, sub *Subscription) []Event {
+	var evts []Event
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			require.True(t, ok)
+			evts = append(evts, e)
+		case <-time.After(200 * time.Millisecond):
+			return evts
+		}
+	}
+}
+
+func eventTypes(evts []Event) []EventType {
+	types := make([]EventType, len(evts))
+	for i, e := range evts {
+		types[i] = e.Type
+	}
+	return types
+}
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(2, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil)
+	require.NoError(t, err)
+	defer rt.Close()
+
+	sub := rt.Subscribe()
+	defer sub.Close()
+
+	var peers []peer.ID
+	for i := 0; i < 3; i++ {
+		p, err := rt.GenRandPeerID(0)
+		require.NoError(t, err)
+		peers = append(peers, p)
+	}
+	_, err = rt.TryAddPeerKadRTT(peers[0], true, false, time.Millisecond)
+	require.NoError(t, err)
+	_, err = rt.TryAddPeer(peers[1], true, false)
+	require.NoError(t, err)
+	_, err = rt.TryAddPeerKadRTT(peers[2], true, false, 3*time.Millisecond)
+	require.Equal(t, ErrPeerRejectedNoCapacity, err)
+	rt.SetRTT(peers[0], 2*time.Millisecond)
+	rt.RemovePeer(peers[1])
+
+	evts := drain(t, sub)
+	require.Equal(t, []EventType{
+		EventPeerAdded, EventPeerAdded,
+		EventBucketSplit, EventPeerRejected,
+		EventRTTUpdated,
+		EventBucketCollapsed, EventPeerRemoved,
+	}, eventTypes(evts))
+
+	require.Equal(t, peers[0], evts[0].Peer)
+	require.Equal(t, time.Millisecond, evts[0].RTT)
+	require.Equal(t, 1, evts[2].Cpl)
+	require.Equal(t, peers[2], evts[3].Peer)
+	require.Equal(t, RejectNoCapacity, evts[3].Reason)
+	require.Equal(t, 2*time.Millisecond, evts[4].RTT)
+	require.Equal(t, peers[1], evts[6].Peer)
+
+	b, err := json.Marshal(evts[3])
+	require.NoError(t, err)
+	require.Contains(t, string(b), `"type":"peer-rejected"`)
+	require.Contains(t, string(b), `"reason":"no-capacity"`)
+
+	// a closed subscription doesn't get anything anymore
+	sub.Close()
+	_, err = rt.TryAddPeer(test.RandPeerIDFatal(t), true, false)
+	require.NoError(t, err)
+	for range sub.Events() {
+	}
+}
+
+func TestEventsReplayKadRTT(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(4, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil,
+		KadRTT(true), RTTInterval(time.Nanosecond), InitialStoreRate(0.2), PoolSize(4))
+	require.NoError(t, err)
+	sub := rt.Subscribe()
+
+	var peers []peer.ID
+	for i := 0; i < 200; i++ {
+		p := test.RandPeerIDFatal(t)
+		peers = append(peers, p)
+		rt.TryAddPeerKadRTT(p, true, true, time.Duration(1+i%17)*time.Millisecond)
+		if i%5 == 0 {
+			rt.RemovePeer(peers[i/2])
+		}
+	}
+
+	// the events are enough to rebuild the table
+	table := make(map[peer.ID]struct{})
+	seen := make(map[EventType]bool)
+	for _, e := range drain(t, sub) {
+		seen[e.Type] = true
+		switch e.Type {
+		case EventPeerAdded:
+			table[e.Peer] = struct{}{}
+		case EventPeerReplaced:
+			require.Contains(t, table, e.Replaced)
+			delete(table, e.Replaced)
+			table[e.Peer] = struct{}{}
+		case EventPeerRemoved, EventPeerEvicted:
+			require.Contains(t, table, e.Peer)
+			delete(table, e.Peer)
+		}
+	}
+	require.True(t, seen[EventParamsChanged])
+	require.True(t, seen[EventBucketSplit])
+
+	require.Len(t, table, rt.Size())
+	for _, p := range rt.ListPeers() {
+		require.Contains(t, table, p)
+	}
+
+	// closing the table ends the subscriptions
+	require.NoError(t, rt.Close())
+	for range sub.Events() {
+	}
+}
@@ -0,0 +1,166 @@
+package kbucket
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// RTTHealth describes the RTTs of the peers of the table with a given CPL.
+type RTTHealth struct {
+	// Measured is the number of peers whose measured RTT is fresh.
+	Measured int `json:"measured"`
+	// Estimated is the number of peers whose RTT is stale or unknown, but could be estimated
+	// from their network coordinate or the peerstore.
+	Estimated int `json:"estimated"`
+	// Unknown is the number of peers whose RTT is not known at all.
+	Unknown int `json:"unknown"`
+
+	// Mean and P95 are the mean and the 95th percentile of the measured and estimated RTTs.
+	Mean time.Duration `json:"mean"`
+	P95  time.Duration `json:"p95"`
+}
+
+// CplHealth compares the peers of the table with a given CPL to the known peers with that CPL.
+type CplHealth struct {
+	Cpl int `json:"cpl"`
+	// Capacity is the capacity of the bucket serving the CPL.
+	Capacity int `json:"capacity"`
+
+	// Known is the number of known peers with the CPL.
+	Known int `json:"known"`
+	// Ideal is the number of peers with the CPL a healthy table holds: all the known ones, up to Capacity.
+	Ideal int `json:"ideal"`
+	// Actual is the number of peers of the table with the CPL.
+	Actual int `json:"actual"`
+	// Missing is the number of known peers the table lacks to reach Ideal.
+	Missing int `json:"missing"`
+	// Extra are the peers of the table with the CPL that aren't known, e.g. because they left the network.
+	Extra []peer.ID `json:"extra,omitempty"`
+
+	RTT RTTHealth `json:"rtt"`
+}
+
+// TableHealth is a health report of the routing table, ordered by CPL.
+type TableHealth struct {
+	Time time.Time   `json:"time"`
+	Cpls []CplHealth `json:"cpls"`
+}
+
+// Missing returns the total number of known peers missing from the table.
+func (h TableHealth) Missing() int {
+	n := 0
+	for _, c := range h.Cpls {
+		n += c.Missing
+	}
+	return n
+}
+
+// Extra returns the total number of peers of the table that aren't known.
+func (h TableHealth) Extra() int {
+	n := 0
+	for _, c := range h.Cpls {
+		n += len(c.Extra)
+	}
+	return n
+}
+
+// Healthy returns true if the table holds the ideal number of known peers at every CPL, and nothing else.
+func (h TableHealth) Healthy() bool {
+	return h.Missing() == 0 && h.Extra() == 0
+}
+
+func (h TableHealth) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "missing=%d extra=%d", h.Missing(), h.Extra())
+	for _, c := range h.Cpls {
+		fmt.Fprintf(&sb, " | cpl=%d ideal=%d actual=%d missing=%d extra=%d rtt(mean=%s p95=%s unknown=%d)",
+			c.Cpl, c.Ideal, c.Actual, c.Missing, len(c.Extra), c.RTT.Mean, c.RTT.P95, c.RTT.Unknown)
+	}
+	return sb.String()
+}
+
+// Health reports, for every CPL, how far the table is from holding the ideal set of peers drawn from
+// known, the peers known to be in the network, and how well the RTTs of its peers are known.
+// known may include the local peer, which is ignored.
+func (rt *RoutingTable) Health(known []peer.ID) TableHealth {
+	rt.tabLock.RLock()
+	defer rt.tabLock.RUnlock()
+
+	now := rt.clock.Now()
+	var cpls []CplHealth
+	cplFor := func(cpl int) *CplHealth {
+		for len(cpls) <= cpl {
+			c := len(cpls)
+			cpls = append(cpls, CplHealth{Cpl: c, Capacity: rt.bucketCapacity(rt.buckets[rt.bucketIndex(c)])})
+		}
+		return &cpls[cpl]
+	}
+
+	knownSet := make(map[peer.ID]struct{}, len(known))
+	for _, p := range known {
+		id := ConvertPeerID(p)
+		if id.equal(rt.local) {
+			continue
+		}
+		if _, ok := knownSet[p]; ok {
+			continue
+		}
+		knownSet[p] = struct{}{}
+		cplFor(CommonPrefixLen(id, rt.local)).Known++
+	}
+
+	rtts := make(map[int][]time.Duration)
+	for _, b := range rt.buckets {
+		for e := b.list.Front(); e != nil; e = e.Next() {
+			pi := e.Value.(*PeerInfo)
+			c := cplFor(CommonPrefixLen(pi.dhtId, rt.local))
+			c.Actual++
+			if _, ok := knownSet[pi.Id]; !ok {
+				c.Extra = append(c.Extra, pi.Id)
+			}
+
+			switch rtt := rt.effectiveRTT(pi, now); {
+			case !pi.rtt.IsStale(now, rt.rttMaxAge):
+				c.RTT.Measured++
+				rtts[c.Cpl] = append(rtts[c.Cpl], rtt)
+			case rtt > 0:
+				c.RTT.Estimated++
+				rtts[c.Cpl] = append(rtts[c.Cpl], rtt)
+			default:
+				c.RTT.Unknown++
+			}
+		}
+	}
+
+	for i := range cpls {
+		c := &cpls[i]
+		c.Ideal = c.Known
+		if c.Ideal > c.Capacity {
+			c.Ideal = c.Capacity
+		}
+		if present := c.Actual - len(c.Extra); present < c.Ideal {
+			c.Missing = c.Ideal - present
+		}
+		c.RTT.Mean, c.RTT.P95 = rttSummary(rtts[c.Cpl])
+	}
+
+	return TableHealth{Time: now, Cpls: cpls}
+}
+
+// rttSummary returns the mean and the 95th percentile (nearest rank) of the RTTs.
+func rttSummary(rtts []time.Duration) (mean, p95 time.Duration) {
+	if len(rtts) == 0 {
+		return 0, 0
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	var sum time.Duration
+	for _, rtt := range rtts {
+		sum += rtt
+	}
+	rank := (95*len(rtts) + 99) / 100
+	return sum / time.Duration(len(rtts)), rtts[rank-1]
+}
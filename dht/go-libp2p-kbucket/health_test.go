@@ -0,0 +1,88 @@
+package kbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableHealth(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	m := pstore.NewMetrics()
+	rt, err := NewRoutingTable(2, ConvertPeerID(local), time.Hour, m, NoOpThreshold, nil)
+	require.NoError(t, err)
+	defer rt.Close()
+
+	gen := func(cpl uint) peer.ID {
+		p, err := rt.GenRandPeerID(cpl)
+		require.NoError(t, err)
+		return p
+	}
+
+	// three known peers at cpl 0, one at cpl 1 and one at cpl 2
+	known := []peer.ID{local, gen(0), gen(0), gen(0), gen(1), gen(2)}
+	require.Empty(t, rt.Health(nil).Cpls)
+
+	h := rt.Health(known)
+	require.Len(t, h.Cpls, 3)
+	require.Equal(t, CplHealth{Cpl: 0, Capacity: 2, Known: 3, Ideal: 2, Missing: 2}, h.Cpls[0])
+	require.Equal(t, 1, h.Cpls[1].Missing)
+	require.Equal(t, 1, h.Cpls[2].Missing)
+	require.Equal(t, 4, h.Missing())
+	require.False(t, h.Healthy())
+
+	// fill the table with the known peers, plus one that isn't known. There is no place for
+	// the third peer at cpl 0.
+	for i, p := range known[1:] {
+		_, err := rt.TryAddPeer(p, true, false)
+		if i == 2 {
+			require.Equal(t, ErrPeerRejectedNoCapacity, err)
+			continue
+		}
+		require.NoError(t, err)
+	}
+	stranger := gen(1)
+	_, err = rt.TryAddPeer(stranger, true, false)
+	require.NoError(t, err)
+
+	rt.SetRTT(known[4], 10*time.Millisecond)
+	m.RecordLatency(stranger, 30*time.Millisecond)
+
+	h = rt.Health(known)
+	require.Len(t, h.Cpls, 3)
+	require.Zero(t, h.Missing())
+	require.Equal(t, []peer.ID{stranger}, h.Cpls[1].Extra)
+	require.Equal(t, 2, h.Cpls[1].Actual)
+	require.Equal(t, RTTHealth{Measured: 1, Estimated: 1, Mean: 20 * time.Millisecond, P95: 30 * time.Millisecond},
+		h.Cpls[1].RTT)
+	require.Equal(t, 2, h.Cpls[0].RTT.Unknown)
+	require.False(t, h.Healthy())
+	require.Contains(t, h.String(), "missing=0 extra=1")
+
+	rt.RemovePeer(stranger)
+	require.True(t, rt.Health(known).Healthy())
+}
+
+func TestRTTSummary(t *testing.T) {
+	t.Parallel()
+
+	mean, p95 := rttSummary(nil)
+	require.Zero(t, mean)
+	require.Zero(t, p95)
+
+	rtts := make([]time.Duration, 0, 100)
+	for i := 100; i > 0; i-- {
+		rtts = append(rtts, time.Duration(i)*time.Millisecond)
+	}
+	mean, p95 = rttSummary(rtts)
+	require.Equal(t, 50500*time.Microsecond, mean)
+	require.Equal(t, 95*time.Millisecond, p95)
+}
@@ -0,0 +1,142 @@
+package kbucket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-kbucket/clock"
+)
+
+// AdaptationSnapshot is a point-in-time view of the statistics KadRTT derives its
+// bucket parameters from.
+type AdaptationSnapshot struct {
+	// StoreRate is the STORE(addPeer) arrival rate measured over the last completed window, or
+	// the initial store rate if no window has completed yet. As in the original KadRTT code, the
+	// measured rate is in arrivals per nanosecond, which keeps the model at its lowest probability.
+	StoreRate float64 `json:"store_rate"`
+	// ExchangeProbability is the fraction of arrivals that led to a k-bucket entry exchange
+	// over the last completed window, or the initial exchange probability.
+	ExchangeProbability float64 `json:"exchange_probability"`
+
+	// Arrivals and Exchanges are the counters of the current, still open, window. They start
+	// at 1 and 0, or at 1 and 1 in the first window.
+	Arrivals  int64 `json:"arrivals"`
+	Exchanges int64 `json:"exchanges"`
+	// WindowStart is the time at which the current window was opened.
+	WindowStart time.Time `json:"window_start"`
+	// Windows is the number of completed windows.
+	Windows uint64 `json:"windows"`
+}
+
+// AdaptationStats collects the STORE arrival rate and the k-bucket entry exchange
+// probability over fixed windows of time. It is safe for concurrent use.
+type AdaptationStats struct {
+	lk sync.Mutex
+
+	clock    clock.Clock
+	interval time.Duration
+
+	storeRate    float64
+	probExchange float64
+
+	arrivals    int64
+	exchanges   int64
+	windowStart time.Time
+	windows     uint64
+}
+
+// NewAdaptationStats creates a collector closing a window every interval, reporting the
+// given store rate and exchange probability until the first window is complete.
+func NewAdaptationStats(interval time.Duration, storeRate, probExchange float64) *AdaptationStats {
+	c := clock.New()
+	return &AdaptationStats{
+		clock:        c,
+		interval:     interval,
+		storeRate:    storeRate,
+		probExchange: probExchange,
+		arrivals:     1,
+		exchanges:    1,
+		windowStart:  c.Now(),
+	}
+}
+
+// setClock makes the collector tell the time with the given clock, and opens a new window.
+func (s *AdaptationStats) setClock(c clock.Clock) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.clock = c
+	s.windowStart = c.Now()
+}
+
+// RecordArrival counts a STORE(addPeer) request. If the current window is complete, it derives
+// the new store rate and exchange probability, opens a new window and returns the snapshot of
+// the new values along with true.
+func (s *AdaptationStats) RecordArrival() (AdaptationSnapshot, bool) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.arrivals++
+	span := s.clock.Since(s.windowStart)
+	if span < s.interval {
+		return AdaptationSnapshot{}, false
+	}
+
+	s.storeRate = float64(s.arrivals) / float64(span)
+	s.probExchange = float64(s.exchanges) / float64(s.arrivals)
+	s.arrivals = 1
+	s.exchanges = 0
+	s.windowStart = s.clock.Now()
+	s.windows++
+
+	return s.snapshot(), true
+}
+
+// RecordExchange counts an arrival that resulted in a k-bucket entry exchange.
+func (s *AdaptationStats) RecordExchange() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.exchanges++
+}
+
+// SetExchangeProbability overrides the exchange probability until the current window completes.
+func (s *AdaptationStats) SetExchangeProbability(prob float64) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.probExchange = prob
+}
+
+// Snapshot returns the current statistics.
+func (s *AdaptationStats) Snapshot() AdaptationSnapshot {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	return s.snapshot()
+}
+
+func (s *AdaptationStats) snapshot() AdaptationSnapshot {
+	return AdaptationSnapshot{
+		StoreRate:           s.storeRate,
+		ExchangeProbability: s.probExchange,
+		Arrivals:            s.arrivals,
+		Exchanges:           s.exchanges,
+		WindowStart:         s.windowStart,
+		Windows:             s.windows,
+	}
+}
+
+// restore resumes collecting from a snapshot taken by another collector. The rates and the number of
+// completed windows are kept, while a new window is opened now.
+func (s *AdaptationStats) restore(snap AdaptationSnapshot) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.storeRate = snap.StoreRate
+	s.probExchange = snap.ExchangeProbability
+	s.windows = snap.Windows
+	s.arrivals = 1
+	s.exchanges = 0
+	s.windowStart = s.clock.Now()
+}
@@ -0,0 +1,121 @@
+package kbucket
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptationStatsWindow(t *testing.T) {
+	t.Parallel()
+
+	s := NewAdaptationStats(time.Hour, 0.5, 0.25)
+	for i := 0; i < 4; i++ {
+		_, done := s.RecordArrival()
+		require.False(t, done)
+	}
+	s.RecordExchange()
+
+	// the initial values are reported until the window completes
+	snap := s.Snapshot()
+	require.Equal(t, 0.5, snap.StoreRate)
+	require.Equal(t, 0.25, snap.ExchangeProbability)
+	// the first window counts one arrival and one exchange from the start
+	require.EqualValues(t, 5, snap.Arrivals)
+	require.EqualValues(t, 2, snap.Exchanges)
+	require.Zero(t, snap.Windows)
+
+	// close the window
+	s.lk.Lock()
+	s.windowStart = time.Now().Add(-2 * time.Second)
+	s.interval = time.Second
+	s.lk.Unlock()
+
+	snap, done := s.RecordArrival()
+	require.True(t, done)
+	require.EqualValues(t, 1, snap.Windows)
+	require.InDelta(t, 2.0/6, snap.ExchangeProbability, 1e-9)
+	// arrivals per nanosecond
+	require.InDelta(t, 3e-9, snap.StoreRate, 1e-10)
+	require.EqualValues(t, 1, snap.Arrivals)
+	require.Zero(t, snap.Exchanges)
+	require.Equal(t, snap, s.Snapshot())
+}
+
+// Run with -race.
+func TestKadRTTConcurrentAdds(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(2, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil,
+		KadRTT(true), RTTInterval(time.Millisecond))
+	require.NoError(t, err)
+
+	const workers = 8
+	const adds = 50
+
+	peers := make([]peer.ID, workers*adds)
+	for i := range peers {
+		peers[i] = test.RandPeerIDFatal(t)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < adds; i++ {
+				p := peers[w*adds+i]
+				rt.TryAddPeerKadRTT(p, i%2 == 0, true, time.Duration(i)*time.Millisecond)
+				rt.SetRTT(p, time.Millisecond)
+				rt.GetRTT(p)
+			}
+		}(w)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < adds; i++ {
+				rt.NearestPeers(ConvertPeerID(local), 5)
+				rt.AdaptationStats()
+				rt.CalcKOpt(0)
+				rt.CalcAlphaOpt(0)
+				rt.CalcBetaOpt(0)
+				rt.Size()
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.NotZero(t, rt.Size())
+	require.NotZero(t, rt.AdaptationStats().Windows)
+}
+
+func TestKadRTTOptimizersOutOfDomain(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	// a store rate above one puts the argument of W0 in CalcKOpt below -1/e
+	rt, err := NewRoutingTable(10, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil,
+		KadRTT(true), InitialStoreRate(4), InitialExchangeProbability(1))
+	require.NoError(t, err)
+	require.Equal(t, 10, rt.CalcKOpt(0))
+
+	for i := 0; i < 30; i++ {
+		// full buckets may reject some of them
+		rt.TryAddPeerKadRTT(test.RandPeerIDFatal(t), true, true, time.Millisecond)
+	}
+	require.Greater(t, rt.Size(), 10)
+	for _, p := range rt.BucketParams() {
+		require.GreaterOrEqual(t, p.K, 10)
+		require.GreaterOrEqual(t, p.Alpha, 2)
+		require.GreaterOrEqual(t, p.Beta, 1)
+	}
+}
@@ -0,0 +1,234 @@
+// Package lambertw implements the two real branches of the Lambert W function,
+// the inverse of f(w) = w * exp(w).
+//
+// The principal branch W0 is defined for x >= -1/e and satisfies W0(x) >= -1.
+// The lower branch W-1 is defined for -1/e <= x < 0 and satisfies W-1(x) <= -1.
+//
+// Initial estimates come from rational, branch point and asymptotic approximations,
+// and are refined with Fritsch iterations until the result is accurate to a few ulps.
+package lambertw
+
+import (
+	"errors"
+	"math"
+)
+
+var (
+	// ErrDomain is returned when the argument is outside the domain of the requested branch.
+	ErrDomain = errors.New("lambertw: argument out of domain")
+	// ErrNaN is returned when the argument is NaN.
+	ErrNaN = errors.New("lambertw: argument is NaN")
+	// ErrBranch is returned when the requested branch is neither 0 nor -1.
+	ErrBranch = errors.New("lambertw: branch must be 0 or -1")
+)
+
+// BranchPoint is the lowest argument of both real branches, -1/e. W0 and W-1 are both -1 there.
+const BranchPoint = -1 / math.E
+
+// maximum number of Fritsch iterations; each one has fourth order convergence,
+// so more than two are only needed close to the branch point.
+const maxIterations = 8
+
+// epsilon is the machine epsilon of float64.
+const epsilon = 0x1p-52
+
+// W0 returns the principal branch of the Lambert W function at x.
+// It returns NaN and ErrDomain if x < -1/e, and NaN and ErrNaN if x is NaN.
+func W0(x float64) (float64, error) {
+	return Branch(0, x)
+}
+
+// Wm1 returns the lower branch of the Lambert W function at x.
+// W-1(0) is -Inf. It returns NaN and ErrDomain if x < -1/e or x > 0, and NaN and ErrNaN if x is NaN.
+func Wm1(x float64) (float64, error) {
+	return Branch(-1, x)
+}
+
+// Branch returns the branch k of the Lambert W function at x, where k is 0 or -1.
+func Branch(k int, x float64) (float64, error) {
+	switch {
+	case k != 0 && k != -1:
+		return math.NaN(), ErrBranch
+	case math.IsNaN(x):
+		return math.NaN(), ErrNaN
+	case x < BranchPoint || (k == -1 && x > 0):
+		return math.NaN(), ErrDomain
+	}
+	return w(k, x), nil
+}
+
+// W returns the branch k of the Lambert W function at x, or NaN if k is neither 0 nor -1
+// or x is outside the domain of the branch, in the manner of the math package.
+func W(k int, x float64) float64 {
+	v, _ := Branch(k, x)
+	return v
+}
+
+// w assumes k and x have been validated.
+func w(k int, x float64) float64 {
+	// Special cases.
+	switch {
+	case x == 0:
+		if k == 0 {
+			return 0
+		}
+		return math.Inf(-1)
+	case x == BranchPoint:
+		return -1
+	case math.IsInf(x, 1):
+		return x
+	}
+
+	// Estimate an initial value using approximations and then use
+	// Fritsch iteration to get an improved estimate with O(1e-15) error
+	w := initial(k, x)
+	for i := 0; i < maxIterations; i++ {
+		next := fritsch(w, x)
+		if math.IsNaN(next) {
+			break
+		}
+		converged := math.Abs(next-w) <= 4*epsilon*math.Abs(next)
+		w = next
+		if converged {
+			break
+		}
+	}
+	return w
+}
+
+func fritsch(w, x float64) float64 {
+	z := math.Log(x/w) - w
+	w1 := w + 1
+	q := 2 * w1 * (w1 + 2*z/3)
+	eps := z / w1 * (q - z) / (q - 2*z)
+	return w * (1 + eps)
+}
+
+func initial(k int, x float64) float64 {
+	switch k {
+	case 0:
+		const (
+			xbranch = -0.32358170806015724
+			xratp0  = 0.14546954290661823
+			xratp1  = 8.706658967856612
+		)
+		switch {
+		case x < xbranch:
+			return branchpoint(k, x)
+		case x < xratp0:
+			return rationalp0(x)
+		case x < xratp1:
+			return rationalp1(x)
+		default:
+			return asymptotic(k, x)
+		}
+	default: // k=-1
+		const (
+			xbranch = -0.30298541769
+			xasymp  = -1e-4
+		)
+		switch {
+		case x < xbranch:
+			return branchpoint(k, x)
+		case x < xasymp:
+			return rationalm(x)
+		default:
+			return asymptotic(k, x)
+		}
+	}
+}
+
+// rationalm returns a rational estimate of W-1(x)
+func rationalm(x float64) float64 {
+	const (
+		a0 = -7.81417672390744
+		a1 = 253.88810188892484
+		a2 = 657.9493176902304
+
+		b0 = 1
+		b1 = -60.43958713690808
+		b2 = 99.9856708310761
+		b3 = 682.6073999909428
+		b4 = 962.1784396969866
+		b5 = 1477.9341280760887
+	)
+
+	return (a0 + x*(a1+x*a2)) / (b0 + x*(b1+x*(b2+x*(b3+x*(b4+x*b5)))))
+}
+
+// asymptotic returns an asymptotic estimate of W(x, k)
+func asymptotic(k int, x float64) float64 {
+	s := 1 + 2*k
+	a := math.Log(float64(s) * x)
+	b := math.Log(float64(s) * a)
+
+	ba := b / a
+	b2 := b * b
+	b3 := b2 * b
+	b4 := b2 * b2
+
+	q0 := b - 2
+	q1 := 2*b2 - 9*b + 6
+	q2 := 3*b3 - 22*b2 + 36*b - 12
+	q3 := 12*b4 - 125*b3 + 350*b2 - 300*b + 60
+	return a - b + ba*(1+1/(2*a)*(q0+1/(3*a)*(q1+1/(2*a)*(q2+1/(5*a)*q3))))
+}
+
+// rationalp0 returns a rational estimate of W0(x) around 0
+func rationalp0(x float64) float64 {
+	const (
+		a0 = 1
+		a1 = 5.931375839364438
+		a2 = 11.39220550532913
+		a3 = 7.33888339911111
+		a4 = 0.653449016991959
+
+		b0 = 1
+		b1 = 6.931373689597704
+		b2 = 16.82349461388016
+		b3 = 16.43072324143226
+		b4 = 5.115235195211697
+	)
+	num := a0 + x*(a1+x*(a2+x*(a3+x*a4)))
+	den := b0 + x*(b1+x*(b2+x*(b3+x*b4)))
+	return x * num / den
+}
+
+// rationalp1 returns a rational estimate of W0(x) for moderate positive x
+func rationalp1(x float64) float64 {
+	const (
+		a0 = 1
+		a1 = 2.445053070726557
+		a2 = 1.343664225958226
+		a3 = 0.148440055397592
+		a4 = 0.0008047501729130
+
+		b0 = 1
+		b1 = 3.444708986486002
+		b2 = 3.292489857371952
+		b3 = 0.916460018803122
+		b4 = 0.0530686404483322
+	)
+	num := a0 + x*(a1+x*(a2+x*(a3+x*a4)))
+	den := b0 + x*(b1+x*(b2+x*(b3+x*b4)))
+	return x * num / den
+}
+
+// branchpoint returns a series estimate of W(x, k) around the branch point -1/e
+func branchpoint(k int, x float64) float64 {
+	s := 1 + 2*k
+	p := float64(s) * math.Sqrt2 * math.Sqrt(1+math.E*x)
+	const (
+		b0 = -1
+		b1 = 1
+		b2 = -0.3333333333333333
+		b3 = 0.1527777777777778
+		b4 = -0.07962962962962963
+		b5 = 0.04450231481481481
+		b6 = -0.02598471487360376
+		b7 = 0.01563563253233392
+		b8 = -0.009616892024299432
+		b9 = 0.006014543252956118
+	)
+	return b0 + p*(b1+p*(b2+p*(b3+p*(b4+p*(b5+p*(b6+p*(b7+p*(b8+p*b9))))))))
+}
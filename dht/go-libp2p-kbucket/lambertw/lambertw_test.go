@@ -0,0 +1,143 @@
+package lambertw
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// reference values computed to 50 digits with Newton's method.
+var w0Tests = []struct {
+	x, w float64
+}{
+	{0, 0},
+	{1e-300, 1e-300},
+	{1e-10, 9.9999999990000000001499999999733333e-11},
+	{0.1, 0.091276527160862264299895721423179568},
+	{0.5, 0.35173371124919582602490930092995106},
+	{1, 0.56714329040978387299996866221035554},
+	{2, 0.85260550201372549134647241469531746},
+	{math.E, 1},
+	{10, 1.7455280027406993830743012648753899},
+	{100, 3.3856301402900501848882443645297268},
+	{1e6, 11.383358086140052622000156781585004},
+	{1e100, 224.84310644511850153937313433795567},
+	{1e300, 684.24720862976084923958762203026668},
+	{-0.1, -0.11183255915896296483356945682026584},
+	{-0.2, -0.25917110181907374505665195021540670},
+	{-math.Ln2 / 2, -math.Ln2},
+	{-0.3, -0.48940222718021496903623125199629336},
+	{-0.35, -0.71663881645607385058816980000386504},
+	{-0.367, -0.93239918474792848371646619087935720},
+	{BranchPoint, -1},
+	{math.Inf(1), math.Inf(1)},
+}
+
+var wm1Tests = []struct {
+	x, w float64
+}{
+	{BranchPoint, -1},
+	{-0.367, -1.0707918867680518740792587252131883},
+	{-0.35, -1.3497172521922488333831444594463570},
+	{-math.Ln2 / 2, -2 * math.Ln2},
+	{-0.3, -1.7813370234216276119741702815127452},
+	{-0.2, -2.5426413577735264242938061566618482},
+	{-0.1, -3.5771520639572972184093919635119948},
+	{-0.01, -6.4727751243940046947410578927244880},
+	{-1e-5, -14.163600815810183009109556303610899},
+	{-1e-10, -26.295238819246925694110128821854918},
+	{-1e-100, -235.72115887568531366046060613052381},
+	{-1e-300, -697.32277629546016099540752740546566},
+	{0, math.Inf(-1)},
+}
+
+func requireClose(t *testing.T, expected, actual, tol float64, msgAndArgs ...interface{}) {
+	t.Helper()
+	if math.IsInf(expected, 0) || expected == 0 {
+		require.Equal(t, expected, actual, msgAndArgs...)
+		return
+	}
+	require.InEpsilon(t, expected, actual, tol, msgAndArgs...)
+}
+
+func TestW0(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range w0Tests {
+		w, err := W0(tc.x)
+		require.NoError(t, err)
+		requireClose(t, tc.w, w, 1e-14, "W0(%g)", tc.x)
+		require.Equal(t, w, W(0, tc.x))
+	}
+}
+
+func TestWm1(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range wm1Tests {
+		w, err := Wm1(tc.x)
+		require.NoError(t, err)
+		requireClose(t, tc.w, w, 1e-14, "W-1(%g)", tc.x)
+		require.Equal(t, w, W(-1, tc.x))
+	}
+}
+
+func TestNearBranchPoint(t *testing.T) {
+	t.Parallel()
+
+	// both branches meet at -1 and stay on their side of it
+	for _, d := range []float64{1e-15, 1e-12, 1e-9, 1e-6, 1e-3} {
+		x := BranchPoint + d
+		w0, err := W0(x)
+		require.NoError(t, err)
+		wm1, err := Wm1(x)
+		require.NoError(t, err)
+
+		require.Greater(t, w0, -1.0)
+		require.Less(t, wm1, -1.0)
+		require.InDelta(t, x, w0*math.Exp(w0), 1e-15)
+		require.InDelta(t, x, wm1*math.Exp(wm1), 1e-15)
+	}
+}
+
+func TestInverse(t *testing.T) {
+	t.Parallel()
+
+	for x := -0.36; x < 50; x += 0.0173 {
+		w, err := W0(x)
+		require.NoError(t, err)
+		require.InDelta(t, x, w*math.Exp(w), 1e-14*math.Max(1, math.Abs(x)), "W0(%g)", x)
+	}
+	for x := -0.36; x < 0; x += 0.00173 {
+		w, err := Wm1(x)
+		require.NoError(t, err)
+		require.InDelta(t, x, w*math.Exp(w), 1e-15, "W-1(%g)", x)
+	}
+}
+
+func TestDomain(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		k   int
+		x   float64
+		err error
+	}{
+		{0, -0.5, ErrDomain},
+		{0, math.Inf(-1), ErrDomain},
+		{0, math.Nextafter(BranchPoint, math.Inf(-1)), ErrDomain},
+		{-1, -0.5, ErrDomain},
+		{-1, 1e-300, ErrDomain},
+		{-1, math.Inf(1), ErrDomain},
+		{0, math.NaN(), ErrNaN},
+		{-1, math.NaN(), ErrNaN},
+		{1, 1, ErrBranch},
+		{-2, -0.1, ErrBranch},
+	} {
+		w, err := Branch(tc.k, tc.x)
+		require.Equal(t, tc.err, err, "W%d(%g)", tc.k, tc.x)
+		require.True(t, math.IsNaN(w))
+		require.True(t, math.IsNaN(W(tc.k, tc.x)))
+	}
+}
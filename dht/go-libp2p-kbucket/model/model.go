@@ -0,0 +1,214 @@
+// Package model evaluates the analytic KadRTT model outside of a running node.
+//
+// The optimal bucket size k, the lookup parallelism alpha and the number of peers returned per query beta
+// are computed with the same closed-form expressions the routing table uses when KadRTT is enabled.
+// On top of them, the package estimates the number of hops and the probability that a lookup
+// finds a stored record, for a network of a given size.
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/libp2p/go-libp2p-kbucket/lambertw"
+)
+
+// IDBits is the length of the keys in the keyspace.
+const IDBits = 256
+
+// minProbability is the lowest product of store rate and exchange probability used to size buckets.
+const minProbability = 0.1
+
+// minAlpha is the lowest alpha ever used.
+const minAlpha = 2
+
+// ErrNoOptimum is returned when the model has no optimum for the given parameters,
+// e.g. because the argument of the Lambert W function falls outside its domain.
+var ErrNoOptimum = errors.New("model: no optimum for the given parameters")
+
+// Params are the inputs of the model.
+type Params struct {
+	// NetworkSize is the number of nodes in the network.
+	NetworkSize int
+	// StoreRate is the rate of store requests per second.
+	StoreRate float64
+	// ExchangeProbability is the probability that a full bucket exchanges a peer.
+	ExchangeProbability float64
+	// PoolSize is the number of peers kept in the lookup pool.
+	PoolSize int
+	// BucketSize is the minimum bucket size.
+	BucketSize int
+}
+
+// Validate returns an error if the parameters can't be evaluated.
+func (p Params) Validate() error {
+	switch {
+	case p.NetworkSize < 1:
+		return fmt.Errorf("model: network size must be positive, got %d", p.NetworkSize)
+	case p.StoreRate <= 0:
+		return fmt.Errorf("model: store rate must be positive, got %f", p.StoreRate)
+	case p.ExchangeProbability <= 0 || p.ExchangeProbability > 1:
+		return fmt.Errorf("model: exchange probability must be in (0, 1], got %f", p.ExchangeProbability)
+	case p.PoolSize < 1:
+		return fmt.Errorf("model: pool size must be positive, got %d", p.PoolSize)
+	case p.BucketSize < 1:
+		return fmt.Errorf("model: bucket size must be positive, got %d", p.BucketSize)
+	}
+	return nil
+}
+
+// Bucket holds the model's predictions for the bucket of a common prefix length.
+type Bucket struct {
+	Cpl int `json:"cpl"`
+	// ExpectedPeers is the expected number of nodes sharing exactly Cpl bits with the local node.
+	ExpectedPeers float64 `json:"expected_peers"`
+
+	K     int `json:"k"`
+	Alpha int `json:"alpha"`
+	Beta  int `json:"beta"`
+
+	// PQuery is the probability that a query is answered by a peer of this bucket.
+	PQuery float64 `json:"p_query"`
+	// PNot is the probability that a query is not answered by this bucket or the ones before it.
+	PNot float64 `json:"p_not"`
+
+	// Hops is the expected number of hops of a lookup for a key in this bucket's keyspace.
+	Hops float64 `json:"hops"`
+	// HitProbability is the probability that such a lookup reaches a peer holding the key.
+	HitProbability float64 `json:"hit_probability"`
+}
+
+// Evaluate computes the model for every bucket that is expected to hold at least one peer
+// in a network of the given size, starting at CPL 0.
+func Evaluate(p Params) ([]Bucket, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	var out []Bucket
+	pNot, prevK := 1.0, 0
+	for cpl := 0; cpl < IDBits; cpl++ {
+		expected := float64(p.NetworkSize) / math.Exp2(float64(cpl+1))
+		if cpl > 0 && expected < 1 {
+			break
+		}
+
+		k, err := OptimalK(cpl, p.StoreRate, p.ExchangeProbability, p.BucketSize)
+		if err != nil {
+			k = p.BucketSize
+		}
+		beta := OptimalBeta(k, p.PoolSize)
+		if cpl == 0 {
+			prevK = k
+		}
+		var pQuery float64
+		pQuery, pNot = QueryProbability(pNot, prevK, k)
+		alpha, err := OptimalAlpha(k, beta, p.PoolSize, pQuery)
+		if err != nil {
+			alpha = minAlpha
+		}
+		prevK = k
+
+		b := Bucket{
+			Cpl:           cpl,
+			ExpectedPeers: expected,
+			K:             k,
+			Alpha:         alpha,
+			Beta:          beta,
+			PQuery:        pQuery,
+			PNot:          pNot,
+		}
+		b.Hops = Hops(expected, alpha, beta)
+		b.HitProbability = HitProbability(pQuery, alpha, beta, b.Hops)
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+// OptimalK returns the optimal size of the bucket for the given CPL. The result is never lower
+// than bucketSize.
+func OptimalK(cpl int, storeRate, probExchange float64, bucketSize int) (int, error) {
+	r := IDBits - 1 - cpl
+	prob := math.Max(probExchange*storeRate, minProbability)
+
+	w1 := -1 * math.Pow(2, float64(r)) * math.Pow(math.E, -1*prob)
+	w2 := math.Log(prob)
+	nomi, err := lambertw.W0(w1 * w2)
+	if err != nil || w2 == 0 {
+		return 0, ErrNoOptimum
+	}
+	k := int(math.Max(1, math.Floor(-1*nomi/w2)))
+	if k < 2 {
+		k = 2
+	}
+	if k < bucketSize {
+		k = bucketSize
+	}
+	return k, nil
+}
+
+// OptimalBeta returns the optimal number of peers returned per query for a bucket of size k.
+func OptimalBeta(k, poolSize int) int {
+	beta := int(math.Min(float64(k), float64(poolSize)))
+	if beta < 1 {
+		beta = k
+	}
+	return beta
+}
+
+// QueryProbability returns the probability that a query is answered by a bucket of size k and the
+// probability that it isn't answered by this bucket or any before it, given the latter probability
+// and the size of the previous bucket.
+func QueryProbability(prevPNot float64, prevK, k int) (pQuery, pNot float64) {
+	pro := prevPNot * (1 - 1/float64(prevK))
+	return pro / float64(k), pro * (1 - 1/float64(k))
+}
+
+// OptimalAlpha returns the optimal lookup parallelism for a bucket, given its size, beta, the pool size
+// and the probability that a query is answered by the bucket. The result is capped at poolSize, but
+// never lower than 2.
+func OptimalAlpha(k, beta, poolSize int, pQuery float64) (int, error) {
+	pNot := 1 - pQuery
+	pb := float64(poolSize) * float64(beta)
+	lnp := math.Log(pNot)
+	pow := math.Pow(pNot, pb)
+
+	denomi := pb * float64(k) * lnp
+	w, err := lambertw.W0(-1 * (float64(poolSize) * math.Pow(pNot, pb+pb/(1-pow)) * float64(beta) * lnp) / (pow - 1))
+	w2 := pb * lnp / (1 - pow)
+	alpha := math.Abs(math.Ceil(denomi / (w - w2)))
+	if err != nil || math.IsNaN(alpha) || math.IsInf(alpha, 0) {
+		return 0, ErrNoOptimum
+	}
+
+	if alpha > float64(poolSize) {
+		alpha = float64(poolSize)
+	}
+	if alpha < minAlpha {
+		alpha = minAlpha
+	}
+	return int(alpha), nil
+}
+
+// Hops returns the expected number of hops of a lookup among the given number of peers, when alpha
+// peers are queried in parallel and each returns beta peers. The first hop queries the local bucket
+// and every hop after it resolves log2(1 + alpha*beta) more bits of the key.
+func Hops(peers float64, alpha, beta int) float64 {
+	if peers <= 1 {
+		return 1
+	}
+	return 1 + math.Log2(peers)/math.Log2(1+float64(alpha*beta))
+}
+
+// HitProbability returns the probability that at least one of the alpha*beta peers contacted in each
+// of the given number of hops answers a query, when each one does so with probability pQuery.
+func HitProbability(pQuery float64, alpha, beta int, hops float64) float64 {
+	if pQuery <= 0 {
+		return 0
+	}
+	if pQuery >= 1 {
+		return 1
+	}
+	return 1 - math.Pow(1-pQuery, float64(alpha*beta)*hops)
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	valid := Params{NetworkSize: 1000, StoreRate: 0.5, ExchangeProbability: 0.5, PoolSize: 20, BucketSize: 20}
+	require.NoError(t, valid.Validate())
+
+	for name, mutate := range map[string]func(p *Params){
+		"network size":         func(p *Params) { p.NetworkSize = 0 },
+		"store rate":           func(p *Params) { p.StoreRate = 0 },
+		"exchange probability": func(p *Params) { p.ExchangeProbability = 1.5 },
+		"pool size":            func(p *Params) { p.PoolSize = -1 },
+		"bucket size":          func(p *Params) { p.BucketSize = 0 },
+	} {
+		p := valid
+		mutate(&p)
+		require.Error(t, p.Validate(), name)
+		_, err := Evaluate(p)
+		require.Error(t, err, name)
+	}
+}
+
+func TestOptimalK(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		cpl, bucketSize int
+		k               int
+	}{
+		{0, 2, 123},
+		{100, 2, 74},
+		{250, 2, 2},
+		{250, 20, 20},
+	} {
+		k, err := OptimalK(tc.cpl, 0.5, 0.5, tc.bucketSize)
+		require.NoError(t, err)
+		require.Equal(t, tc.k, k, "cpl %d", tc.cpl)
+	}
+
+	// the argument of W0 falls below -1/e
+	_, err := OptimalK(0, 4, 1, 20)
+	require.Equal(t, ErrNoOptimum, err)
+}
+
+func TestEvaluate(t *testing.T) {
+	t.Parallel()
+
+	p := Params{NetworkSize: 1000, StoreRate: 0.5, ExchangeProbability: 0.5, PoolSize: 10, BucketSize: 20}
+	buckets, err := Evaluate(p)
+	require.NoError(t, err)
+
+	// 1000 nodes fill the buckets up to CPL 8
+	require.Len(t, buckets, 9)
+	for i, b := range buckets {
+		require.Equal(t, i, b.Cpl)
+		require.InDelta(t, 1000/math.Exp2(float64(i+1)), b.ExpectedPeers, 1e-9)
+
+		k, err := OptimalK(i, p.StoreRate, p.ExchangeProbability, p.BucketSize)
+		require.NoError(t, err)
+		require.Equal(t, k, b.K)
+		require.Equal(t, OptimalBeta(k, p.PoolSize), b.Beta)
+		require.LessOrEqual(t, b.Beta, p.PoolSize)
+		require.GreaterOrEqual(t, b.Alpha, 2)
+		require.LessOrEqual(t, b.Alpha, p.PoolSize)
+
+		require.Greater(t, b.PQuery, 0.0)
+		require.GreaterOrEqual(t, b.Hops, 1.0)
+		require.True(t, b.HitProbability >= 0 && b.HitProbability <= 1)
+		if i > 0 {
+			// deeper buckets are reached by fewer queries and need fewer hops
+			require.Less(t, b.PNot, buckets[i-1].PNot)
+			require.LessOrEqual(t, b.Hops, buckets[i-1].Hops)
+		}
+	}
+
+	// a single node only has the first bucket
+	p.NetworkSize = 1
+	buckets, err = Evaluate(p)
+	require.NoError(t, err)
+	require.Len(t, buckets, 1)
+	require.Equal(t, 1.0, buckets[0].Hops)
+}
+
+func TestQueryProbability(t *testing.T) {
+	t.Parallel()
+
+	pQuery, pNot := QueryProbability(1, 4, 2)
+	require.InDelta(t, 0.375, pQuery, 1e-12)
+	require.InDelta(t, 0.375, pNot, 1e-12)
+
+	pQuery, pNot = QueryProbability(pNot, 2, 5)
+	require.InDelta(t, 0.0375, pQuery, 1e-12)
+	require.InDelta(t, 0.15, pNot, 1e-12)
+}
+
+func TestHopsAndHitProbability(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, 1.0, Hops(0.5, 3, 3))
+	// 9 queried peers resolve log2(10) bits per hop
+	require.InDelta(t, 3, Hops(100, 3, 3), 1e-12)
+	require.Less(t, Hops(100, 3, 10), Hops(100, 3, 3))
+
+	require.Zero(t, HitProbability(0, 3, 3, 2))
+	require.Equal(t, 1.0, HitProbability(1, 3, 3, 2))
+	require.InDelta(t, 1-math.Pow(0.9, 18), HitProbability(0.1, 3, 3, 2), 1e-12)
+}
@@ -0,0 +1,244 @@
+package kbucket
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/libp2p/go-libp2p-kbucket/clock"
+	"github.com/libp2p/go-libp2p-kbucket/vivaldi"
+)
+
+// DefaultRTTInterval is the default interval after which KadRTT re-derives the
+// store rate, the exchange probability and the per-bucket k, alpha and beta.
+const DefaultRTTInterval = 180 * time.Second
+
+// Option is a function that configures a RoutingTable.
+type Option func(*RoutingTable) error
+
+func (rt *RoutingTable) applyOptions(opts ...Option) error {
+	for i, opt := range opts {
+		if err := opt(rt); err != nil {
+			return fmt.Errorf("routing table option %d failed: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// KadRTT enables or disables the KadRTT mode of the routing table.
+// In KadRTT mode, the size of each bucket and the lookup parameters are derived
+// from the observed STORE arrival rate and entry exchange probability, and full
+// buckets are rearranged according to the RTT and the ID variance of their peers.
+//
+// Defaults to false, i.e. classic Kademlia behaviour.
+func KadRTT(enabled bool) Option {
+	return func(rt *RoutingTable) error {
+		rt.isKadRTT = enabled
+		return nil
+	}
+}
+
+// RTTInterval sets the interval after which KadRTT re-derives its parameters.
+//
+// Defaults to DefaultRTTInterval.
+func RTTInterval(d time.Duration) Option {
+	return func(rt *RoutingTable) error {
+		if d <= 0 {
+			return fmt.Errorf("rtt interval must be positive, got %s", d)
+		}
+		rt.stats.interval = d
+		return nil
+	}
+}
+
+// InitialStoreRate sets the STORE message arrival rate KadRTT assumes until
+// the first rttInterval has elapsed.
+//
+// Defaults to 0.5.
+func InitialStoreRate(rate float64) Option {
+	return func(rt *RoutingTable) error {
+		if rate <= 0 {
+			return fmt.Errorf("store rate must be positive, got %f", rate)
+		}
+		rt.stats.storeRate = rate
+		return nil
+	}
+}
+
+// InitialExchangeProbability sets the k-bucket entry exchange probability KadRTT
+// assumes until the first rttInterval has elapsed.
+//
+// Defaults to 0.5.
+func InitialExchangeProbability(prob float64) Option {
+	return func(rt *RoutingTable) error {
+		if prob <= 0 || prob > 1 {
+			return fmt.Errorf("exchange probability must be in (0, 1], got %f", prob)
+		}
+		rt.stats.probExchange = prob
+		return nil
+	}
+}
+
+// PoolSize sets the number of peers KadRTT assumes to be queried in parallel
+// when deriving the optimal alpha and beta of each bucket.
+//
+// Defaults to the bucket size.
+func PoolSize(size int) Option {
+	return func(rt *RoutingTable) error {
+		if size <= 0 {
+			return fmt.Errorf("pool size must be positive, got %d", size)
+		}
+		rt.basePoolSize = size
+		return nil
+	}
+}
+
+// BucketKRange bounds the k KadRTT derives for every bucket to [minK, maxK]. A maxK of 0 leaves k unbounded.
+//
+// Defaults to DefaultMinBucketK and 0.
+func BucketKRange(minK, maxK int) Option {
+	return func(rt *RoutingTable) error {
+		if minK < 1 {
+			return fmt.Errorf("minimum bucket k must be positive, got %d", minK)
+		}
+		if maxK != 0 && maxK < minK {
+			return fmt.Errorf("maximum bucket k %d is lower than the minimum %d", maxK, minK)
+		}
+		rt.minBucketK, rt.maxBucketK = minK, maxK
+		return nil
+	}
+}
+
+// MaxEntries bounds the number of peers in a KadRTT table. When the k KadRTT derives for the buckets add up
+// to more than n, they are scaled down together, keeping their ratios, but none below the minimum set with
+// BucketKRange; the peers beyond the new capacities are evicted as soon as the parameters are re-derived,
+// whether at the end of an rttInterval window or on a bucket split.
+// 0 leaves the table unbounded.
+//
+// Defaults to 0.
+func MaxEntries(n int) Option {
+	return func(rt *RoutingTable) error {
+		if n < 0 {
+			return fmt.Errorf("max entries must not be negative, got %d", n)
+		}
+		rt.maxEntries = n
+		return nil
+	}
+}
+
+// Admission sets the policy deciding whether a peer is admitted into a full bucket.
+//
+// Defaults to IDVariancePolicy in KadRTT mode and to ReplaceablePolicy otherwise.
+func Admission(policy AdmissionPolicy) Option {
+	return func(rt *RoutingTable) error {
+		if policy == nil {
+			return fmt.Errorf("admission policy must not be nil")
+		}
+		rt.admission = policy
+		return nil
+	}
+}
+
+// IDDispersion sets how KadRTT measures the spread of the IDs in a bucket when it picks
+// the peers to evict.
+//
+// Defaults to DispersionVariance.
+func IDDispersion(d Dispersion) Option {
+	return func(rt *RoutingTable) error {
+		if d != DispersionVariance && d != DispersionMaxGap {
+			return fmt.Errorf("unknown dispersion measure %d", d)
+		}
+		rt.dispersion = d
+		return nil
+	}
+}
+
+// RTTMaxAge sets the age after which the RTT measured for a peer is considered stale,
+// in which case the peerstore latency is used instead.
+//
+// Defaults to DefaultRTTMaxAge.
+func RTTMaxAge(d time.Duration) Option {
+	return func(rt *RoutingTable) error {
+		if d <= 0 {
+			return fmt.Errorf("rtt max age must be positive, got %s", d)
+		}
+		rt.rttMaxAge = d
+		return nil
+	}
+}
+
+// XORTrieIndex enables or disables an index of the peers in a binary XOR trie. With the index,
+// the closest peers to a key are found in O(log n + count) instead of by collecting and sorting
+// the peers of the nearby buckets, at the cost of keeping the index up to date.
+//
+// Defaults to false.
+func XORTrieIndex(enabled bool) Option {
+	return func(rt *RoutingTable) error {
+		if enabled {
+			rt.trie = newXORTrie()
+		} else {
+			rt.trie = nil
+		}
+		return nil
+	}
+}
+
+// ReplacementCacheSize sets the number of peers each bucket remembers after rejecting them because it
+// was full. When a peer is removed with RemovePeer, the best of them, by RTT and freshness, takes its place.
+// A size of 0 disables the cache.
+//
+// Defaults to DefaultReplacementCacheSize in KadRTT mode and to 0 otherwise.
+func ReplacementCacheSize(n int) Option {
+	return func(rt *RoutingTable) error {
+		if n < 0 {
+			return fmt.Errorf("replacement cache size must not be negative, got %d", n)
+		}
+		rt.replacementCacheSize = n
+		return nil
+	}
+}
+
+// DefaultDiversityWeight is the default weight of the diversity score in the KadRTT admission policy.
+const DefaultDiversityWeight = 1.0
+
+// DiversityWeight sets how much the default KadRTT admission policy penalizes the peers whose IP groups are
+// crowded in the soft diversity filter of the table; see IDVariancePolicy. It has no effect unless the
+// filter is soft, and 0 compares the raw RTTs.
+//
+// Defaults to DefaultDiversityWeight.
+func DiversityWeight(w float64) Option {
+	return func(rt *RoutingTable) error {
+		if w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			return fmt.Errorf("diversity weight must be a non-negative number, got %v", w)
+		}
+		rt.diversityWeight = w
+		return nil
+	}
+}
+
+// NetworkCoordinates sets the Vivaldi client that estimates the RTT of the peers whose RTT isn't known
+// or is stale, from their network coordinates. A nil client disables the estimation.
+//
+// Defaults to nil.
+func NetworkCoordinates(c *vivaldi.Client) Option {
+	return func(rt *RoutingTable) error {
+		rt.coords = c
+		return nil
+	}
+}
+
+// Clock sets the clock the table tells the time with: the time of the RTT samples, the KadRTT windows
+// after which the parameters are re-derived, and the usefulness and freshness of the peers. Tests pass
+// a clock.Mock to drive several KadRTT intervals without sleeping.
+//
+// Defaults to the wall clock.
+func Clock(c clock.Clock) Option {
+	return func(rt *RoutingTable) error {
+		if c == nil {
+			return fmt.Errorf("clock must not be nil")
+		}
+		rt.clock = c
+		rt.stats.setClock(c)
+		return nil
+	}
+}
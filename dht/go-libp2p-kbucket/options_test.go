@@ -0,0 +1,72 @@
+package kbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/test"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutingTableDefaultOptions(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(10, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil)
+	require.NoError(t, err)
+
+	require.False(t, rt.isKadRTT)
+	require.Equal(t, DefaultRTTInterval, rt.stats.interval)
+	require.Equal(t, 0.5, rt.AdaptationStats().StoreRate)
+	require.Equal(t, 0.5, rt.AdaptationStats().ExchangeProbability)
+	require.Equal(t, 10, rt.pool_size)
+}
+
+func TestRoutingTableKadRTTOptions(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(10, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil,
+		KadRTT(true),
+		RTTInterval(30*time.Second),
+		InitialStoreRate(2),
+		InitialExchangeProbability(0.25),
+		PoolSize(4),
+	)
+	require.NoError(t, err)
+
+	require.True(t, rt.isKadRTT)
+	require.Equal(t, 30*time.Second, rt.stats.interval)
+	require.Equal(t, 2.0, rt.AdaptationStats().StoreRate)
+	require.Equal(t, 0.25, rt.AdaptationStats().ExchangeProbability)
+	require.Equal(t, 4, rt.pool_size)
+
+	// the configured pool size survives a parameter recalculation
+	rt.configPool()
+	require.Equal(t, 4, rt.pool_size)
+
+	// instances are independent of each other
+	rt2, err := NewRoutingTable(10, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil)
+	require.NoError(t, err)
+	require.False(t, rt2.isKadRTT)
+}
+
+func TestRoutingTableInvalidOptions(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	for _, opt := range []Option{
+		RTTInterval(0),
+		InitialStoreRate(-1),
+		InitialExchangeProbability(0),
+		InitialExchangeProbability(1.5),
+		PoolSize(0),
+		IDDispersion(Dispersion(7)),
+	} {
+		_, err := NewRoutingTable(10, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil, opt)
+		require.Error(t, err)
+	}
+}
@@ -89,6 +89,9 @@ type Filter struct {
 	cplPeerGroups map[int]map[peer.ID][]PeerIPGroupKey
 
 	asnStore asnStore
+
+	// in soft mode, peers are scored instead of being rejected by Allow
+	soft bool
 }
 
 // NewFilter creates a Filter for Peer Diversity.
@@ -139,6 +142,25 @@ func (f *Filter) Remove(p peer.ID) {
 	}
 }
 
+// SetSoft switches the Filter between its default, hard, mode and its soft mode.
+// In soft mode, TryAdd doesn't reject the peers the PeerIPGroupFilter disallows; they are added
+// to the Filter state like the others, and callers rank peers with Score instead.
+// Peers whose IP groups can't be determined are rejected in both modes.
+func (f *Filter) SetSoft(soft bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.soft = soft
+}
+
+// IsSoft returns true if the Filter is in soft mode.
+func (f *Filter) IsSoft() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.soft
+}
+
 // TryAdd attempts to add the peer to the Filter state and returns true if it's successful, false otherwise.
 func (f *Filter) TryAdd(p peer.ID) bool {
 	f.mu.Lock()
@@ -149,12 +171,40 @@ func (f *Filter) TryAdd(p peer.ID) bool {
 	}
 
 	cpl := f.cplFnc(p)
+	peerGroups, ok := f.groupsFor(p, cpl)
+	if !ok {
+		return false
+	}
+	if !f.soft {
+		for _, group := range peerGroups {
+			if !f.pgm.Allow(group) {
+				return false
+			}
+		}
+	}
+
+	if _, ok := f.cplPeerGroups[cpl]; !ok {
+		f.cplPeerGroups[cpl] = make(map[peer.ID][]PeerIPGroupKey)
+	}
+
+	for _, g := range peerGroups {
+		f.pgm.Increment(g)
+
+		f.peerGroups[p] = append(f.peerGroups[p], g)
+		f.cplPeerGroups[cpl][p] = append(f.cplPeerGroups[cpl][p], g.IPGroupKey)
+	}
 
+	return true
+}
+
+// groupsFor returns the IP groups of the peer, one per address, or false if they can't be determined.
+// locking is the responsibility of the caller
+func (f *Filter) groupsFor(p peer.ID, cpl int) ([]PeerGroupInfo, bool) {
 	// don't allow peers for which we can't determine addresses.
 	addrs := f.pgm.PeerAddresses(p)
 	if len(addrs) == 0 {
 		dfLog.Debugw("no addresses found for peer", "appKey", f.logKey, "peer", p.Pretty())
-		return false
+		return nil, false
 	}
 
 	peerGroups := make([]PeerGroupInfo, 0, len(addrs))
@@ -163,7 +213,7 @@ func (f *Filter) TryAdd(p peer.ID) bool {
 		if err != nil {
 			dfLog.Errorw("failed to parse IP from multiaddr", "appKey", f.logKey,
 				"multiaddr", a.String(), "err", err)
-			return false
+			return nil, false
 		}
 
 		// reject the peer if we can't determine a grouping for one of it's address.
@@ -171,33 +221,58 @@ func (f *Filter) TryAdd(p peer.ID) bool {
 		if err != nil {
 			dfLog.Errorw("failed to find Group Key", "appKey", f.logKey, "ip", ip.String(), "peer", p,
 				"err", err)
-			return false
+			return nil, false
 		}
 		if len(key) == 0 {
 			dfLog.Errorw("group key is empty", "appKey", f.logKey, "ip", ip.String(), "peer", p)
-			return false
+			return nil, false
 		}
-		group := PeerGroupInfo{Id: p, Cpl: cpl, IPGroupKey: key}
+		peerGroups = append(peerGroups, PeerGroupInfo{Id: p, Cpl: cpl, IPGroupKey: key})
+	}
+	return peerGroups, true
+}
 
-		if !f.pgm.Allow(group) {
-			return false
-		}
+// Score returns how diverse the IP groups of the peer are among the other peers of the Filter with the same Cpl,
+// whether or not the peer was added to the Filter. It is 1 if none of them shares an IP group with the peer, and
+// 1/(n+1) if n of them do. Whitelisted peers score 1, and peers whose IP groups can't be determined score 0.
+func (f *Filter) Score(p peer.ID) float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-		peerGroups = append(peerGroups, group)
+	if _, ok := f.wlpeers[p]; ok {
+		return 1
 	}
 
-	if _, ok := f.cplPeerGroups[cpl]; !ok {
-		f.cplPeerGroups[cpl] = make(map[peer.ID][]PeerIPGroupKey)
+	cpl := f.cplFnc(p)
+	keys, ok := f.cplPeerGroups[cpl][p]
+	if !ok {
+		groups, ok := f.groupsFor(p, cpl)
+		if !ok {
+			return 0
+		}
+		for _, g := range groups {
+			keys = append(keys, g.IPGroupKey)
+		}
 	}
 
-	for _, g := range peerGroups {
-		f.pgm.Increment(g)
-
-		f.peerGroups[p] = append(f.peerGroups[p], g)
-		f.cplPeerGroups[cpl][p] = append(f.cplPeerGroups[cpl][p], g.IPGroupKey)
+	shared := 0
+	for other, otherKeys := range f.cplPeerGroups[cpl] {
+		if other != p && sharesGroup(keys, otherKeys) {
+			shared++
+		}
 	}
+	return 1 / float64(shared+1)
+}
 
-	return true
+func sharesGroup(a, b []PeerIPGroupKey) bool {
+	for _, ka := range a {
+		for _, kb := range b {
+			if ka == kb {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // WhitelistPeers will always allow the given peers.
@@ -314,3 +314,54 @@ func TestGetDiversityStats(t *testing.T) {
 	require.Len(t, stats[1].Peers[p3], 1)
 	require.Len(t, stats[1].Peers[p4], 1)
 }
+
+func TestSoftFilterScore(t *testing.T) {
+	paddrs := map[peer.ID][]ma.Multiaddr{
+		"p1": {ma.StringCast("/ip4/192.168.1.1/tcp/0")},
+		"p2": {ma.StringCast("/ip4/192.168.2.1/tcp/0")},
+		"p3": {ma.StringCast("/ip4/192.168.3.1/tcp/0"), ma.StringCast("/ip4/10.1.0.1/tcp/0")},
+		"p4": {ma.StringCast("/ip4/172.16.0.1/tcp/0")},
+		"p5": {ma.StringCast("/ip4/192.168.4.1/tcp/0")},
+	}
+
+	m := newMockPeerGroupFilter()
+	m.peerAddressFunc = func(p peer.ID) []ma.Multiaddr {
+		return paddrs[p]
+	}
+	// at most one peer per group
+	groups := make(map[PeerIPGroupKey]int)
+	m.allowFnc = func(g PeerGroupInfo) bool {
+		return groups[g.IPGroupKey] == 0
+	}
+
+	f, err := NewFilter(m, "test", func(p peer.ID) int { return 1 })
+	require.NoError(t, err)
+	require.False(t, f.IsSoft())
+
+	require.True(t, f.TryAdd("p1"))
+	groups["192.168.0.0"]++
+	require.False(t, f.TryAdd("p2"))
+
+	// the soft filter lets the crowded peers in
+	f.SetSoft(true)
+	require.True(t, f.IsSoft())
+	require.True(t, f.TryAdd("p2"))
+	require.True(t, f.TryAdd("p3"))
+	require.True(t, f.TryAdd("p4"))
+	// but not the peers it can't group
+	require.False(t, f.TryAdd("unknown"))
+
+	// p1, p2 and p3 share 192.168.0.0
+	require.Equal(t, 1.0/3, f.Score("p1"))
+	require.Equal(t, 1.0/3, f.Score("p3"))
+	require.Equal(t, 1.0, f.Score("p4"))
+	// peers that weren't added are scored against all the others
+	require.Equal(t, 1.0/4, f.Score("p5"))
+	require.Zero(t, f.Score("unknown"))
+
+	f.Remove("p2")
+	require.Equal(t, 1.0/2, f.Score("p1"))
+
+	f.WhitelistPeers("p5")
+	require.Equal(t, 1.0, f.Score("p5"))
+}
@@ -0,0 +1,97 @@
+package kbucket
+
+import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// RankedPeer is a candidate handed to a RankingPolicy.
+type RankedPeer struct {
+	Peer peer.ID
+	// Distance is the XOR distance between the peer and the target.
+	Distance ID
+	// RTT is the smoothed RTT of the peer if it is fresh, or else the peerstore latency. 0 means unknown.
+	RTT time.Duration
+}
+
+// RankingPolicy orders the peers returned by NearestPeersByPolicy.
+//
+// Rank is given the XOR-closest peers to the target, closest first, and reorders them in place.
+// It is called without the routing table lock held.
+type RankingPolicy interface {
+	Rank(peers []RankedPeer)
+}
+
+// RankingFunc adapts a function to a RankingPolicy.
+type RankingFunc func(peers []RankedPeer)
+
+// Rank implements RankingPolicy.
+func (f RankingFunc) Rank(peers []RankedPeer) {
+	f(peers)
+}
+
+// XORRanking keeps the peers sorted by XOR distance, as NearestPeers does.
+type XORRanking struct{}
+
+var _ RankingPolicy = XORRanking{}
+
+// Rank implements RankingPolicy.
+func (XORRanking) Rank(peers []RankedPeer) {}
+
+// RTTRanking sorts the peers by increasing RTT. Peers with an unknown RTT go last,
+// ties are broken by distance.
+type RTTRanking struct{}
+
+var _ RankingPolicy = RTTRanking{}
+
+// Rank implements RankingPolicy.
+func (RTTRanking) Rank(peers []RankedPeer) {
+	sort.SliceStable(peers, func(a, b int) bool {
+		return rttLess(peers[a], peers[b])
+	})
+}
+
+func rttLess(a, b RankedPeer) bool {
+	switch {
+	case a.RTT == b.RTT:
+		return a.Distance.less(b.Distance)
+	case a.RTT == 0:
+		return false
+	case b.RTT == 0:
+		return true
+	default:
+		return a.RTT < b.RTT
+	}
+}
+
+// KadRTTRanking is the KadRTT ranking. Peers less than twice as far from the target as the closest one
+// and at least as fast as it come first, the rest follow. Both groups are sorted as by RTTRanking.
+// Peers without a known RTT are never preferred.
+type KadRTTRanking struct{}
+
+var _ RankingPolicy = KadRTTRanking{}
+
+// Rank implements RankingPolicy.
+func (KadRTTRanking) Rank(peers []RankedPeer) {
+	if len(peers) == 0 {
+		return
+	}
+
+	first := peers[0]
+	maxDist := idToInt(first.Distance)
+	maxDist.Lsh(maxDist, 1)
+	ok := make([]RankedPeer, 0, len(peers))
+	ng := make([]RankedPeer, 0, len(peers))
+	for _, p := range peers {
+		if idToInt(p.Distance).Cmp(maxDist) < 0 && p.RTT > 0 && (first.RTT == 0 || p.RTT <= first.RTT) {
+			ok = append(ok, p)
+		} else {
+			ng = append(ng, p)
+		}
+	}
+	RTTRanking{}.Rank(ok)
+	RTTRanking{}.Rank(ng)
+	copy(peers[copy(peers, ok):], ng)
+}
@@ -0,0 +1,98 @@
+package kbucket
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func rankedPeer(dist int64, rtt time.Duration) RankedPeer {
+	return RankedPeer{Peer: peer.ID(big.NewInt(dist).String()), Distance: ID(big.NewInt(dist).Bytes()), RTT: rtt}
+}
+
+func rankedIDs(peers []RankedPeer) []peer.ID {
+	out := make([]peer.ID, len(peers))
+	for i, p := range peers {
+		out[i] = p.Peer
+	}
+	return out
+}
+
+func TestRankingPolicies(t *testing.T) {
+	t.Parallel()
+
+	closest := rankedPeer(10, 50*time.Millisecond)
+	nearFast := rankedPeer(15, 20*time.Millisecond)
+	nearFaster := rankedPeer(19, 10*time.Millisecond)
+	nearSlow := rankedPeer(12, 80*time.Millisecond)
+	nearUnknown := rankedPeer(11, 0)
+	far := rankedPeer(40, time.Millisecond)
+	candidates := func() []RankedPeer {
+		return []RankedPeer{closest, nearUnknown, nearSlow, nearFast, nearFaster, far}
+	}
+
+	peers := candidates()
+	XORRanking{}.Rank(peers)
+	require.Equal(t, rankedIDs(candidates()), rankedIDs(peers))
+
+	peers = candidates()
+	RTTRanking{}.Rank(peers)
+	require.Equal(t, rankedIDs([]RankedPeer{far, nearFaster, nearFast, closest, nearSlow, nearUnknown}), rankedIDs(peers))
+
+	// far away and slower peers come after the fast peers close to the target
+	peers = candidates()
+	KadRTTRanking{}.Rank(peers)
+	require.Equal(t, rankedIDs([]RankedPeer{nearFaster, nearFast, closest, far, nearSlow, nearUnknown}), rankedIDs(peers))
+
+	KadRTTRanking{}.Rank(nil)
+}
+
+func TestNearestPeersByPolicy(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(20, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil, KadRTT(true))
+	require.NoError(t, err)
+
+	for i := 0; i < 30; i++ {
+		_, err := rt.TryAddPeerKadRTT(test.RandPeerIDFatal(t), true, true, time.Duration(1+i%7)*time.Millisecond)
+		require.NoError(t, err)
+	}
+	target := ConvertPeerID(test.RandPeerIDFatal(t))
+
+	// NearestPeers returns exactly the 'count' closest peers, even in KadRTT mode
+	nearest := rt.NearestPeers(target, 7)
+	require.Len(t, nearest, 7)
+	require.Equal(t, SortClosestPeers(rt.ListPeers(), target)[:7], nearest)
+
+	require.Equal(t, nearest, rt.NearestPeersByPolicy(target, 7, XORRanking{}))
+
+	// the same peers, ranked differently
+	byRTT := rt.NearestPeersByPolicy(target, 7, RTTRanking{})
+	require.ElementsMatch(t, nearest, byRTT)
+	for i := 1; i < len(byRTT); i++ {
+		require.LessOrEqual(t, int64(rt.GetRTT(byRTT[i-1])), int64(rt.GetRTT(byRTT[i])))
+	}
+	require.ElementsMatch(t, nearest, rt.NearestPeersByPolicy(target, 7, KadRTTRanking{}))
+
+	var got []RankedPeer
+	reversed := rt.NearestPeersByPolicy(target, 7, RankingFunc(func(peers []RankedPeer) {
+		got = append(got, peers...)
+		for i, j := 0, len(peers)-1; i < j; i, j = i+1, j-1 {
+			peers[i], peers[j] = peers[j], peers[i]
+		}
+	}))
+	require.Len(t, got, 7)
+	for i, p := range got {
+		require.Equal(t, nearest[i], p.Peer)
+		require.Equal(t, rt.GetRTT(p.Peer), p.RTT)
+		require.Equal(t, nearest[len(nearest)-1-i], reversed[i])
+	}
+}
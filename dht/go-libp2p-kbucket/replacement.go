@@ -0,0 +1,175 @@
+package kbucket
+
+import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// DefaultReplacementCacheSize is the default number of candidates remembered per bucket in KadRTT mode.
+const DefaultReplacementCacheSize = 8
+
+// ReplacementInfo is a snapshot of a peer waiting in the replacement cache of a bucket.
+type ReplacementInfo struct {
+	Id peer.ID `json:"id"`
+	// RTT is the smoothed RTT measured for the peer, or 0 if it is unknown.
+	RTT time.Duration `json:"rtt"`
+	// LastSeenAt is the last time the peer was offered to the table.
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// replacementCache keeps the peers that were rejected because their bucket was full, best first,
+// so that one of them can take the place of a peer removed from the bucket later on.
+// As with the bucket, access is synchronized on the Routing Table lock.
+type replacementCache struct {
+	peers []*PeerInfo
+}
+
+// replacementLess orders the candidates of a replacement cache: peers with a fresh RTT come first,
+// by increasing RTT, then the others, most recently seen first.
+func replacementLess(p1, p2 *PeerInfo, now time.Time, maxAge time.Duration) bool {
+	fresh1, fresh2 := !p1.rtt.IsStale(now, maxAge), !p2.rtt.IsStale(now, maxAge)
+	if fresh1 != fresh2 {
+		return fresh1
+	}
+	if fresh1 {
+		if r1, r2 := p1.rtt.EWMA(), p2.rtt.EWMA(); r1 != r2 {
+			return r1 < r2
+		}
+	}
+	return p1.LastSuccessfulOutboundQueryAt.After(p2.LastSuccessfulOutboundQueryAt)
+}
+
+// sort orders the cache as of now; freshness changes with time, so it is re-sorted on every use.
+func (c *replacementCache) sort(now time.Time, maxAge time.Duration) {
+	sort.SliceStable(c.peers, func(i, j int) bool {
+		return replacementLess(c.peers[i], c.peers[j], now, maxAge)
+	})
+}
+
+// add remembers the candidate, keeping at most max peers. If the peer is already cached, its entry is
+// refreshed with the RTT of the candidate. It returns false if the candidate didn't make it into the cache.
+func (c *replacementCache) add(pi *PeerInfo, max int, now time.Time, maxAge time.Duration) bool {
+	if max <= 0 {
+		return false
+	}
+	if old := c.get(pi.Id); old != nil {
+		if rtt := pi.rtt.EWMA(); rtt > 0 {
+			old.rtt.AddSample(rtt, now)
+		}
+		old.LastSuccessfulOutboundQueryAt = pi.LastSuccessfulOutboundQueryAt
+		old.replaceable = pi.replaceable
+		c.sort(now, maxAge)
+		return true
+	}
+
+	c.peers = append(c.peers, pi)
+	c.sort(now, maxAge)
+	if len(c.peers) > max {
+		dropped := c.peers[max]
+		c.peers[max] = nil
+		c.peers = c.peers[:max]
+		return dropped != pi
+	}
+	return true
+}
+
+func (c *replacementCache) get(id peer.ID) *PeerInfo {
+	for _, pi := range c.peers {
+		if pi.Id == id {
+			return pi
+		}
+	}
+	return nil
+}
+
+// remove forgets the given peer; it returns true if it was cached.
+func (c *replacementCache) remove(id peer.ID) bool {
+	for i, pi := range c.peers {
+		if pi.Id == id {
+			copy(c.peers[i:], c.peers[i+1:])
+			c.peers[len(c.peers)-1] = nil
+			c.peers = c.peers[:len(c.peers)-1]
+			return true
+		}
+	}
+	return false
+}
+
+// take empties the cache and returns its peers, best first.
+func (c *replacementCache) take(now time.Time, maxAge time.Duration) []*PeerInfo {
+	c.sort(now, maxAge)
+	peers := c.peers
+	c.peers = nil
+	return peers
+}
+
+func (c *replacementCache) len() int {
+	return len(c.peers)
+}
+
+// split moves the peers with a CPL greater than cpl with the target to the returned cache,
+// the same way bucket.split does.
+func (c *replacementCache) split(cpl int, target ID) *replacementCache {
+	out := &replacementCache{}
+	kept := c.peers[:0]
+	for _, pi := range c.peers {
+		if CommonPrefixLen(pi.dhtId, target) > cpl {
+			out.peers = append(out.peers, pi)
+		} else {
+			kept = append(kept, pi)
+		}
+	}
+	for i := len(kept); i < len(c.peers); i++ {
+		c.peers[i] = nil
+	}
+	c.peers = kept
+	return out
+}
+
+func (c *replacementCache) infos() []ReplacementInfo {
+	var infos []ReplacementInfo
+	for _, pi := range c.peers {
+		infos = append(infos, ReplacementInfo{
+			Id:         pi.Id,
+			RTT:        pi.rtt.EWMA(),
+			LastSeenAt: pi.LastSuccessfulOutboundQueryAt,
+		})
+	}
+	return infos
+}
+
+// cacheReplacement remembers a peer rejected for lack of capacity in the cache of its bucket.
+// locking is the responsibility of the caller
+func (rt *RoutingTable) cacheReplacement(pi *PeerInfo, now time.Time) {
+	b := rt.buckets[rt.bucketIdForPeer(pi.Id)]
+	b.replacements.add(pi, rt.replacementCacheSize, now, rt.rttMaxAge)
+}
+
+// refillBucket tries the given cached peers, best first, until one of them gets into the table.
+// The ones left over go back to the cache.
+// locking is the responsibility of the caller
+func (rt *RoutingTable) refillBucket(candidates []*PeerInfo, now time.Time) {
+	for i, pi := range candidates {
+		pi.AddedAt = now
+		ok, err := rt.insertPeer(pi, pi.rtt.EWMA(), now)
+		if ok {
+			log.Debugf("replaced removed peer with cached peer %s", pi.Id)
+			for _, rest := range candidates[i+1:] {
+				rt.cacheReplacement(rest, now)
+			}
+			return
+		}
+		log.Debugf("cached peer %s not admitted: %s", pi.Id, err)
+	}
+}
+
+// mergeReplacements moves the replacement cache of a bucket that is being folded into the bucket taking over its peers.
+// locking is the responsibility of the caller
+func (rt *RoutingTable) mergeReplacements(into, from *bucket) {
+	now := rt.clock.Now()
+	for _, pi := range from.replacements.take(now, rt.rttMaxAge) {
+		into.replacements.add(pi, rt.replacementCacheSize, now, rt.rttMaxAge)
+	}
+}
@@ -0,0 +1,152 @@
+package kbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func replacementIds(c *replacementCache) []peer.ID {
+	var ids []peer.ID
+	for _, pi := range c.peers {
+		ids = append(ids, pi.Id)
+	}
+	return ids
+}
+
+func TestReplacementCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	maxAge := time.Minute
+	fast, slow := randPeerInfo(t, true, time.Millisecond), randPeerInfo(t, true, time.Second)
+	unknown := randPeerInfo(t, true, 0)
+	stale := randPeerInfo(t, true, 0)
+	stale.rtt.AddSample(time.Microsecond, now.Add(-time.Hour))
+
+	c := &replacementCache{}
+	require.False(t, c.add(&fast, 0, now, maxAge))
+	require.Zero(t, c.len())
+
+	// peers with a fresh RTT come first, fastest first
+	require.True(t, c.add(&unknown, 3, now, maxAge))
+	require.True(t, c.add(&slow, 3, now, maxAge))
+	require.True(t, c.add(&stale, 3, now, maxAge))
+	require.Equal(t, []peer.ID{slow.Id, unknown.Id, stale.Id}, replacementIds(c))
+
+	// the worst one makes room for a better candidate
+	require.True(t, c.add(&fast, 3, now, maxAge))
+	require.Equal(t, []peer.ID{fast.Id, slow.Id, unknown.Id}, replacementIds(c))
+
+	// and a worse candidate doesn't get in
+	require.False(t, c.add(&stale, 3, now, maxAge))
+	require.Equal(t, 3, c.len())
+
+	// offering a cached peer again refreshes its RTT
+	again := PeerInfo{Id: unknown.Id, dhtId: unknown.dhtId}
+	again.rtt.AddSample(time.Microsecond, now)
+	require.True(t, c.add(&again, 3, now, maxAge))
+	require.Equal(t, []peer.ID{unknown.Id, fast.Id, slow.Id}, replacementIds(c))
+
+	require.True(t, c.remove(fast.Id))
+	require.False(t, c.remove(fast.Id))
+	require.Equal(t, []peer.ID{unknown.Id, slow.Id}, replacementIds(c))
+
+	require.Len(t, c.take(now, maxAge), 2)
+	require.Zero(t, c.len())
+}
+
+func TestReplacementCacheSplit(t *testing.T) {
+	t.Parallel()
+
+	local := ConvertPeerID(test.RandPeerIDFatal(t))
+	c := &replacementCache{}
+	for i := 0; i < 20; i++ {
+		pi := randPeerInfo(t, true, time.Millisecond)
+		c.peers = append(c.peers, &pi)
+	}
+
+	out := c.split(0, local)
+	require.Equal(t, 20, c.len()+out.len())
+	for _, pi := range c.peers {
+		require.Equal(t, 0, CommonPrefixLen(pi.dhtId, local))
+	}
+	for _, pi := range out.peers {
+		require.Greater(t, CommonPrefixLen(pi.dhtId, local), 0)
+	}
+}
+
+func TestReplacementCacheRefill(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(2, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil, ReplacementCacheSize(2))
+	require.NoError(t, err)
+
+	var peers []peer.ID
+	for i := 0; i < 5; i++ {
+		p, err := rt.GenRandPeerID(0)
+		require.NoError(t, err)
+		peers = append(peers, p)
+	}
+	for _, p := range peers[:2] {
+		b, err := rt.TryAddPeer(p, true, false)
+		require.NoError(t, err)
+		require.True(t, b)
+	}
+
+	// the bucket is full, the rejected peers are cached by RTT
+	for i, rtt := range []time.Duration{30, 10, 20} {
+		b, err := rt.TryAddPeerKadRTT(peers[2+i], true, false, rtt*time.Millisecond)
+		require.Equal(t, ErrPeerRejectedNoCapacity, err)
+		require.False(t, b)
+	}
+	replacements := rt.BucketParamsForCpl(0).Replacements
+	require.Len(t, replacements, 2)
+	require.Equal(t, peers[3], replacements[0].Id)
+	require.Equal(t, 10*time.Millisecond, replacements[0].RTT)
+	require.Equal(t, peers[4], replacements[1].Id)
+
+	// the fastest cached peer takes the place of a removed peer
+	rt.RemovePeer(peers[0])
+	require.Equal(t, 2, rt.Size())
+	require.Equal(t, peers[3], rt.Find(peers[3]))
+	replacements = rt.BucketParamsForCpl(0).Replacements
+	require.Len(t, replacements, 1)
+	require.Equal(t, peers[4], replacements[0].Id)
+
+	// removing a cached peer just forgets it
+	rt.RemovePeer(peers[4])
+	require.Empty(t, rt.BucketParamsForCpl(0).Replacements)
+	require.Equal(t, 2, rt.Size())
+
+	// with nobody to replace it, the bucket shrinks
+	rt.RemovePeer(peers[1])
+	require.Equal(t, 1, rt.Size())
+}
+
+func TestReplacementCacheSizeDefaults(t *testing.T) {
+	t.Parallel()
+
+	local := ConvertPeerID(test.RandPeerIDFatal(t))
+	rt, err := NewRoutingTable(2, local, time.Hour, pstore.NewMetrics(), NoOpThreshold, nil)
+	require.NoError(t, err)
+	require.Zero(t, rt.replacementCacheSize)
+
+	rt, err = NewRoutingTable(2, local, time.Hour, pstore.NewMetrics(), NoOpThreshold, nil, KadRTT(true))
+	require.NoError(t, err)
+	require.Equal(t, DefaultReplacementCacheSize, rt.replacementCacheSize)
+
+	rt, err = NewRoutingTable(2, local, time.Hour, pstore.NewMetrics(), NoOpThreshold, nil, KadRTT(true), ReplacementCacheSize(0))
+	require.NoError(t, err)
+	require.Zero(t, rt.replacementCacheSize)
+
+	_, err = NewRoutingTable(2, local, time.Hour, pstore.NewMetrics(), NoOpThreshold, nil, ReplacementCacheSize(-1))
+	require.Error(t, err)
+}
@@ -0,0 +1,161 @@
+package kbucket
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+const (
+	// rttSmoothing is the weight of a new sample in the smoothed RTT (RFC 6298 alpha).
+	rttSmoothing = 0.125
+	// rttJitterSmoothing is the weight of a new sample in the smoothed RTT deviation (RFC 6298 beta).
+	rttJitterSmoothing = 0.25
+)
+
+// DefaultRTTMaxAge is the default age after which the RTT measured for a peer is considered stale.
+const DefaultRTTMaxAge = 10 * time.Minute
+
+// RTTStats keeps running statistics of the RTT samples measured for a peer.
+// The zero value holds no samples.
+type RTTStats struct {
+	ewma   time.Duration
+	jitter time.Duration
+	min    time.Duration
+
+	// Welford's running mean and sum of squared deviations, in nanoseconds.
+	mean float64
+	m2   float64
+
+	samples        int
+	lastMeasuredAt time.Time
+}
+
+// AddSample records an RTT measured at the given time. Non-positive samples are ignored.
+func (s *RTTStats) AddSample(rtt time.Duration, at time.Time) {
+	if rtt <= 0 {
+		return
+	}
+
+	if s.samples == 0 {
+		s.ewma = rtt
+		s.jitter = rtt / 2
+		s.min = rtt
+	} else {
+		dev := s.ewma - rtt
+		if dev < 0 {
+			dev = -dev
+		}
+		s.jitter = time.Duration((1-rttJitterSmoothing)*float64(s.jitter) + rttJitterSmoothing*float64(dev))
+		s.ewma = time.Duration((1-rttSmoothing)*float64(s.ewma) + rttSmoothing*float64(rtt))
+		if rtt < s.min {
+			s.min = rtt
+		}
+	}
+
+	s.samples++
+	delta := float64(rtt) - s.mean
+	s.mean += delta / float64(s.samples)
+	s.m2 += delta * (float64(rtt) - s.mean)
+
+	if at.After(s.lastMeasuredAt) {
+		s.lastMeasuredAt = at
+	}
+}
+
+// EWMA returns the smoothed RTT, or 0 if there are no samples.
+func (s RTTStats) EWMA() time.Duration {
+	return s.ewma
+}
+
+// Min returns the lowest RTT sampled, or 0 if there are no samples.
+func (s RTTStats) Min() time.Duration {
+	return s.min
+}
+
+// StdDev returns the standard deviation of all the RTT samples.
+func (s RTTStats) StdDev() time.Duration {
+	if s.samples < 2 {
+		return 0
+	}
+	return time.Duration(math.Sqrt(s.m2 / float64(s.samples-1)))
+}
+
+// Jitter returns the smoothed mean deviation of the RTT samples from the smoothed RTT.
+func (s RTTStats) Jitter() time.Duration {
+	return s.jitter
+}
+
+// RTO returns the retransmission timeout of RFC 6298 for the samples: the smoothed RTT plus four times
+// the smoothed deviation, or 0 if there are no samples.
+func (s RTTStats) RTO() time.Duration {
+	return s.ewma + 4*s.jitter
+}
+
+// P95 estimates the 95th percentile of the RTT, assuming the samples are normally distributed around the
+// smoothed RTT with the standard deviation of the samples, or the smoothed deviation until there are two
+// of them. It returns 0 if there are no samples.
+func (s RTTStats) P95() time.Duration {
+	sd := s.StdDev()
+	if s.samples < 2 {
+		sd = s.jitter
+	}
+	return s.ewma + time.Duration(1.645*float64(sd))
+}
+
+// Samples returns the number of RTT samples recorded.
+func (s RTTStats) Samples() int {
+	return s.samples
+}
+
+// LastMeasuredAt returns the time of the latest sample, or the zero time if there are no samples.
+func (s RTTStats) LastMeasuredAt() time.Time {
+	return s.lastMeasuredAt
+}
+
+// IsStale returns true if there are no samples or the latest one is older than maxAge at now.
+func (s RTTStats) IsStale(now time.Time, maxAge time.Duration) bool {
+	return s.samples == 0 || now.Sub(s.lastMeasuredAt) > maxAge
+}
+
+// rttStatsJSON is the encoding of RTTStats.
+type rttStatsJSON struct {
+	EWMA           time.Duration `json:"ewma"`
+	Jitter         time.Duration `json:"jitter"`
+	Min            time.Duration `json:"min"`
+	Mean           float64       `json:"mean"`
+	M2             float64       `json:"m2"`
+	Samples        int           `json:"samples"`
+	LastMeasuredAt time.Time     `json:"last_measured_at"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s RTTStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rttStatsJSON{
+		EWMA:           s.ewma,
+		Jitter:         s.jitter,
+		Min:            s.min,
+		Mean:           s.mean,
+		M2:             s.m2,
+		Samples:        s.samples,
+		LastMeasuredAt: s.lastMeasuredAt,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *RTTStats) UnmarshalJSON(b []byte) error {
+	var v rttStatsJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*s = RTTStats{
+		ewma:           v.EWMA,
+		jitter:         v.Jitter,
+		min:            v.Min,
+		mean:           v.Mean,
+		m2:             v.M2,
+		samples:        v.Samples,
+		lastMeasuredAt: v.LastMeasuredAt,
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package kbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/test"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRTTStats(t *testing.T) {
+	t.Parallel()
+
+	var s RTTStats
+	now := time.Now()
+	require.True(t, s.IsStale(now, time.Hour))
+	require.Zero(t, s.EWMA())
+	require.Zero(t, s.RTO())
+	require.Zero(t, s.P95())
+
+	// non-positive samples are ignored
+	s.AddSample(0, now)
+	s.AddSample(-time.Second, now)
+	require.Zero(t, s.Samples())
+
+	s.AddSample(100*time.Millisecond, now)
+	require.Equal(t, 100*time.Millisecond, s.EWMA())
+	require.Equal(t, 100*time.Millisecond, s.Min())
+	require.Equal(t, 50*time.Millisecond, s.Jitter())
+	require.Zero(t, s.StdDev())
+	require.Equal(t, 300*time.Millisecond, s.RTO())
+	require.Equal(t, 182250*time.Microsecond, s.P95())
+
+	s.AddSample(20*time.Millisecond, now.Add(time.Second))
+	s.AddSample(180*time.Millisecond, now.Add(2*time.Second))
+	require.Equal(t, 3, s.Samples())
+	require.Equal(t, 20*time.Millisecond, s.Min())
+	// 100 -> 90 -> 101.25
+	require.Equal(t, 101250*time.Microsecond, s.EWMA())
+	require.Equal(t, 80*time.Millisecond, s.StdDev())
+	require.Equal(t, 232850*time.Microsecond, s.P95())
+	require.Equal(t, now.Add(2*time.Second), s.LastMeasuredAt())
+
+	// a single outlier barely moves the smoothed RTT
+	s.AddSample(5*time.Second, now.Add(3*time.Second))
+	require.Less(t, int64(s.EWMA()), int64(time.Second))
+	require.Equal(t, 20*time.Millisecond, s.Min())
+
+	// out of order samples don't move the last measurement back
+	s.AddSample(time.Millisecond, now)
+	require.Equal(t, now.Add(3*time.Second), s.LastMeasuredAt())
+
+	require.False(t, s.IsStale(now.Add(time.Minute), time.Hour))
+	require.True(t, s.IsStale(now.Add(2*time.Hour), time.Hour))
+}
+
+func TestRoutingTableRTTStats(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(10, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil, KadRTT(true))
+	require.NoError(t, err)
+
+	p := test.RandPeerIDFatal(t)
+	_, ok := rt.RTTStats(p)
+	require.False(t, ok)
+	require.Zero(t, rt.GetRTT(p))
+
+	b, err := rt.TryAddPeerKadRTT(p, true, true, 40*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, b)
+
+	// adding the peer again records another sample
+	b, err = rt.TryAddPeerKadRTT(p, true, true, 80*time.Millisecond)
+	require.NoError(t, err)
+	require.False(t, b)
+	rt.SetRTT(p, 20*time.Millisecond)
+
+	stats, ok := rt.RTTStats(p)
+	require.True(t, ok)
+	require.Equal(t, 3, stats.Samples())
+	require.Equal(t, 20*time.Millisecond, stats.Min())
+	require.Equal(t, stats.EWMA(), rt.GetRTT(p))
+}
+
+func TestKadRTTOrderingUsesFreshRTT(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	m := pstore.NewMetrics()
+	rt, err := NewRoutingTable(20, ConvertPeerID(local), time.Hour, m, NoOpThreshold, nil, KadRTT(true), RTTMaxAge(time.Minute))
+	require.NoError(t, err)
+
+	var fast, stale []string
+	for i := 0; i < 6; i++ {
+		p := test.RandPeerIDFatal(t)
+		_, err := rt.TryAddPeerKadRTT(p, true, true, time.Duration(10+i)*time.Millisecond)
+		require.NoError(t, err)
+		fast = append(fast, string(p))
+	}
+	for i := 0; i < 3; i++ {
+		p := test.RandPeerIDFatal(t)
+		_, err := rt.TryAddPeerKadRTT(p, true, true, time.Millisecond)
+		require.NoError(t, err)
+		stale = append(stale, string(p))
+
+		// the fast sample is old, and the peerstore knows better
+		rt.tabLock.Lock()
+		pi := rt.buckets[rt.bucketIdForPeer(p)].getPeer(p)
+		pi.rtt.lastMeasuredAt = time.Now().Add(-time.Hour)
+		rt.tabLock.Unlock()
+		m.RecordLatency(p, time.Second)
+	}
+
+	// peers with a stale RTT are ranked by their (high) peerstore latency
+	ranked := rt.NearestPeersByPolicy(ConvertPeerID(local), 9, RTTRanking{})
+	require.Len(t, ranked, 9)
+	for i, p := range ranked {
+		if i < len(fast) {
+			require.Equal(t, fast[i], string(p))
+		} else {
+			require.Contains(t, stale, string(p))
+		}
+	}
+}
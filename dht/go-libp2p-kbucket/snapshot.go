@@ -0,0 +1,109 @@
+package kbucket
+
+import (
+	"math/big"
+	"time"
+)
+
+// BucketParams is a snapshot of the parameters KadRTT tuned for a single bucket.
+type BucketParams struct {
+	// Cpl is the common prefix length with the local ID served by the bucket.
+	// The last bucket also holds all the peers with a longer common prefix.
+	Cpl int `json:"cpl"`
+	// Peers is the number of peers in the bucket.
+	Peers int `json:"peers"`
+
+	// K is the capacity of the bucket; it is the bucket size of the table unless KadRTT is enabled.
+	K int `json:"k"`
+	// PreferredK is the capacity KadRTT derived for the bucket, before the entry budget of the table
+	// reduced it to K. It is 0 unless KadRTT is enabled.
+	PreferredK int `json:"preferred_k,omitempty"`
+	// Alpha is the degree of lookup concurrency for targets in the bucket.
+	Alpha int `json:"alpha"`
+	// Beta is the number of next hops returned by each queried peer.
+	Beta int `json:"beta"`
+
+	// PQuery is the content hit probability per query.
+	PQuery float64 `json:"p_query"`
+	// PNot is the probability of no content hit up to this bucket.
+	PNot float64 `json:"p_not"`
+	// IDVariance is the variance of the gaps between adjacent peer IDs in the bucket.
+	IDVariance *big.Int `json:"id_variance"`
+	// Replacements are the peers waiting for a place in the bucket, best first.
+	Replacements []ReplacementInfo `json:"replacements,omitempty"`
+}
+
+// Snapshot is a point-in-time view of the routing table parameters.
+type Snapshot struct {
+	Time       time.Time `json:"time"`
+	KadRTT     bool      `json:"kadrtt"`
+	BucketSize int       `json:"bucket_size"`
+	PoolSize   int       `json:"pool_size"`
+
+	Stats   AdaptationSnapshot `json:"stats"`
+	Buckets []BucketParams     `json:"buckets"`
+}
+
+// BucketParams returns the parameters of all the buckets, ordered by CPL.
+func (rt *RoutingTable) BucketParams() []BucketParams {
+	rt.tabLock.RLock()
+	defer rt.tabLock.RUnlock()
+
+	return rt.bucketParams()
+}
+
+// BucketParamsForCpl returns the parameters of the bucket serving the given CPL.
+// CPLs past the last bucket map to the last bucket, and negative ones to the first.
+func (rt *RoutingTable) BucketParamsForCpl(cpl int) BucketParams {
+	rt.tabLock.RLock()
+	defer rt.tabLock.RUnlock()
+
+	if cpl >= len(rt.buckets) {
+		cpl = len(rt.buckets) - 1
+	}
+	if cpl < 0 {
+		cpl = 0
+	}
+	return rt.paramsFor(cpl)
+}
+
+// Snapshot returns the current parameters of the routing table and all of its buckets.
+func (rt *RoutingTable) Snapshot() Snapshot {
+	rt.tabLock.RLock()
+	defer rt.tabLock.RUnlock()
+
+	return Snapshot{
+		Time:       rt.clock.Now(),
+		KadRTT:     rt.isKadRTT,
+		BucketSize: rt.bucketsize,
+		PoolSize:   rt.pool_size,
+		Stats:      rt.stats.Snapshot(),
+		Buckets:    rt.bucketParams(),
+	}
+}
+
+// locking is the responsibility of the caller
+func (rt *RoutingTable) bucketParams() []BucketParams {
+	params := make([]BucketParams, len(rt.buckets))
+	for i := range rt.buckets {
+		params[i] = rt.paramsFor(i)
+	}
+	return params
+}
+
+// locking is the responsibility of the caller
+func (rt *RoutingTable) paramsFor(cpl int) BucketParams {
+	b := rt.buckets[cpl]
+	return BucketParams{
+		Cpl:          cpl,
+		Peers:        b.len(),
+		K:            rt.bucketCapacity(b),
+		PreferredK:   b.kPref,
+		Alpha:        b.alpha,
+		Beta:         b.beta,
+		PQuery:       b.p_query,
+		PNot:         b.p_not,
+		IDVariance:   b.spacing.variance(),
+		Replacements: b.replacements.infos(),
+	}
+}
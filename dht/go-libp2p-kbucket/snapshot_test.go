@@ -0,0 +1,76 @@
+package kbucket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/test"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(2, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil,
+		KadRTT(true), PoolSize(3))
+	require.NoError(t, err)
+
+	for cpl := uint(0); cpl < 3; cpl++ {
+		for i := 0; i < 2; i++ {
+			p, err := rt.GenRandPeerID(cpl)
+			require.NoError(t, err)
+			_, err = rt.TryAddPeerKadRTT(p, true, true, time.Millisecond)
+			require.NoError(t, err)
+		}
+	}
+
+	params := rt.BucketParams()
+	require.Len(t, params, len(rt.GetBuckets()))
+	total := 0
+	for i, bp := range params {
+		require.Equal(t, i, bp.Cpl)
+		require.Equal(t, rt.buckets[i].k, bp.K)
+		require.Equal(t, rt.buckets[i].alpha, bp.Alpha)
+		require.Equal(t, rt.buckets[i].beta, bp.Beta)
+		total += bp.Peers
+	}
+	require.Equal(t, rt.Size(), total)
+
+	// CPLs past the last bucket map to the last bucket, and negative ones to the first
+	for _, tc := range []struct {
+		cpl, want int
+	}{
+		{cpl: 0, want: 0},
+		{cpl: 1, want: 1},
+		{cpl: len(params) - 1, want: len(params) - 1},
+		{cpl: len(params), want: len(params) - 1},
+		{cpl: 100, want: len(params) - 1},
+		{cpl: -1, want: 0},
+		{cpl: -100, want: 0},
+	} {
+		require.Equal(t, tc.want, rt.BucketParamsForCpl(tc.cpl).Cpl, "cpl %d", tc.cpl)
+	}
+
+	snap := rt.Snapshot()
+	require.True(t, snap.KadRTT)
+	require.Equal(t, 2, snap.BucketSize)
+	require.Equal(t, 3, snap.PoolSize)
+	require.Equal(t, params, snap.Buckets)
+
+	// the snapshot is a copy
+	snap.Buckets[0].IDVariance.SetInt64(-42)
+	require.NotEqual(t, int64(-42), rt.buckets[0].spacing.variance().Int64())
+
+	bz, err := json.Marshal(snap)
+	require.NoError(t, err)
+	var decoded Snapshot
+	require.NoError(t, json.Unmarshal(bz, &decoded))
+	require.Equal(t, snap.Buckets, decoded.Buckets)
+	require.Equal(t, snap.Stats.StoreRate, decoded.Stats.StoreRate)
+	require.True(t, snap.Time.Equal(decoded.Time))
+}
@@ -2,7 +2,6 @@
 package kbucket
 
 import (
-	"container/list"
 	"context"
 	"errors"
 	"fmt"
@@ -12,11 +11,13 @@ import (
 	"math"
 	"math/big"
 
-	"sort"
 	"sync"
 	"time"
 
+	"github.com/libp2p/go-libp2p-kbucket/clock"
+	"github.com/libp2p/go-libp2p-kbucket/model"
 	"github.com/libp2p/go-libp2p-kbucket/peerdiversity"
+	"github.com/libp2p/go-libp2p-kbucket/vivaldi"
 
 	logging "github.com/ipfs/go-log"
 )
@@ -65,16 +66,10 @@ type RoutingTable struct {
 
 	//Added by Kanemitsu START
 	/**
-	STORE message arrival rate
-	derived by : num_arrive / rtt_duration
+	STORE message arrival rate and k-bucket entry exchange probability,
+	derived per rttInterval
 	*/
-	arv_rate_store float64
-
-
-	/**
-	# of STORE(addPeer) requests
-	*/
-	num_arrive int64
+	stats *AdaptationStats
 
 	/**
 	Pool Size
@@ -82,28 +77,52 @@ type RoutingTable struct {
 	pool_size int
 
 	/**
-	k-bucket entry exchange probability
-	that is derived by :
-	num_exchange / num_arrive per rttInterval
+	Configured pool size that pool_size is reset to
 	*/
-	prob_exchange float64
-
-	num_exchange int64
+	basePoolSize int
 	//Added by Kanemitsu END
 
 	isKadRTT bool
 
-	/**
-	Time interval to obtain RTT
-	*/
-	rttInterval time.Duration
+	// decides who gets into a full bucket
+	admission AdmissionPolicy
+
+	// measures how evenly the IDs in a bucket are spread
+	dispersion Dispersion
+
+	// age after which the RTT of a peer is stale
+	rttMaxAge time.Duration
+
+	// optional index of all the peers, used to find the closest ones
+	trie *xorTrie
+
+	// maximum number of peers in the replacement cache of a bucket, -1 until configured
+	replacementCacheSize int
+
+	// optional network coordinates, used to estimate the RTT of peers that weren't measured
+	coords *vivaldi.Client
+
+	// bounds of the k of a KadRTT bucket, and maximum number of peers in the table; 0 means unbounded
+	minBucketK int
+	maxBucketK int
+	maxEntries int
 
-	lastExTime time.Time
+	// weight of the diversity score of the peers in the default KadRTT admission policy
+	diversityWeight float64
+
+	// tells the time of the RTT samples, the KadRTT windows and the usefulness of the peers
+	clock clock.Clock
+
+	// event subscribers
+	subsLk sync.Mutex
+	subs   []*Subscription
 }
 
 // NewRoutingTable creates a new routing table with a given bucketsize, local ID, and latency tolerance.
+// KadRTT mode and its parameters are configured with opts; by default the table behaves as a classic
+// Kademlia routing table.
 func NewRoutingTable(bucketsize int, localID ID, latency time.Duration, m peerstore.Metrics, usefulnessGracePeriod time.Duration,
-	df *peerdiversity.Filter) (*RoutingTable, error) {
+	df *peerdiversity.Filter, opts ...Option) (*RoutingTable, error) {
 	rt := &RoutingTable{
 		buckets:    []*bucket{newBucket()},
 		bucketsize: bucketsize,
@@ -118,23 +137,39 @@ func NewRoutingTable(bucketsize int, localID ID, latency time.Duration, m peerst
 		PeerAdded:   func(peer.ID) {},
 
 		usefulnessGracePeriod: usefulnessGracePeriod,
-		arv_rate_store: 0.5,
-		prob_exchange: 0.5,
+		stats: NewAdaptationStats(DefaultRTTInterval, 0.5, 0.5),
+		basePoolSize: bucketsize,
+		rttMaxAge: DefaultRTTMaxAge,
 		df: df,
+
+		replacementCacheSize: -1,
+		diversityWeight:      DefaultDiversityWeight,
+		minBucketK:           DefaultMinBucketK,
+		clock:                clock.New(),
+	}
+	if err := rt.applyOptions(opts...); err != nil {
+		return nil, err
+	}
+	if rt.admission == nil {
+		if rt.isKadRTT {
+			rt.admission = IDVariancePolicy{Measure: rt.dispersion, DiversityWeight: rt.diversityWeight}
+		} else {
+			rt.admission = ReplaceablePolicy{}
+		}
+	}
+	if rt.replacementCacheSize < 0 {
+		if rt.isKadRTT {
+			rt.replacementCacheSize = DefaultReplacementCacheSize
+		} else {
+			rt.replacementCacheSize = 0
+		}
 	}
-	rt.isKadRTT = true
 	//Addec by Kanemitsu START
-	//rt.arv_rate_store = 0.5
-	rt.pool_size = rt.bucketsize
-	//rt.prob_exchange = 0.5
+	rt.pool_size = rt.basePoolSize
 
 	//rt.setOptValues(0)
 	//set the initial values for k, alpha, and beta.
 	//rt.buildInitParameters()
-	rt.lastExTime = time.Now()
-	rt.num_arrive = 1
-	rt.num_exchange = 1
-
 	//Addec by Kanemitsu END
 
 	rt.ctx, rt.ctxCancel = context.WithCancel(context.Background())
@@ -151,23 +186,85 @@ func NewRoutingTable(bucketsize int, localID ID, latency time.Duration, m peerst
 	return rt, nil
 }
 
+// SetRTT records an RTT sample for the given peer. It is a no-op if the peer isn't in the Routing Table.
 func (rt *RoutingTable) SetRTT(p peer.ID, rtt time.Duration) {
-	//pに対して，rttをセットする．
-	bucketID := rt.bucketIdForPeer(p)
-	//bucket := rt.buckets[bucketID]
-	bucket := rt.GetBucket(bucketID)
-	peer := bucket.getPeer(p)
+	rt.tabLock.Lock()
+	defer rt.tabLock.Unlock()
 
-	peer.SetRTT(rtt)
+	bucketID := rt.bucketIdForPeer(p)
+	if peer := rt.buckets[bucketID].getPeer(p); peer != nil {
+		peer.rtt.AddSample(rtt, rt.clock.Now())
+		if rtt > 0 {
+			rt.emit(Event{Type: EventRTTUpdated, Cpl: bucketID, Peer: p, RTT: rtt})
+		}
+	}
 }
 
+// GetRTT returns the smoothed RTT of the given peer, or 0 if the peer isn't in the Routing Table.
 func (rt *RoutingTable) GetRTT(p peer.ID) time.Duration {
-	bucketID := rt.bucketIdForPeer(p)
-	//bucket := rt.buckets[bucketID]
-	bucket := rt.GetBucket(bucketID)
-	peer := bucket.getPeer(p)
+	stats, _ := rt.RTTStats(p)
+	return stats.EWMA()
+}
+
+// RTTStats returns the RTT statistics of the given peer and whether the peer is in the Routing Table.
+func (rt *RoutingTable) RTTStats(p peer.ID) (RTTStats, bool) {
+	rt.tabLock.RLock()
+	defer rt.tabLock.RUnlock()
+
+	bucket := rt.buckets[rt.bucketIdForPeer(p)]
+	if peer := bucket.getPeer(p); peer != nil {
+		return peer.RTTStats(), true
+	}
+	return RTTStats{}, false
+}
+
+// effectiveRTT returns the smoothed RTT of the peer, falling back to the RTT estimated from its network
+// coordinate and then to the peerstore latency if the RTT of the peer is stale. It returns 0 if none is known.
+func (rt *RoutingTable) effectiveRTT(pi *PeerInfo, now time.Time) time.Duration {
+	if !pi.rtt.IsStale(now, rt.rttMaxAge) {
+		return pi.rtt.EWMA()
+	}
+	return rt.estimatedRTT(pi.Id)
+}
+
+// estimatedRTT returns the RTT of the peer estimated from its network coordinate, or the peerstore
+// latency if its coordinate isn't known. It returns 0 if neither is known.
+func (rt *RoutingTable) estimatedRTT(p peer.ID) time.Duration {
+	if rt.coords != nil {
+		if rtt, ok := rt.coords.EstimateRTT(p); ok {
+			return rtt
+		}
+	}
+	if rt.metrics == nil {
+		return 0
+	}
+	return rt.metrics.LatencyEWMA(p)
+}
+
+// EstimateRTT returns the best known RTT to any peer, whether or not it is in the Routing Table:
+// its fresh measured RTT if it is in the table, else the RTT estimated from its network coordinate,
+// else the peerstore latency. The boolean is false if none is known.
+func (rt *RoutingTable) EstimateRTT(p peer.ID) (time.Duration, bool) {
+	rt.tabLock.RLock()
+	defer rt.tabLock.RUnlock()
 
-	return peer.GetRTT()
+	var rtt time.Duration
+	if pi := rt.buckets[rt.bucketIdForPeer(p)].getPeer(p); pi != nil {
+		rtt = rt.effectiveRTT(pi, rt.clock.Now())
+	} else {
+		rtt = rt.estimatedRTT(p)
+	}
+	return rtt, rtt > 0
+}
+
+// Coordinates returns the Vivaldi client of the table, or nil if network coordinates aren't enabled.
+func (rt *RoutingTable) Coordinates() *vivaldi.Client {
+	return rt.coords
+}
+
+// AdaptationStats returns the current KadRTT store rate and exchange probability statistics.
+func (rt *RoutingTable) AdaptationStats() AdaptationSnapshot {
+	return rt.stats.Snapshot()
 }
 
 func (rt *RoutingTable) configPool() {
@@ -180,18 +277,20 @@ func (rt *RoutingTable) configPool() {
 		}
 	}
 	//rt.setPoolSize(maxPoolSize)
-	rt.setPoolSize(rt.bucketsize)
+	rt.setPoolSize(rt.basePoolSize)
 }
 
 func (rt *RoutingTable) setOptValues(idx int) *bucket {
 	initB := rt.buckets[idx]
-	k_opt := rt.CalcKOpt(idx)
+	prevK, prevAlpha, prevBeta := initB.k, initB.alpha, initB.beta
+	initB.kPref = rt.clampK(rt.CalcKOpt(idx))
+	k_opt := rt.allotK(idx)
 
 	//k_opt = int(math.Max(float64(k_opt), float64(rt.bucketsize)))
 
 	initB.SetK(k_opt)
 	//fmt.Println("####idx:", idx, "/K:", k_opt)
-	b_opt := rt.CalcBetaOpt(idx)
+	b_opt := rt.calcBetaOpt(idx)
 	if b_opt < 1 {
 		b_opt = k_opt
 	}
@@ -199,7 +298,7 @@ func (rt *RoutingTable) setOptValues(idx int) *bucket {
 
 	initB.SetBeta(b_opt)
 
-	a_opt := rt.CalcAlphaOpt(idx)
+	a_opt := rt.calcAlphaOpt(idx)
 	if a_opt > int(rt.pool_size) {
 		a_opt = int(rt.pool_size)
 	}
@@ -210,24 +309,36 @@ func (rt *RoutingTable) setOptValues(idx int) *bucket {
 	initB.SetAlpha(a_opt)
 	//fmt.Println("***OptA: %d", a_opt)
 	rt.configPool()
+	rt.emitParamsChanged(idx, prevK, prevAlpha, prevBeta)
+
+	// the entry budget may have lowered the k of any bucket, so evict the surplus right away
+	// rather than when the next rttInterval window closes. The evictions may collapse empty
+	// buckets, so the length is checked again on every iteration.
+	for i := 0; i < len(rt.buckets); i++ {
+		rt.shrinkBucket(i)
+	}
 
 	return initB
 }
 
+// GetBuckets returns the current buckets of the table.
+// The buckets are updated under the table lock, so their parameters must not be read
+// while peers are being added concurrently.
 func (rt *RoutingTable) GetBuckets() []*bucket {
-	return rt.buckets
-}
+	rt.tabLock.RLock()
+	defer rt.tabLock.RUnlock()
 
-func (rt *RoutingTable) GetBucket(cpl int) *bucket {
-	return rt.buckets[cpl]
+	buckets := make([]*bucket, len(rt.buckets))
+	copy(buckets, rt.buckets)
+	return buckets
 }
 
-func (rt *RoutingTable) setStoreRate(val float64) {
-	rt.arv_rate_store = val
-}
+// GetBucket returns the bucket at the given index. See GetBuckets.
+func (rt *RoutingTable) GetBucket(cpl int) *bucket {
+	rt.tabLock.RLock()
+	defer rt.tabLock.RUnlock()
 
-func (rt *RoutingTable) setProbExchange(val float64) {
-	rt.prob_exchange = val
+	return rt.buckets[cpl]
 }
 
 func (rt *RoutingTable) setPoolSize(val int) {
@@ -236,226 +347,57 @@ func (rt *RoutingTable) setPoolSize(val int) {
 
 //Derive optimal k
 func (rt *RoutingTable) CalcKOpt(idx int) int {
-	//r := idx + 1
-	r := 255 - idx
-	prob := math.Max(rt.prob_exchange * rt.arv_rate_store, 0.1)
-
-	val := (float64)(prob)
-	w2 := math.Log(val)
-	w1 := -1 * math.Pow(2, float64(r)) * math.Pow(math.E, (float64)(-1*prob))
-	nomi := rt.LambertW(0, w1*w2)
-	denomi := math.Log(prob)
-	k_opt := int(math.Max(float64(1), math.Floor(-1*nomi/denomi)))
-	//fmt.Println("#####idx:", idx, "/kopt:", k_opt)
-	if k_opt < 2 {
-		k_opt = 2
-	}
-
-	if k_opt < rt.bucketsize {
-		k_opt = rt.bucketsize
+	stats := rt.stats.Snapshot()
+	k_opt, err := model.OptimalK(idx, stats.StoreRate, stats.ExchangeProbability, rt.bucketsize)
+	if err != nil {
+		log.Debugf("no optimal k for bucket %d (store rate %f, exchange probability %f): %v",
+			idx, stats.StoreRate, stats.ExchangeProbability, err)
+		return rt.bucketsize
 	}
 
 	return k_opt
-
-}
-
-func (rt *RoutingTable) LambertW(k int, x float64) float64 {
-	// Special cases.
-	switch {
-	case k < -1 || k > 0 || x < -1/math.E || (k == -1 && x > 0) || math.IsNaN(x):
-		return math.NaN()
-	case x == 0:
-		if k == 0 {
-			return 0
-		}
-		return math.Inf(-1)
-	case x == -1/math.E:
-		return -1
-	case math.IsInf(x, 1):
-		return x
-	}
-
-	// Estimate an initial value using approximations and then use
-	// Fritsch iteration (once) to get an improved estimate with O(1e-15) error
-	w := rt.initial(k, x)
-	return rt.fritsch(w, x)
-}
-
-func (rt *RoutingTable) fritsch(w, x float64) float64 {
-	z := math.Log(x/w) - w
-	w1 := w + 1
-	q := 2 * w1 * (w1 + 2*z/3)
-	eps := z / w1 * (q - z) / (q - 2*z)
-	return w * (1 + eps)
-}
-
-func (rt *RoutingTable) initial(k int, x float64) float64 {
-	switch k {
-	case 0:
-		const (
-			xbranch = -0.32358170806015724
-			xratp0  = 0.14546954290661823
-			xratp1  = 8.706658967856612
-		)
-		switch {
-		case x < xbranch:
-			return rt.branchpoint(k, x)
-		case x < xratp0:
-			return rt.rationalp0(x)
-		case x < xratp1:
-			return rt.rationalp1(x)
-		default:
-			return rt.asymptotic(k, x)
-		}
-	default: // k=-1
-		const xbranch = -0.30298541769
-		switch {
-		case x < xbranch:
-			return rt.branchpoint(k, x)
-		default:
-			return rt.rationalm(x)
-		}
-	}
-}
-
-func (rt *RoutingTable) rationalm(x float64) float64 {
-	const (
-		a0 = -7.81417672390744
-		a1 = 253.88810188892484
-		a2 = 657.9493176902304
-
-		b0 = 1
-		b1 = -60.43958713690808
-		b2 = 99.9856708310761
-		b3 = 682.6073999909428
-		b4 = 962.1784396969866
-		b5 = 1477.9341280760887
-	)
-
-	return (a0 + x*(a1+x*a2)) / (b0 + x*(b1+x*(b2+x*(b3+x*(b4+x*b5)))))
-}
-
-// asymptotic returns an asymptotic estimate of W(x, k)
-func (rt *RoutingTable) asymptotic(k int, x float64) float64 {
-	s := 1 + 2*k
-	a := math.Log(float64(s) * x)
-	b := math.Log(float64(s) * a)
-
-	ba := b / a
-	b2 := b * b
-	b3 := b2 * b
-	b4 := b2 * b2
-
-	q0 := b - 2
-	q1 := 2*b2 - 9*b + 6
-	q2 := 3*b3 - 22*b2 + 36*b - 12
-	q3 := 12*b4 - 125*b3 + 350*b2 - 300*b + 60
-	return a - b + ba*(1+1/(2*a)*(q0+1/(3*a)*(q1+1/(2*a)*(q2+1/(5*a)*q3))))
-}
-func (rt *RoutingTable) rationalp0(x float64) float64 {
-	const (
-		a0 = 1
-		a1 = 5.931375839364438
-		a2 = 11.39220550532913
-		a3 = 7.33888339911111
-		a4 = 0.653449016991959
-
-		b0 = 1
-		b1 = 6.931373689597704
-		b2 = 16.82349461388016
-		b3 = 16.43072324143226
-		b4 = 5.115235195211697
-	)
-	num := a0 + x*(a1+x*(a2+x*(a3+x*a4)))
-	den := b0 + x*(b1+x*(b2+x*(b3+x*b4)))
-	return x * num / den
-}
-
-func (rt *RoutingTable) rationalp1(x float64) float64 {
-	const (
-		a0 = 1
-		a1 = 2.445053070726557
-		a2 = 1.343664225958226
-		a3 = 0.148440055397592
-		a4 = 0.0008047501729130
-
-		b0 = 1
-		b1 = 3.444708986486002
-		b2 = 3.292489857371952
-		b3 = 0.916460018803122
-		b4 = 0.0530686404483322
-	)
-	num := a0 + x*(a1+x*(a2+x*(a3+x*a4)))
-	den := b0 + x*(b1+x*(b2+x*(b3+x*b4)))
-	return x * num / den
-}
-func (rt *RoutingTable) branchpoint(k int, x float64) float64 {
-	s := 1 + 2*k
-	p := float64(s) * math.Sqrt2 * math.Sqrt(1+math.E*x)
-	const (
-		b0 = -1
-		b1 = 1
-		b2 = -0.3333333333333333
-		b3 = 0.1527777777777778
-		b4 = -0.07962962962962963
-		b5 = 0.04450231481481481
-		b6 = -0.02598471487360376
-		b7 = 0.01563563253233392
-		b8 = -0.009616892024299432
-		b9 = 0.006014543252956118
-	)
-	return b0 + p*(b1+p*(b2+p*(b3+p*(b4+p*(b5+p*(b6+p*(b7+p*(b8+p*b9))))))))
 }
 
 //Derive optimal alpha
 func (rt *RoutingTable) CalcAlphaOpt(idx int) int {
+	// updates the query probabilities of the bucket
+	rt.tabLock.Lock()
+	defer rt.tabLock.Unlock()
 
-	//	b0 := rt.buckets[0]
-	//k0 := b0.k
-	br := rt.buckets[idx]
+	return rt.calcAlphaOpt(idx)
+}
 
-	var p_query float64
-	var pro float64
-	pro = 1.0
+// locking is the responsibility of the caller
+func (rt *RoutingTable) calcAlphaOpt(idx int) int {
+	br := rt.buckets[idx]
 	b_pre := rt.buckets[0]
 	if idx > 0 {
 		b_pre = rt.buckets[idx-1]
-	} else {
-		b_pre = rt.buckets[0]
 	}
 
-	pre_pro := b_pre.p_not
+	// updates the query probabilities of the bucket
+	br.p_query, br.p_not = model.QueryProbability(b_pre.p_not, b_pre.k, br.k)
 
-	pro = pre_pro * float64(float64(1)-float64(1/float64(b_pre.k)))
-	br.p_not = pro
-	if idx == 0 {
-		pre_pro = 1
-		br.p_not = 1
+	alpha_opt, err := model.OptimalAlpha(br.k, br.beta, rt.pool_size, br.p_query)
+	if err != nil {
+		// keep the current alpha
+		log.Debugf("no optimal alpha for bucket %d (p_query %f): %v", idx, br.p_query, err)
+		return br.alpha
 	}
-	//p_query = b_pre.p_query + pro * 1/float64(br.k)
-	p_query = pro * 1 / float64(br.k)
-	br.p_query = p_query
-
-	//p_query = math.Min(0.99, p_query)
-	p_not := float64(1.0 - p_query)
-
-	br.p_not = pro * float64(float64(1)-float64(1/float64(br.k)))
 
-	denomi := float64(float64(br.beta) * float64(br.k) * float64(rt.pool_size) * math.Log(p_not))
-
-	w := rt.LambertW(0, float64(-1)*(float64(rt.pool_size)*math.Pow(p_not, float64(rt.pool_size)*float64(br.beta)+(float64(rt.pool_size)*float64(br.beta))/(1-math.Pow(p_not, float64(rt.pool_size)*float64(br.beta))))*float64(br.beta)*math.Log(p_not))/(math.Pow(p_not, float64(rt.pool_size*br.beta))-1))
-	w2 := (float64(br.beta) * float64(rt.pool_size) * math.Log(p_not)) / (1 - math.Pow(p_not, float64(br.beta)*float64(rt.pool_size)))
-	alpha_opt := math.Abs(float64(math.Ceil(denomi / float64((w - w2)))))
-
-	if alpha_opt < 2 {
-		alpha_opt = 2
-	}
-
-	return int(math.Ceil(alpha_opt))
+	return alpha_opt
 }
 
 //Derive optimal beta
 func (rt *RoutingTable) CalcBetaOpt(idx int) int {
+	rt.tabLock.RLock()
+	defer rt.tabLock.RUnlock()
+
+	return rt.calcBetaOpt(idx)
+}
+
+// locking is the responsibility of the caller
+func (rt *RoutingTable) calcBetaOpt(idx int) int {
 	/*br := rt.buckets[idx]
 	alphaR := br.alpha
 	beta_opt := rt.pool_size/alphaR
@@ -464,9 +406,7 @@ func (rt *RoutingTable) CalcBetaOpt(idx int) int {
 
 	//b0 := rt.buckets[0]
 	//beta_opt := int(rt.pool_size * b0.k / br.alpha)
-	beta_opt := int(math.Min(float64(br.k), float64(rt.pool_size)))
-
-	return beta_opt
+	return model.OptimalBeta(br.k, rt.pool_size)
 }
 
 //set the initial alpha, beta, and k for each k-bucket in KadRTT
@@ -491,1210 +431,119 @@ func (rt *RoutingTable) Close() error {
 	return nil
 }
 
-// NPeersForCPL returns the number of peers we have for a given Cpl
-func (rt *RoutingTable) NPeersForCpl(cpl uint) int {
-	rt.tabLock.RLock()
-	defer rt.tabLock.RUnlock()
-
-	// it's in the last bucket
-	if int(cpl) >= len(rt.buckets)-1 {
-		count := 0
-		b := rt.buckets[len(rt.buckets)-1]
-		for _, p := range b.peers() {
-			if CommonPrefixLen(rt.local, p.dhtId) == int(cpl) {
-				count++
-			}
-		}
-		return count
-	} else {
-		return rt.buckets[cpl].len()
-	}
-}
-
-// TryAddPeer tries to add a peer to the Routing table.
-// If the peer ALREADY exists in the Routing Table and has been queried before, this call is a no-op.
-// If the peer ALREADY exists in the Routing Table but hasn't been queried before, we set it's LastUsefulAt value to
-// the current time. This needs to done because we don't mark peers as "Useful"(by setting the LastUsefulAt value)
-// when we first connect to them.
-//
-// If the peer is a queryPeer i.e. we queried it or it queried us, we set the LastSuccessfulOutboundQuery to the current time.
-// If the peer is just a peer that we connect to/it connected to us without any DHT query, we consider it as having
-// no LastSuccessfulOutboundQuery.
-//
-//
-// If the logical bucket to which the peer belongs is full and it's not the last bucket, we try to replace an existing peer
-// whose LastSuccessfulOutboundQuery is above the maximum allowed threshold in that bucket with the new peer.
-// If no such peer exists in that bucket, we do NOT add the peer to the Routing Table and return error "ErrPeerRejectedNoCapacity".
-
-// It returns a boolean value set to true if the peer was newly added to the Routing Table, false otherwise.
-// It also returns any error that occurred while adding the peer to the Routing Table. If the error is not nil,
-// the boolean value will ALWAYS be false i.e. the peer wont be added to the Routing Table it it's not already there.
-//
-// A return value of false with error=nil indicates that the peer ALREADY exists in the Routing Table.
-func (rt *RoutingTable) TryAddPeer(p peer.ID, queryPeer bool, isReplaceable bool) (bool, error) {
-	rt.tabLock.Lock()
-	defer rt.tabLock.Unlock()
-
-	return rt.addPeer(p, queryPeer, isReplaceable)
-}
-
-func (rt *RoutingTable) TryAddPeer2(p peer.ID, queryPeer bool, isReplaceable bool, rtt time.Duration) (bool, error) {
-	rt.tabLock.Lock()
-	defer rt.tabLock.Unlock()
-
-	return rt.addPeer2(p, queryPeer, isReplaceable, rtt)
-}
-
-func (rt *RoutingTable) TryAddPeerKadRTT(p peer.ID, queryPeer bool, isReplaceable bool, rtt time.Duration) (bool, error) {
-	rt.tabLock.Lock()
-	defer rt.tabLock.Unlock()
-
-	return rt.addPeerKadRTT(p, queryPeer, isReplaceable, rtt)
-
-}
-
-func Distance(k1, k2 []byte) *big.Int {
-	// XOR the keys
-	k3 := u.XOR(k1, k2)
-
-	// interpret it as an integer
-	dist := big.NewInt(0).SetBytes(k3)
-	return dist
-}
-
-//Added by Kanemitsu
-func (rt *RoutingTable) calcIDVariance(b *bucket, p peer.ID, rtt time.Duration) *big.Int {
-	//Sort by increasing order of key
-	var bp ByPeer = b.peers()
-	//peers := ks.ByPeer(bp)
-	len := len(b.peers())
-
-	//tmpb := b
-	sort.Sort(bp)
-	//var total *big.Int
-	var total = big.NewInt(0)
-	rttList := list.New()
-
-	//まずは端っこの距離を計測する．
-	for i := 0; i < len; i++ {
-		//If the exising entry's RTT > new Entry's RTT,
-		//the existing one is put into the list
-		if i == len-1 {
-			break
-		}
-		//fmt.Println("#### 561/ ExsitingRTT:", bp[i].rtt.Nanoseconds(), " /NewRTT:", rtt.Nanoseconds())
-		if bp[i].GetRTT().Milliseconds() <= 0 {
-			//RTTの更新
-			existingRTT := rt.metrics.LatencyEWMA(bp[i].Id)
-			bp[i].SetRTT(existingRTT)
-		}
-
-		if bp[i].GetRTT().Milliseconds() > rtt.Milliseconds() {
-			rttList.PushFront(bp[i])
-			//fmt.Println("###564: RTT Reverse###")
-		}
-
-		//Calc ID Distance for two entries
-		dist := Distance(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-		dist = dist.Abs(dist)
-
-		//var dist2 = big.NewFloat(0)
-		//dist2.
-		//dist2 = dist2.Div(dist, big.NewInt(36893488147419103232 ))
-		//fmt.Println("dist:", dist)
-		total.Add(total, dist)
-
-	}
-	//fmt.Println("### 574: rttLlistLen:", rttList.Len())
-
-	var vTotal = big.NewInt(0)
-	//var variance *big.Int
-
-	//	start1 := time.Now()
-	avg := total.Div(total, big.NewInt(int64(len)))
-	//end1 := time.Since(start1)
-	//fmt.Println("###Time1:", end1.Microseconds())
-	//Derive the current ID variance
-	for i := 0; i < len-1; i++ {
-		//Calc ID Distance for two entries
-		//dist := Distance(ConvertPeerID(bp[i].Id),ConvertPeerID(bp[i+1].Id))
-		//total.Add(total, dist)
-		//start2 := time.Now()
-
-		dist := Distance(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-		dist = dist.Abs(dist)
-		//dist = dist.Div(dist, big.NewInt(36893488147419103232 ))
-		//end2 := time.Since(start2)
-		//fmt.Println("###Time2:", end2.Microseconds())
-
-		//start3 := time.Now()
-
-		sub := total.Abs(total.Sub(dist, avg))
-		//end3 := time.Since(start3)
-		//fmt.Println("###Time3:", end3.Microseconds())
-		////start4 := time.Now()
-
-		//m := sub.Mul(sub, sub)
-		m := sub.Add(sub, sub)
-		//end4 := time.Since(start4)
-		//	fmt.Println("###Time4:", end4.Microseconds())
-		//	start5 := time.Now()
-
-		vTotal.Add(vTotal, m)
-		//end5 := time.Since(start5)
-		//fmt.Println("###Time5:", end5.Microseconds())
-	}
-
-	retP := p
-	retP.Size()
-
-	//_ = rttList.Front().Value.(*PeerInfo).Id
-	//tmpB := newBucket()
-	//copy(tmpB.peers(), b.peers())
-
-	var isExchanged bool
-	isExchanged = false
-	for e := rttList.Front(); e != nil; e = e.Next() {
-		oldP := e.Value.(PeerInfo).Id
-		//Calc ID Variance for each peer RTT > new Peer RTT
-		//swap oldP and p for deriting the new variance.
-		newV := rt.getIDVariance(b, oldP, p, rtt)
-
-		if newV.Cmp(vTotal) <= 0 {
-			vTotal = newV
-			retP = oldP
-			isExchanged = true
-		}
-	}
-	/*
-		//If the actual ID is set to retP,
-		//the bucket should be updated.
-		replaceablePeer := b.min(func(p1 *PeerInfo, p2 *PeerInfo) bool {
-			return p1.replaceable
-		})
-
-		if replaceablePeer != nil && replaceablePeer.replaceable {
-			// let's evict it and add the new peer
-			if rt.removePeer(replaceablePeer.Id) {
-				b.pushFront(&PeerInfo{
-					Id:                            p,
-					LastUsefulAt:                  time.Now(),
-					LastSuccessfulOutboundQueryAt: time.Now(),
-					AddedAt:                       time.Now(),
-					dhtId:                         ConvertPeerID(p),
-					replaceable:                   true,
-				})
-				rt.PeerAdded(p)
-				//return true, nil
-			}
-		}
-
-	*/
-	if isExchanged {
-		if peer := b.getPeer(retP); peer != nil {
-			if peer.replaceable {
-				if rt.removePeer(retP) {
-					b.pushFront(&PeerInfo{
-						Id:                            p,
-						LastUsefulAt:                  time.Now(),
-						LastSuccessfulOutboundQueryAt: time.Now(),
-						AddedAt:                       time.Now(),
-						dhtId:                         ConvertPeerID(p),
-						replaceable:                   true,
-						rtt:                           rtt,
-					})
-					//tmpB.pushFront(info2)
-
-					//fmt.Println("### 633: ENTRY EXCHANGED!!!!!")
-					//rt.prob_exchange++
-					rt.num_exchange++
-					rt.PeerAdded(p)
-				}
-			}
-		}
-
-	} else {
-
-	}
-
-	//b.idVariance = vTotal
-
-	return vTotal
-
-}
-
-//Added by Kanemitsu
-func (rt *RoutingTable) calcIDVariance3(b *bucket, p peer.ID, rtt time.Duration) *big.Int {
-	//Sort by increasing order of key
-	var bp ByPeer = b.peers()
-	//peers := ks.ByPeer(bp)
-	len := len(b.peers())
-
-	//tmpb := b
-	sort.Sort(bp)
-	//var total *big.Int
-	var total = big.NewInt(0)
-	rttList := list.New()
-	lowList := list.New()
-	highList := list.New()
-
-	cnt := 0
-	//kyori := Distance(ConvertPeerID(bp[0].Id), ConvertPeerID(bp[len-1].Id))
-	//fmt.Println("BIT0:", (ConvertPeerID(bp[0].Id), "BITMax:", (ConvertPeerID(bp[len-1].Id))
-	//fmt.Println("BitLen:", kyori.BitLen())
-
-	//まずは端っこの距離を計測する．
-	for i := 0; i < len; i++ {
-		//If the exising entry's RTT > new Entry's RTT,
-		//the existing one is put into the list
-
-		if i == len-1 {
-			break
-		}
-		//fmt.Println("#### 561/ ExsitingRTT:", bp[i].rtt.Nanoseconds(), " /NewRTT:", rtt.Nanoseconds())
-		if bp[i].GetRTT().Milliseconds() <= 0 {
-			//RTTの更新
-			existingRTT := rt.metrics.LatencyEWMA(bp[i].Id)
-			bp[i].SetRTT(existingRTT)
-		}
-
-		if bp[i].GetRTT().Milliseconds() > rtt.Milliseconds() {
-			rttList.PushFront(bp[i])
-			//fmt.Println("###564: RTT Reverse###")
-		}
-		dist := big.NewInt(0)
-		if ConvertPeerID(bp[i].Id).less(rt.local) {
-			lowList.PushFront(bp[i])
-			if lowList.Len() > 1 {
-				dist = Distance(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-				dist = dist.Abs(dist)
-				total.Add(total, dist)
-				cnt++
-			}
-
-		} else {
-			highList.PushFront(bp[i])
-			if highList.Len() > 1 {
-				dist = Distance(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-				dist = dist.Abs(dist)
-				total.Add(total, dist)
-				cnt++
-			}
-		}
-
-	}
-	//fmt.Println("low:", lowList.Len(), "/High:", highList.Len())
-
-	var vTotal = big.NewInt(0)
-	//var variance *big.Int
-
-	//	start1 := time.Now()
-	avg := total.Div(total, big.NewInt(int64(cnt)))
-	lowList.Init()
-	highList.Init()
-	cnt = 0
-	for i := 0; i < len-1; i++ {
-		dist := big.NewInt(0)
-		if ConvertPeerID(bp[i].Id).less(rt.local) {
-			lowList.PushFront(bp[i])
-			if lowList.Len() > 1 {
-				dist = Distance(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-				dist = dist.Abs(dist)
-				sub := total.Abs(total.Sub(dist, avg))
-				m := sub.Add(sub, sub)
-				vTotal.Add(vTotal, m)
-				cnt++
-			}
-
-		} else {
-			highList.PushFront(bp[i])
-			if highList.Len() > 1 {
-				dist = Distance(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-				dist = dist.Abs(dist)
-				sub := total.Abs(total.Sub(dist, avg))
-				m := sub.Add(sub, sub)
-				vTotal.Add(vTotal, m)
-
-				cnt++
-			}
-		}
-
-	}
-
-	retP := p
-	retP.Size()
-
-	//_ = rttList.Front().Value.(*PeerInfo).Id
-	//tmpB := newBucket()
-	//copy(tmpB.peers(), b.peers())
-
-	var isExchanged bool
-	isExchanged = false
-	for e := rttList.Front(); e != nil; e = e.Next() {
-		oldP := e.Value.(PeerInfo).Id
-		//Calc ID Variance for each peer RTT > new Peer RTT
-		//swap oldP and p for deriting the new variance.
-		newV := rt.getIDVariance3(b, oldP, p, rtt)
-
-		if newV.Cmp(vTotal) <= 0 {
-			vTotal = newV
-			retP = oldP
-			isExchanged = true
-		}
-	}
-	/*
-		//If the actual ID is set to retP,
-		//the bucket should be updated.
-		replaceablePeer := b.min(func(p1 *PeerInfo, p2 *PeerInfo) bool {
-			return p1.replaceable
-		})
-
-		if replaceablePeer != nil && replaceablePeer.replaceable {
-			// let's evict it and add the new peer
-			if rt.removePeer(replaceablePeer.Id) {
-				b.pushFront(&PeerInfo{
-					Id:                            p,
-					LastUsefulAt:                  time.Now(),
-					LastSuccessfulOutboundQueryAt: time.Now(),
-					AddedAt:                       time.Now(),
-					dhtId:                         ConvertPeerID(p),
-					replaceable:                   true,
-				})
-				rt.PeerAdded(p)
-				//return true, nil
-			}
-		}
-
-	*/
-	if isExchanged {
-		if peer := b.getPeer(retP); peer != nil {
-			if peer.replaceable {
-				if rt.removePeer(retP) {
-					b.pushFront(&PeerInfo{
-						Id:                            p,
-						LastUsefulAt:                  time.Now(),
-						LastSuccessfulOutboundQueryAt: time.Now(),
-						AddedAt:                       time.Now(),
-						dhtId:                         ConvertPeerID(p),
-						replaceable:                   true,
-						rtt:                           rtt,
-					})
-					//tmpB.pushFront(info2)
-
-					//fmt.Println("### 633: ENTRY EXCHANGED!!!!!")
-					//rt.prob_exchange++
-					rt.num_exchange++
-
-					rt.PeerAdded(p)
-				}
-			}
-		}
-
-	} else {
-
-	}
-
-	//b.idVariance = vTotal
-
-	return vTotal
-
-}
-
-//rt.localよりも小さい/大きいでグループを分ける．
-//各グループ内でのdistanceの分散を求める．
-//そして，2つの分散の平均値で比較する．
-//Added by Kanemitsu
-func (rt *RoutingTable) calcIDVarianceByCPL(b *bucket, p peer.ID, rtt time.Duration) float64 {
-	//Sort by increasing order of key
-	var bp ByPeer = b.peers()
-	//peers := ks.ByPeer(bp)
-	len := len(b.peers())
-
-	//tmpb := b
-	sort.Sort(bp)
-	//var total *big.Int
-	var total = uint(0)
-	rttList := list.New()
-	//rttListLarge := list.New()
-	//lowList = list.New()
-	//highList = list.New()
-
-	//まずは端っこの距離を計測する．
-	for i := 0; i < len; i++ {
-		//If the exising entry's RTT > new Entry's RTT,
-		//the existing one is put into the list
-
-		if i == len-1 {
-			break
-		}
-		//fmt.Println("#### 561/ ExsitingRTT:", bp[i].rtt.Nanoseconds(), " /NewRTT:", rtt.Nanoseconds())
-		if bp[i].GetRTT().Milliseconds() <= 0 {
-			//RTTの更新
-			existingRTT := rt.metrics.LatencyEWMA(bp[i].Id)
-			bp[i].SetRTT(existingRTT)
-		}
-
-		if bp[i].GetRTT().Milliseconds() > rtt.Milliseconds() {
-			rttList.PushFront(bp[i])
-			//fmt.Println("###564: RTT Reverse###")
-		}
-
-		//Calc ID Distance for two entries
-
-		//dist := Distance(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-		//dist = dist.Abs(dist)
-		dist := CommonPrefixLen(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-
-		//var dist2 = big.NewFloat(0)
-		//dist2.
-		//dist2 = dist2.Div(dist, big.NewInt(36893488147419103232 ))
-		//fmt.Println("dist:", dist)
-		total += uint(dist)
-
-	}
-	//fmt.Println("### 574: rttLlistLen:", rttList.Len())
-
-	//var vTotal = big.NewInt(0)
-	var vTotal = float64(0)
-
-	//var variance *big.Int
-
-	//	start1 := time.Now()
-	avg := float64(float64(total) / float64(len))
-
-	//avg := total.Div(total, big.NewInt(int64(len)))
-	//end1 := time.Since(start1)
-	//fmt.Println("###Time1:", end1.Microseconds())
-	//Derive the current ID variance
-	var m = float64(0)
-
-	for i := 0; i < len-1; i++ {
-		//Calc ID Distance for two entries
-		//dist := Distance(ConvertPeerID(bp[i].Id),ConvertPeerID(bp[i+1].Id))
-		//total.Add(total, dist)
-		//start2 := time.Now()
-
-		//dist := Distance(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-		//dist = dist.Abs(dist)
-		dist := CommonPrefixLen(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-
-		//dist = dist.Div(dist, big.NewInt(36893488147419103232 ))
-		//end2 := time.Since(start2)
-		//fmt.Println("###Time2:", end2.Microseconds())
-
-		//start3 := time.Now()
-
-		//sub := total.Abs(total.Sub(dist, avg))
-		sub := math.Abs(float64(dist) - float64(avg))
-
-		//end3 := time.Since(start3)
-		//fmt.Println("###Time3:", end3.Microseconds())
-		////start4 := time.Now()
-		m += sub * sub
-		//m := sub.Mul(sub, sub)
-		//m := sub.Add(sub, sub)
-		//end4 := time.Since(start4)
-		//	fmt.Println("###Time4:", end4.Microseconds())
-		//	start5 := time.Now()
-		vTotal += m
-		//vTotal.Add(vTotal, m)
-		//end5 := time.Since(start5)
-		//fmt.Println("###Time5:", end5.Microseconds())
-	}
-
-	retP := p
-	retP.Size()
-
-	//_ = rttList.Front().Value.(*PeerInfo).Id
-	//tmpB := newBucket()
-	//copy(tmpB.peers(), b.peers())
-
-	var isExchanged bool
-	isExchanged = false
-	for e := rttList.Front(); e != nil; e = e.Next() {
-		oldP := e.Value.(PeerInfo).Id
-		//Calc ID Variance for each peer RTT > new Peer RTT
-		//swap oldP and p for deriting the new variance.
-		newV := rt.getIDVarianceByCPL(b, oldP, p, rtt)
-
-		//	if newV.Cmp(vTotal) <= 0 {
-		if newV <= vTotal {
-			vTotal = newV
-			retP = oldP
-			isExchanged = true
-		}
-	}
-	/*
-		//If the actual ID is set to retP,
-		//the bucket should be updated.
-		replaceablePeer := b.min(func(p1 *PeerInfo, p2 *PeerInfo) bool {
-			return p1.replaceable
-		})
-
-		if replaceablePeer != nil && replaceablePeer.replaceable {
-			// let's evict it and add the new peer
-			if rt.removePeer(replaceablePeer.Id) {
-				b.pushFront(&PeerInfo{
-					Id:                            p,
-					LastUsefulAt:                  time.Now(),
-					LastSuccessfulOutboundQueryAt: time.Now(),
-					AddedAt:                       time.Now(),
-					dhtId:                         ConvertPeerID(p),
-					replaceable:                   true,
-				})
-				rt.PeerAdded(p)
-				//return true, nil
-			}
-		}
-
-	*/
-	if isExchanged {
-		if peer := b.getPeer(retP); peer != nil {
-			if peer.replaceable {
-				if rt.removePeer(retP) {
-					b.pushFront(&PeerInfo{
-						Id:                            p,
-						LastUsefulAt:                  time.Now(),
-						LastSuccessfulOutboundQueryAt: time.Now(),
-						AddedAt:                       time.Now(),
-						dhtId:                         ConvertPeerID(p),
-						replaceable:                   true,
-						rtt:                           rtt,
-					})
-					//tmpB.pushFront(info2)
-
-					//fmt.Println("### 633: ENTRY EXCHANGED!!!!!")
-					rt.num_arrive++
-					rt.num_exchange++
-					rt.PeerAdded(p)
-				}
-			}
-		}
-
-	} else {
-
-	}
-
-	//b.idVariance = vTotal
-
-	return vTotal
-
-}
-
-func (rt *RoutingTable) remove(slice []PeerInfo, s int) []PeerInfo {
-	return append(slice[:s], slice[s+1:]...)
-}
-
-func (rt *RoutingTable) removePeerInfo(slice []PeerInfo, search peer.ID) []PeerInfo {
-	result := []PeerInfo{}
-	for _, v := range slice {
-		if v.Id != search {
-			result = append(result, v)
-		}
-	}
-	return result
-}
-
-func (rt *RoutingTable) getIDVarianceByCPL(b *bucket, oldP peer.ID, newP peer.ID, rtt time.Duration) float64 {
-	//var total = big.NewInt(0)
-	var total = uint(0)
-
-	//tmpB := b
-	//tmpB := newBucket()
-	//tmpB := b
-	tmpB := make([]PeerInfo, b.len())
-
-	copy(tmpB, b.peers())
-	tmpB = rt.removePeerInfo(tmpB, oldP)
-
-	tmpB = append(tmpB, PeerInfo{
-		Id:                            newP,
-		LastUsefulAt:                  time.Now(),
-		LastSuccessfulOutboundQueryAt: time.Now(),
-		AddedAt:                       time.Now(),
-		dhtId:                         ConvertPeerID(newP),
-		replaceable:                   true,
-		rtt:                           rtt,
-	})
-
-	len := len(tmpB)
-
-	var bp ByPeer = tmpB
-	//peers := ks.ByPeer(bp)
-
-	sort.Sort(bp)
-	//var total *big.Int
-
-	for i := 0; i < len; i++ {
-		//If the exising entry's RTT > new Entry's RTT,
-		//the existing one is put into the list
-		if i == len-1 {
-			break
-		}
-		//Calc ID Distance for two entries
-		dist := CommonPrefixLen(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-		total += uint(dist)
-
-	}
-
-	var vTotal = float64(0)
-
-	//var vTotal = big.NewInt(0)
-	//var variance *big.Int
-	avg := float64(float64(total) / float64(len))
-
-	//avg := total.Div(total, big.NewInt(int64(len)))
-	for i := 0; i < len; i++ {
-		//Calc ID Distance for two entries
-		//dist := Distance(ConvertPeerID(bp[i].Id),ConvertPeerID(bp[i+1].Id))
-		//total.Add(total, dist)
-		if i == len-1 {
-			break
-		}
-		dist := CommonPrefixLen(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-		//dist = dist.Abs(dist)
-		sub := math.Abs(float64(dist) - float64(avg))
-		m := sub * sub
-		//sub := total.Abs(total.Sub(dist, avg))
-		//m := sub.Mul(sub, sub)
-		//m := sub.Add(sub, sub)
-		vTotal += float64(m)
-		//vTotal.Add(vTotal, m)
-	}
-
-	return vTotal
-}
-
-//Added by Kanemitsu
-func (rt *RoutingTable) getIDVariance(b *bucket, oldP peer.ID, newP peer.ID, rtt time.Duration) *big.Int {
-	var total = big.NewInt(0)
-	//tmpB := b
-	//tmpB := newBucket()
-	//tmpB := b
-	tmpB := make([]PeerInfo, b.len())
-
-	copy(tmpB, b.peers())
-	tmpB = rt.removePeerInfo(tmpB, oldP)
-
-	tmpB = append(tmpB, PeerInfo{
-		Id:                            newP,
-		LastUsefulAt:                  time.Now(),
-		LastSuccessfulOutboundQueryAt: time.Now(),
-		AddedAt:                       time.Now(),
-		dhtId:                         ConvertPeerID(newP),
-		replaceable:                   true,
-		rtt:                           rtt,
-	})
-
-	len := len(tmpB)
-
-	var bp ByPeer = tmpB
-	//peers := ks.ByPeer(bp)
-
-	sort.Sort(bp)
-	//var total *big.Int
-
-	for i := 0; i < len; i++ {
-		//If the exising entry's RTT > new Entry's RTT,
-		//the existing one is put into the list
-		if i == len-1 {
-			break
-		}
-		//Calc ID Distance for two entries
-		dist := Distance(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-		dist = dist.Abs(dist)
-		total.Add(total, dist)
-
-	}
-
-	var vTotal = big.NewInt(0)
-	//var variance *big.Int
-	avg := total.Div(total, big.NewInt(int64(len)))
-	for i := 0; i < len; i++ {
-		//Calc ID Distance for two entries
-		//dist := Distance(ConvertPeerID(bp[i].Id),ConvertPeerID(bp[i+1].Id))
-		//total.Add(total, dist)
-		if i == len-1 {
-			break
-		}
-		dist := Distance(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-		dist = dist.Abs(dist)
-		sub := total.Abs(total.Sub(dist, avg))
-		//m := sub.Mul(sub, sub)
-		m := sub.Add(sub, sub)
-		vTotal.Add(vTotal, m)
-	}
-
-	return vTotal
-}
-
-//Added by Kanemitsu
-func (rt *RoutingTable) getIDVariance3(b *bucket, oldP peer.ID, newP peer.ID, rtt time.Duration) *big.Int {
-	var total = big.NewInt(0)
-	//tmpB := b
-	//tmpB := newBucket()
-	//tmpB := b
-	tmpB := make([]PeerInfo, b.len())
-
-	copy(tmpB, b.peers())
-	tmpB = rt.removePeerInfo(tmpB, oldP)
-
-	tmpB = append(tmpB, PeerInfo{
-		Id:                            newP,
-		LastUsefulAt:                  time.Now(),
-		LastSuccessfulOutboundQueryAt: time.Now(),
-		AddedAt:                       time.Now(),
-		dhtId:                         ConvertPeerID(newP),
-		replaceable:                   true,
-		rtt:                           rtt,
-	})
-
-	len := len(tmpB)
-	lowList := list.New()
-	highList := list.New()
-
-	var bp ByPeer = tmpB
-	//peers := ks.ByPeer(bp)
-
-	sort.Sort(bp)
-	//var total *big.Int
-	cnt := 0
-	for i := 0; i < len; i++ {
-		//If the exising entry's RTT > new Entry's RTT,
-		//the existing one is put into the list
-		if i == len-1 {
-			break
-		}
-		dist := big.NewInt(0)
-		if ConvertPeerID(bp[i].Id).less(rt.local) {
-			lowList.PushFront(bp[i])
-			if lowList.Len() > 1 {
-				dist = Distance(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-				dist = dist.Abs(dist)
-
-				total.Add(total, dist)
-				cnt++
-			}
-
-		} else {
-			highList.PushFront(bp[i])
-			if highList.Len() > 1 {
-				dist = Distance(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-				dist = dist.Abs(dist)
-
-				total.Add(total, dist)
-
-				cnt++
-			}
-		}
-
-	}
-
-	var vTotal = big.NewInt(0)
-	//var variance *big.Int
-	lowList.Init()
-	highList.Init()
-	avg := total.Div(total, big.NewInt(int64(len)))
-	cnt = 0
-	for i := 0; i < len; i++ {
-		//Calc ID Distance for two entries
-		//dist := Distance(ConvertPeerID(bp[i].Id),ConvertPeerID(bp[i+1].Id))
-		//total.Add(total, dist)
-		if i == len-1 {
-			break
-		}
-
-		dist := big.NewInt(0)
-		if ConvertPeerID(bp[i].Id).less(rt.local) {
-			lowList.PushFront(bp[i])
-			if lowList.Len() > 1 {
-				dist = Distance(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-				dist = dist.Abs(dist)
-				sub := total.Abs(total.Sub(dist, avg))
-				m := sub.Add(sub, sub)
-				vTotal.Add(vTotal, m)
-				cnt++
-			}
-
-		} else {
-			highList.PushFront(bp[i])
-			if highList.Len() > 1 {
-				dist = Distance(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-				dist = dist.Abs(dist)
-				sub := total.Abs(total.Sub(dist, avg))
-				m := sub.Add(sub, sub)
-				vTotal.Add(vTotal, m)
-
-				cnt++
-			}
-		}
-
-	}
-
-	return vTotal
-}
-
-//Added by Kanemitsu
-func (rt *RoutingTable) getIDVarianceWithOut(b ByPeer, id peer.ID) *big.Int {
-	var total = big.NewInt(0)
-	//tmpB := b
-	/*	tmpB := newBucket()
-		copy(tmpB.peers(), b)
-		tmpB.remove(id)
-	*/
-	tmpB := make([]PeerInfo, len(b))
-
-	copy(tmpB, b)
-	tmpB = rt.removePeerInfo(tmpB, id)
-
-	len := len(tmpB)
-
-	var bp ByPeer = tmpB
-	//peers := ks.ByPeer(bp)
-
-	sort.Sort(bp)
-	//var total *big.Int
-
-	for i := 0; i < len; i++ {
-		//If the exising entry's RTT > new Entry's RTT,
-		//the existing one is put into the list
-		if i == len-1 {
-			break
-		}
-		//Calc ID Distance for two entries
-		dist := Distance(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-		dist = dist.Abs(dist)
-		total.Add(total, dist)
-
-	}
-
-	var vTotal = big.NewInt(0)
-	//var variance *big.Int
-	avg := total.Div(total, big.NewInt(int64(len)))
-	for i := 0; i < len; i++ {
-		//Calc ID Distance for two entries
-		//dist := Distance(ConvertPeerID(bp[i].Id),ConvertPeerID(bp[i+1].Id))
-		//total.Add(total, dist)
-		if i == len-1 {
-			break
-		}
-		dist := Distance(ConvertPeerID(bp[i].Id), ConvertPeerID(bp[i+1].Id))
-		dist = dist.Abs(dist)
-		sub := total.Abs(total.Sub(dist, avg))
-		//m := sub.Mul(sub, sub)
-		m := sub.Add(sub, sub)
-		vTotal.Add(vTotal, m)
-	}
-
-	return vTotal
-}
-
-func (rt *RoutingTable) addPeerKadRTT(p peer.ID, queryPeer bool, isReplaceable bool, rtt time.Duration) (bool, error) {
-	bucketID := rt.bucketIdForPeer(p)
-	bucket := rt.buckets[bucketID]
-	//rtt = rt.metrics.LatencyEWMA(p)
-	//fmt.Print("****RTT:", rtt)
-	now := time.Now()
-	var lastUsefulAt time.Time
-	if queryPeer {
-		lastUsefulAt = now
-	}
-
-	//Update the exchange probability
-	//At first, increment the # of arrivals.
-	rt.num_arrive++
-	span := time.Since(rt.lastExTime)
-	//fmt.Print("######## 814")
-	//fmt.Print("^^^^^^^^^^^^^^^^^alpha:", bucket.GetAlpha(), "/ beta:", bucket.GetBeta(),"/ K:", bucket.GetK())
-
-	if span.Seconds() >= rt.rttInterval.Seconds() {
-
-		//old_alpha := bucket.alpha
-		//old_beta := bucket.beta
-		//old_k := bucket.k
-		//fmt.Print("######## 820")
-		rt.lastExTime = time.Now()
-		rt.arv_rate_store = float64(float64(rt.num_arrive) / float64(span))
-		rt.prob_exchange = float64(float64(rt.num_exchange) / float64(rt.num_arrive))
-		//fmt.Print("######## 824")
-
-		//Update optimal values for alpha, beta, k for the specific k-bucket index.
-		bucket = rt.setOptValues(bucketID)
-		//fmt.Print("######## 828")
-
-		//bucket = rt.GetBucket(bucketID)
-		if bucket.len() < bucket.k {
-			rt.prob_exchange = 1
-		}
-
-		if bucket.len() > bucket.k {
-			//if the number of bucket entries is reduced, we must
-			//select members to be discarded.
-			//select the by which the ID variance is large.
-			num := bucket.len() - bucket.k
-
-			var bp ByPeer = bucket.peers()
-			//peers := ks.ByPeer(bp)
-			peer_num := len(bucket.peers())
-			//fmt.Print("######## 844")
-
-			//tmpb := b
-			//IDの小さい順にソートする．
-			sort.Sort(bp)
-			retList := list.New()
-			//fmt.Print("######## 850")
-
-			for i := 0; i < peer_num; i++ {
-				//Derive ID variance when bp[i] is evicted.
-				variance := rt.getIDVarianceWithOut(bp, bp[i].Id)
-
-				retList.PushFront(&VarianceInfo{
-					id:       bp[i].Id,
-					variance: variance,
-				})
-
-			}
-			ps := make([]VarianceInfo, 0, retList.Len())
-			for e := retList.Front(); e != nil; e = e.Next() {
-				p := e.Value.(*VarianceInfo)
-				ps = append(ps, *p)
-			}
-			var bv ByVariance = ps
-			sort.Sort(bv)
-			//len2 := bv.Len()
-
-			//sort.Sort(retList)
-
-			//fmt.Println("#### Before:%d", len(bucket.peers()))
-			//Remove $num peers from the k-bucket.
-
-			for i := 0; i < num; i++ {
-				targetID := bv[i]
-				bucket.remove(targetID.id)
-				//retList.Remove(targetID)
-			}
-			//fmt.Println("#### After:%d", len(bucket.peers()))
-			//fmt.Print("######## 866")
-
-		} else {
-			//If the number is expanded, no need for reducing the entries in
-			// the bucket.
-			//fmt.Print("######## 870")
-
-		}
-		//Reset the values of exchange probability for the next calculation
-
-		rt.num_arrive = 1
-		rt.num_exchange = 0
-
-	}
-	//fmt.Print("######## 882")
-
-	// peer already exists in the Routing Table.
-	if peer := bucket.getPeer(p); peer != nil {
-		// if we're querying the peer first time after adding it, let's give it a
-		// usefulness bump. This will ONLY happen once.
-		if peer.LastUsefulAt.IsZero() && queryPeer {
-			peer.LastUsefulAt = lastUsefulAt
-		}
-		//fmt.Print("######## 891")
-
-		return false, nil
-	}
-
-	//fmt.Print("****RTT:", rtt)
-
-	//fmt.Println("***currentLatency: %d / Max:%d: ", rt.metrics.LatencyEWMA(p), rt.maxLatency)
-	// peer's latency threshold is NOT acceptable
-	if rt.metrics.LatencyEWMA(p) > rt.maxLatency {
-		// Connection doesnt meet requirements, skip!
-		//fmt.Print("######## 902")
-
-		return false, ErrPeerRejectedHighLatency
-	}
-	//以降は，ローカルにpが無い状況．
-
-	// add it to the diversity filter for now.
-	// if we aren't able to find a place for the peer in the table,
-	// we will simply remove it from the Filter later.
-	if rt.df != nil {
-		//fmt.Print("######## 912")
+// NPeersForCPL returns the number of peers we have for a given Cpl
+func (rt *RoutingTable) NPeersForCpl(cpl uint) int {
+	rt.tabLock.RLock()
+	defer rt.tabLock.RUnlock()
 
-		if !rt.df.TryAdd(p) {
-			return false, errors.New("peer rejected by the diversity filter")
+	// it's in the last bucket
+	if int(cpl) >= len(rt.buckets)-1 {
+		count := 0
+		b := rt.buckets[len(rt.buckets)-1]
+		for _, p := range b.peers() {
+			if CommonPrefixLen(rt.local, p.dhtId) == int(cpl) {
+				count++
+			}
 		}
+		return count
+	} else {
+		return rt.buckets[cpl].len()
 	}
-	bsize := rt.bucketsize
-	if rt.isKadRTT {
-		bsize = bucket.k
-	}
-
-	// We have enough space in the bucket (whether spawned or grouped).
-	if bucket.len() < bsize {
-		//fmt.Print("######## 925")
-
-		bucket.pushFront(&PeerInfo{
-			Id:                            p,
-			LastUsefulAt:                  lastUsefulAt,
-			LastSuccessfulOutboundQueryAt: now,
-			AddedAt:                       now,
-			dhtId:                         ConvertPeerID(p),
-			replaceable:                   isReplaceable,
-			rtt:                           rtt,
-		})
-		rt.PeerAdded(p)
-		//fmt.Print("######## 937")
+}
 
-		return true, nil
-	}
+// TryAddPeer tries to add a peer to the Routing table.
+// If the peer ALREADY exists in the Routing Table and has been queried before, this call is a no-op.
+// If the peer ALREADY exists in the Routing Table but hasn't been queried before, we set it's LastUsefulAt value to
+// the current time. This needs to done because we don't mark peers as "Useful"(by setting the LastUsefulAt value)
+// when we first connect to them.
+//
+// If the peer is a queryPeer i.e. we queried it or it queried us, we set the LastSuccessfulOutboundQuery to the current time.
+// If the peer is just a peer that we connect to/it connected to us without any DHT query, we consider it as having
+// no LastSuccessfulOutboundQuery.
+//
+//
+// If the logical bucket to which the peer belongs is full and it's not the last bucket, the AdmissionPolicy of the
+// table decides whether an existing peer in that bucket is replaced with the new peer.
+// If the policy rejects the peer, we do NOT add the peer to the Routing Table and return error "ErrPeerRejectedNoCapacity".
 
-	//in case of k-bucket is full
-	if bucketID == len(rt.buckets)-1 {
-		//fmt.Print("######## 944")
+// It returns a boolean value set to true if the peer was newly added to the Routing Table, false otherwise.
+// It also returns any error that occurred while adding the peer to the Routing Table. If the error is not nil,
+// the boolean value will ALWAYS be false i.e. the peer wont be added to the Routing Table it it's not already there.
+//
+// A return value of false with error=nil indicates that the peer ALREADY exists in the Routing Table.
+func (rt *RoutingTable) TryAddPeer(p peer.ID, queryPeer bool, isReplaceable bool) (bool, error) {
+	rt.tabLock.Lock()
+	defer rt.tabLock.Unlock()
 
-		// if the bucket is too large and this is the last bucket (i.e. wildcard), unfold it.
-		rt.nextBucket()
-		// the structure of the table has changed, so let's recheck if the peer now has a dedicated bucket.
-		bucketID = rt.bucketIdForPeer(p)
-		bucket = rt.buckets[bucketID]
-		blen := rt.bucketsize
-		if rt.isKadRTT {
-			blen = bucket.k
-		}
-		//fmt.Print("######## 955")
+	return rt.addPeer(p, queryPeer, isReplaceable, 0)
+}
 
-		// push the peer only if the bucket isn't overflowing after slitting
-		if bucket.len() < blen /*rt.bucketsize*/ {
-			//fmt.Print("######## 959")
-
-			bucket.pushFront(&PeerInfo{
-				Id:                            p,
-				LastUsefulAt:                  lastUsefulAt,
-				LastSuccessfulOutboundQueryAt: now,
-				AddedAt:                       now,
-				dhtId:                         ConvertPeerID(p),
-				replaceable:                   isReplaceable,
-				rtt:                           rtt,
-			})
-			//fmt.Print("######## 970")
+// TryAddPeer2 is the same as TryAddPeerKadRTT and is kept for compatibility.
+func (rt *RoutingTable) TryAddPeer2(p peer.ID, queryPeer bool, isReplaceable bool, rtt time.Duration) (bool, error) {
+	return rt.TryAddPeerKadRTT(p, queryPeer, isReplaceable, rtt)
+}
 
-			rt.PeerAdded(p)
-			return true, nil
-		}
-	}
+// TryAddPeerKadRTT is the same as TryAddPeer, but records the given RTT for the peer
+// and hands it to the AdmissionPolicy if the bucket of the peer is full.
+func (rt *RoutingTable) TryAddPeerKadRTT(p peer.ID, queryPeer bool, isReplaceable bool, rtt time.Duration) (bool, error) {
+	rt.tabLock.Lock()
+	defer rt.tabLock.Unlock()
 
-	//the case that k-bucket is full.
-	//Added by Kanemitsu
-	if rt.isKadRTT {
+	return rt.addPeer(p, queryPeer, isReplaceable, rtt)
+}
 
-		//If the k-bucket is full, ID-rearrengement is performed.
-		//If the ID variance is made lower, the exchange is accepted.
-		//To do that, the current ID variance is calculated when addPeer is called.
-		//IDVariance := rt.calcIDVariance(bucket, bucketID, p, rtt)
-		//fmt.Print("######## 985")
-
-		if bucket.len() == 0 {
-			//fmt.Print("######## 988")
-
-			bucket.pushFront(&PeerInfo{
-				Id:                            p,
-				LastUsefulAt:                  lastUsefulAt,
-				LastSuccessfulOutboundQueryAt: now,
-				AddedAt:                       now,
-				dhtId:                         ConvertPeerID(p),
-				replaceable:                   true,
-				rtt:                           rtt,
-			})
+func Distance(k1, k2 []byte) *big.Int {
+	// XOR the keys
+	k3 := u.XOR(k1, k2)
 
-			rt.PeerAdded(p)
-			//fmt.Print("######## 1001")
-
-			rt.num_exchange++
-		} else if bucket.len() == 1 {
-			//fmt.Print("######## 1005")
-
-			info := bucket.list.Front().Value.(*PeerInfo)
-			//fmt.Print("######## 1006")
-
-			//fmt.Println("*****firstRTT: %d, NextRTT, %d", info.GetRTT(), rtt)
-
-			if info.GetRTT() > rtt {
-				//fmt.Print("######## 1013")
-
-				//swap
-				if info.replaceable {
-					if rt.removePeer(info.Id) {
-						bucket.pushFront(&PeerInfo{
-							Id:                            p,
-							LastUsefulAt:                  lastUsefulAt,
-							LastSuccessfulOutboundQueryAt: now,
-							AddedAt:                       now,
-							dhtId:                         ConvertPeerID(p),
-							replaceable:                   true,
-							rtt:                           rtt,
-						})
-						rt.PeerAdded(p)
-						//fmt.Print("######## 1027")
-					}
-					rt.num_exchange++
-				}
-
-				//return true, nil
-			}
+	// interpret it as an integer
+	dist := big.NewInt(0).SetBytes(k3)
+	return dist
+}
 
-		} else {
-			//fmt.Println("############ IDVariance #############")
-			//start := time.Now()
-			rt.calcIDVarianceByCPL(bucket, p, rtt)
-			//fmt.Print("#####BID:", bucketID, "/")
-			//dur := time.Since(start)
-			//fmt.Println("############Elapsed: ", dur.Milliseconds(), "(ms)")
-		}
+// updateKadRTTParams counts the arrival of a new STORE(addPeer) request and, once per rttInterval,
+// re-derives the optimal parameters of the given bucket from the new store rate and exchange probability.
+// If a bucket has to shrink, the peers whose eviction leaves the most evenly spaced IDs are discarded first.
+// locking is the responsibility of the caller
+func (rt *RoutingTable) updateKadRTTParams(bucketID int) {
+	if _, windowDone := rt.stats.RecordArrival(); !windowDone {
+		return
+	}
 
-	} else {
-		// the bucket to which the peer belongs is full. Let's try to find a peer
-		// in that bucket which is replaceable.
-		// we don't really need a stable sort here as it dosen't matter which peer we evict
-		// as long as it's a replaceable peer.
-		replaceablePeer := bucket.min(func(p1 *PeerInfo, p2 *PeerInfo) bool {
-			return p1.replaceable
-		})
+	//Update optimal values for alpha, beta, k for the specific k-bucket index.
+	bucket := rt.setOptValues(bucketID)
 
-		if replaceablePeer != nil && replaceablePeer.replaceable {
-			// let's evict it and add the new peer
-			if rt.removePeer(replaceablePeer.Id) {
-				bucket.pushFront(&PeerInfo{
-					Id:                            p,
-					LastUsefulAt:                  lastUsefulAt,
-					LastSuccessfulOutboundQueryAt: now,
-					AddedAt:                       now,
-					dhtId:                         ConvertPeerID(p),
-					replaceable:                   isReplaceable,
-				})
-				rt.PeerAdded(p)
-				return true, nil
-			}
-		}
+	if bucket.len() < bucket.k {
+		rt.stats.SetExchangeProbability(1)
 	}
+}
 
-	// we weren't able to find place for the peer, remove it from the filter state.
-	if rt.df != nil {
-		rt.df.Remove(p)
+// bucketCapacity returns the maximum number of peers the given bucket may hold.
+func (rt *RoutingTable) bucketCapacity(b *bucket) int {
+	if rt.isKadRTT {
+		return b.k
 	}
-	return false, ErrPeerRejectedNoCapacity
+	return rt.bucketsize
 }
 
 // locking is the responsibility of the caller
-func (rt *RoutingTable) addPeer(p peer.ID, queryPeer bool, isReplaceable bool) (bool, error) {
+func (rt *RoutingTable) addPeer(p peer.ID, queryPeer bool, isReplaceable bool, rtt time.Duration) (bool, error) {
 	bucketID := rt.bucketIdForPeer(p)
 	bucket := rt.buckets[bucketID]
 
-	now := time.Now()
+	now := rt.clock.Now()
 	var lastUsefulAt time.Time
 	if queryPeer {
 		lastUsefulAt = now
 	}
 
+	if rt.isKadRTT {
+		rt.updateKadRTTParams(bucketID)
+		// evictions may have collapsed buckets
+		bucketID = rt.bucketIdForPeer(p)
+		bucket = rt.buckets[bucketID]
+	}
+
 	// peer already exists in the Routing Table.
 	if peer := bucket.getPeer(p); peer != nil {
 		// if we're querying the peer first time after adding it, let's give it a
@@ -1702,235 +551,153 @@ func (rt *RoutingTable) addPeer(p peer.ID, queryPeer bool, isReplaceable bool) (
 		if peer.LastUsefulAt.IsZero() && queryPeer {
 			peer.LastUsefulAt = lastUsefulAt
 		}
+		peer.rtt.AddSample(rtt, now)
+		if rtt > 0 {
+			rt.emit(Event{Type: EventRTTUpdated, Time: now, Cpl: bucketID, Peer: p, RTT: rtt})
+		}
 		return false, nil
 	}
-	//fmt.Println("****Latency: %d / Max: %d", rt.metrics.LatencyEWMA(p),rt.maxLatency )
+
+	candidate := &PeerInfo{
+		Id:                            p,
+		LastUsefulAt:                  lastUsefulAt,
+		LastSuccessfulOutboundQueryAt: now,
+		AddedAt:                       now,
+		dhtId:                         ConvertPeerID(p),
+		replaceable:                   isReplaceable,
+	}
+	candidate.rtt.AddSample(rtt, now)
+
+	return rt.insertPeer(candidate, rtt, now)
+}
+
+// insertPeer tries to find a place for a peer that isn't in the table yet.
+// locking is the responsibility of the caller
+func (rt *RoutingTable) insertPeer(candidate *PeerInfo, rtt time.Duration, now time.Time) (bool, error) {
+	p := candidate.Id
+	bucketID := rt.bucketIdForPeer(p)
+	bucket := rt.buckets[bucketID]
+
 	// peer's latency threshold is NOT acceptable
 	if rt.metrics.LatencyEWMA(p) > rt.maxLatency {
 		// Connection doesnt meet requirements, skip!
+		rt.emitRejected(bucketID, candidate, rtt, RejectHighLatency, now)
 		return false, ErrPeerRejectedHighLatency
 	}
-	//以降は，ローカルにpが無い状況．
 
 	// add it to the diversity filter for now.
 	// if we aren't able to find a place for the peer in the table,
 	// we will simply remove it from the Filter later.
 	if rt.df != nil {
 		if !rt.df.TryAdd(p) {
+			rt.emitRejected(bucketID, candidate, rtt, RejectDiversity, now)
 			return false, errors.New("peer rejected by the diversity filter")
 		}
 	}
-	bsize := rt.bucketsize
-	if rt.isKadRTT {
-		//bsize = bucket.k
-	}
-
-	//まずはpingを行って遅延を計測する．
 
 	// We have enough space in the bucket (whether spawned or grouped).
-	if bucket.len() < bsize {
-		bucket.pushFront(&PeerInfo{
-			Id:                            p,
-			LastUsefulAt:                  lastUsefulAt,
-			LastSuccessfulOutboundQueryAt: now,
-			AddedAt:                       now,
-			dhtId:                         ConvertPeerID(p),
-			replaceable:                   isReplaceable,
-		})
+	if bucket.len() < rt.bucketCapacity(bucket) {
+		rt.pushPeer(bucket, candidate)
+		rt.emitAdded(bucketID, candidate, rtt, now)
 		rt.PeerAdded(p)
-
 		return true, nil
 	}
 
-	//Bucketがフルの場合
 	if bucketID == len(rt.buckets)-1 {
 		// if the bucket is too large and this is the last bucket (i.e. wildcard), unfold it.
 		rt.nextBucket()
 		// the structure of the table has changed, so let's recheck if the peer now has a dedicated bucket.
 		bucketID = rt.bucketIdForPeer(p)
 		bucket = rt.buckets[bucketID]
-		blen := rt.bucketsize
-		if rt.isKadRTT {
-			blen = bucket.k
-		}
+
 		// push the peer only if the bucket isn't overflowing after slitting
-		if bucket.len() < blen /*rt.bucketsize*/ {
-			bucket.pushFront(&PeerInfo{
-				Id:                            p,
-				LastUsefulAt:                  lastUsefulAt,
-				LastSuccessfulOutboundQueryAt: now,
-				AddedAt:                       now,
-				dhtId:                         ConvertPeerID(p),
-				replaceable:                   isReplaceable,
-			})
+		if bucket.len() < rt.bucketCapacity(bucket) {
+			rt.pushPeer(bucket, candidate)
+			rt.emitAdded(bucketID, candidate, rtt, now)
 			rt.PeerAdded(p)
 			return true, nil
 		}
 	}
 
-	//if rt.isKadRTT {
-	//以降は，フルのとき．
-	//もし新規ピアpを入れることで，ID距離の分散が小さくなるのであれば交換する．
-	//そのために，現状の分散値を取得する．
-
-	//}else{
-	// the bucket to which the peer belongs is full. Let's try to find a peer
-	// in that bucket which is replaceable.
-	// we don't really need a stable sort here as it dosen't matter which peer we evict
-	// as long as it's a replaceable peer.
-	replaceablePeer := bucket.min(func(p1 *PeerInfo, p2 *PeerInfo) bool {
-		return p1.replaceable
-	})
-
-	if replaceablePeer != nil && replaceablePeer.replaceable {
-		// let's evict it and add the new peer
-		if rt.removePeer(replaceablePeer.Id) {
-			bucket.pushFront(&PeerInfo{
-				Id:                            p,
-				LastUsefulAt:                  lastUsefulAt,
-				LastSuccessfulOutboundQueryAt: now,
-				AddedAt:                       now,
-				dhtId:                         ConvertPeerID(p),
-				replaceable:                   isReplaceable,
-			})
-			rt.PeerAdded(p)
-			return true, nil
+	// the bucket to which the peer belongs is full. Let the admission policy decide
+	// whether the peer gets in and who has to make place for it.
+	peers := bucket.peers()
+	for i := range peers {
+		if peers[i].rtt.IsStale(now, rt.rttMaxAge) {
+			peers[i].rtt = RTTStats{}
+			peers[i].rtt.AddSample(rt.metrics.LatencyEWMA(peers[i].Id), now)
 		}
 	}
-	//}
-
-	// we weren't able to find place for the peer, remove it from the filter state.
-	if rt.df != nil {
-		rt.df.Remove(p)
-	}
-	return false, ErrPeerRejectedNoCapacity
-}
-
-// locking is the responsibility of the caller
-func (rt *RoutingTable) addPeer2(p peer.ID, queryPeer bool, isReplaceable bool, rtt time.Duration) (bool, error) {
-	bucketID := rt.bucketIdForPeer(p)
-	bucket := rt.buckets[bucketID]
-
-	now := time.Now()
-	var lastUsefulAt time.Time
-	if queryPeer {
-		lastUsefulAt = now
+	// a candidate added without a measurement, e.g. with TryAddPeer, is judged on its peerstore latency,
+	// as the stale peers of the bucket are
+	if rtt <= 0 {
+		rtt = rt.metrics.LatencyEWMA(p)
+		candidate.rtt.AddSample(rtt, now)
 	}
-
-	// peer already exists in the Routing Table.
-	if peer := bucket.getPeer(p); peer != nil {
-		// if we're querying the peer first time after adding it, let's give it a
-		// usefulness bump. This will ONLY happen once.
-		if peer.LastUsefulAt.IsZero() && queryPeer {
-			peer.LastUsefulAt = lastUsefulAt
+	admitted := *candidate
+	if rt.df != nil && rt.df.IsSoft() {
+		admitted.crowding = 1 - rt.df.Score(p)
+		for i := range peers {
+			peers[i].crowding = 1 - rt.df.Score(peers[i].Id)
 		}
-		return false, nil
 	}
-	//fmt.Println("****Latency: %d / Max: %d", rt.metrics.LatencyEWMA(p),rt.maxLatency )
-	// peer's latency threshold is NOT acceptable
-	if rt.metrics.LatencyEWMA(p) > rt.maxLatency {
-		// Connection doesnt meet requirements, skip!
-		return false, ErrPeerRejectedHighLatency
-	}
-	//以降は，ローカルにpが無い状況．
 
-	// add it to the diversity filter for now.
-	// if we aren't able to find a place for the peer in the table,
-	// we will simply remove it from the Filter later.
-	if rt.df != nil {
-		if !rt.df.TryAdd(p) {
-			return false, errors.New("peer rejected by the diversity filter")
-		}
-	}
-	bsize := rt.bucketsize
-	if rt.isKadRTT {
-		//bsize = bucket.k
+	var decision AdmissionDecision
+	var victim peer.ID
+	if pol, ok := rt.admission.(spacingAdmissionPolicy); ok {
+		decision, victim = pol.admit(peers, bucket.spacing, admitted, rtt)
+	} else {
+		decision, victim = rt.admission.Admit(peers, admitted, rtt)
 	}
-
-	//まずはpingを行って遅延を計測する．
-
-	// We have enough space in the bucket (whether spawned or grouped).
-	if bucket.len() < bsize {
-		bucket.pushFront(&PeerInfo{
-			Id:                            p,
-			LastUsefulAt:                  lastUsefulAt,
-			LastSuccessfulOutboundQueryAt: now,
-			AddedAt:                       now,
-			dhtId:                         ConvertPeerID(p),
-			replaceable:                   isReplaceable,
-			rtt:                           rtt,
-		})
+	switch decision {
+	case AdmissionAccept:
+		rt.pushPeer(bucket, candidate)
+		rt.emitAdded(bucketID, candidate, rtt, now)
 		rt.PeerAdded(p)
-
-		return true, nil
-	}
-
-	//Bucketがフルの場合
-	if bucketID == len(rt.buckets)-1 {
-		// if the bucket is too large and this is the last bucket (i.e. wildcard), unfold it.
-		rt.nextBucket()
-		// the structure of the table has changed, so let's recheck if the peer now has a dedicated bucket.
-		bucketID = rt.bucketIdForPeer(p)
-		bucket = rt.buckets[bucketID]
-		blen := rt.bucketsize
 		if rt.isKadRTT {
-			//blen = bucket.k
+			rt.stats.RecordExchange()
 		}
-		// push the peer only if the bucket isn't overflowing after slitting
-		if bucket.len() < blen /*rt.bucketsize*/ {
-			bucket.pushFront(&PeerInfo{
-				Id:                            p,
-				LastUsefulAt:                  lastUsefulAt,
-				LastSuccessfulOutboundQueryAt: now,
-				AddedAt:                       now,
-				dhtId:                         ConvertPeerID(p),
-				replaceable:                   isReplaceable,
-				rtt:                           rtt,
-			})
-			rt.PeerAdded(p)
-			return true, nil
+		return true, nil
+	case AdmissionReplace:
+		if bucket.getPeer(victim) == nil {
+			log.Warnf("admission policy tried to replace %s which is not in the bucket", victim)
+			break
 		}
-	}
-
-	//if rt.isKadRTT {
-	//以降は，フルのとき．
-	//もし新規ピアpを入れることで，ID距離の分散が小さくなるのであれば交換する．
-	//そのために，現状の分散値を取得する．
-
-	//}else{
-	// the bucket to which the peer belongs is full. Let's try to find a peer
-	// in that bucket which is replaceable.
-	// we don't really need a stable sort here as it dosen't matter which peer we evict
-	// as long as it's a replaceable peer.
-	replaceablePeer := bucket.min(func(p1 *PeerInfo, p2 *PeerInfo) bool {
-		return p1.replaceable
-	})
-
-	if replaceablePeer != nil && replaceablePeer.replaceable {
 		// let's evict it and add the new peer
-		if rt.removePeer(replaceablePeer.Id) {
-			bucket.pushFront(&PeerInfo{
-				Id:                            p,
-				LastUsefulAt:                  lastUsefulAt,
-				LastSuccessfulOutboundQueryAt: now,
-				AddedAt:                       now,
-				dhtId:                         ConvertPeerID(p),
-				replaceable:                   isReplaceable,
-				rtt:                           rtt,
-			})
+		if rt.removePeer(victim) {
+			// the bucket may have been collapsed, look it up again.
+			bucketID = rt.bucketIdForPeer(p)
+			bucket = rt.buckets[bucketID]
+			rt.pushPeer(bucket, candidate)
+			rt.emit(Event{Type: EventPeerReplaced, Time: now, Cpl: bucketID, Peer: p, RTT: rtt, Replaced: victim})
 			rt.PeerAdded(p)
+			if rt.isKadRTT {
+				rt.stats.RecordExchange()
+			}
 			return true, nil
 		}
 	}
-	//}
 
 	// we weren't able to find place for the peer, remove it from the filter state.
 	if rt.df != nil {
 		rt.df.Remove(p)
 	}
+	rt.emitRejected(bucketID, candidate, rtt, RejectNoCapacity, now)
+	// but keep it around in case a peer of the bucket goes away.
+	rt.cacheReplacement(candidate, now)
 	return false, ErrPeerRejectedNoCapacity
 }
 
+// pushPeer adds the peer to the front of the bucket and to the index.
+// locking is the responsibility of the caller
+func (rt *RoutingTable) pushPeer(b *bucket, pi *PeerInfo) {
+	b.pushFront(pi)
+	b.replacements.remove(pi.Id)
+	if rt.trie != nil {
+		rt.trie.add(pi)
+	}
+}
+
 // MarkAllPeersIrreplaceable marks all peers in the routing table as irreplaceable
 // This means that we will never replace an existing peer in the table to make space for a new peer.
 // However, they can still be removed by calling the `RemovePeer` API.
@@ -1994,11 +761,26 @@ func (rt *RoutingTable) UpdateLastUsefulAt(p peer.ID, t time.Time) bool {
 
 // RemovePeer should be called when the caller is sure that a peer is not useful for queries.
 // For eg: the peer could have stopped supporting the DHT protocol.
-// It evicts the peer from the Routing Table.
+// It evicts the peer from the Routing Table, and the best peer of the replacement cache
+// of its bucket, if any, takes its place.
 func (rt *RoutingTable) RemovePeer(p peer.ID) {
 	rt.tabLock.Lock()
 	defer rt.tabLock.Unlock()
+
+	bucket := rt.buckets[rt.bucketIdForPeer(p)]
+	if bucket.getPeer(p) == nil {
+		// it may be waiting for a place, it won't be useful there either.
+		bucket.replacements.remove(p)
+		return
+	}
+
+	// the bucket may be collapsed once the peer is removed, so take its candidates first.
+	now := rt.clock.Now()
+	candidates := bucket.replacements.take(now, rt.rttMaxAge)
+	bucketID := rt.bucketIdForPeer(p)
 	rt.removePeer(p)
+	rt.emit(Event{Type: EventPeerRemoved, Time: now, Cpl: bucketID, Peer: p})
+	rt.refillBucket(candidates, now)
 }
 
 // locking is the responsibility of the caller
@@ -2006,25 +788,13 @@ func (rt *RoutingTable) removePeer(p peer.ID) bool {
 	bucketID := rt.bucketIdForPeer(p)
 	bucket := rt.buckets[bucketID]
 	if bucket.remove(p) {
+		if rt.trie != nil {
+			rt.trie.remove(ConvertPeerID(p))
+		}
 		if rt.df != nil {
 			rt.df.Remove(p)
 		}
-		for {
-			lastBucketIndex := len(rt.buckets) - 1
-
-			// remove the last bucket if it's empty and it isn't the only bucket we have
-			if len(rt.buckets) > 1 && rt.buckets[lastBucketIndex].len() == 0 {
-				rt.buckets[lastBucketIndex] = nil
-				rt.buckets = rt.buckets[:lastBucketIndex]
-			} else if len(rt.buckets) >= 2 && rt.buckets[lastBucketIndex-1].len() == 0 {
-				// if the second last bucket just became empty, remove and replace it with the last bucket.
-				rt.buckets[lastBucketIndex-1] = rt.buckets[lastBucketIndex]
-				rt.buckets[lastBucketIndex] = nil
-				rt.buckets = rt.buckets[:lastBucketIndex]
-			} else {
-				break
-			}
-		}
+		rt.collapseEmptyBuckets()
 
 		// peer removed callback
 		rt.PeerRemoved(p)
@@ -2033,6 +803,31 @@ func (rt *RoutingTable) removePeer(p peer.ID) bool {
 	return false
 }
 
+// collapseEmptyBuckets folds the empty buckets at the end of the table.
+// locking is the responsibility of the caller
+func (rt *RoutingTable) collapseEmptyBuckets() {
+	for {
+		lastBucketIndex := len(rt.buckets) - 1
+
+		// remove the last bucket if it's empty and it isn't the only bucket we have
+		if len(rt.buckets) > 1 && rt.buckets[lastBucketIndex].len() == 0 {
+			rt.mergeReplacements(rt.buckets[lastBucketIndex-1], rt.buckets[lastBucketIndex])
+			rt.buckets[lastBucketIndex] = nil
+			rt.buckets = rt.buckets[:lastBucketIndex]
+			rt.emit(Event{Type: EventBucketCollapsed, Cpl: lastBucketIndex})
+		} else if len(rt.buckets) >= 2 && rt.buckets[lastBucketIndex-1].len() == 0 {
+			// if the second last bucket just became empty, remove and replace it with the last bucket.
+			rt.mergeReplacements(rt.buckets[lastBucketIndex], rt.buckets[lastBucketIndex-1])
+			rt.buckets[lastBucketIndex-1] = rt.buckets[lastBucketIndex]
+			rt.buckets[lastBucketIndex] = nil
+			rt.buckets = rt.buckets[:lastBucketIndex]
+			rt.emit(Event{Type: EventBucketCollapsed, Cpl: lastBucketIndex - 1})
+		} else {
+			break
+		}
+	}
+}
+
 func (rt *RoutingTable) nextBucket() {
 	// This is the last bucket, which allegedly is a mixed bag containing peers not belonging in dedicated (unfolded) buckets.
 	// _allegedly_ is used here to denote that *all* peers in the last bucket might feasibly belong to another bucket.
@@ -2044,6 +839,7 @@ func (rt *RoutingTable) nextBucket() {
 	newBucket := bucket.split(len(rt.buckets)-1, rt.local)
 
 	rt.buckets = append(rt.buckets, newBucket)
+	rt.emit(Event{Type: EventBucketSplit, Cpl: len(rt.buckets) - 1})
 
 	//Added by Kanemitsu
 	//newBucket = rt.setOptValues(len(rt.buckets)-1)
@@ -2083,25 +879,62 @@ func (rt *RoutingTable) NearestPeer(id ID) peer.ID {
 
 // NearestPeers returns a list of the 'count' closest peers to the given ID
 func (rt *RoutingTable) NearestPeers(id ID, count int) []peer.ID {
+	// It's assumed that this also protects the buckets.
+	rt.tabLock.RLock()
+	pds := rt.nearestPeers(id, count)
+	rt.tabLock.RUnlock()
+
+	out := make([]peer.ID, 0, pds.Len())
+	for _, p := range pds.peers {
+		out = append(out, p.p)
+	}
+	return out
+}
+
+// NearestPeersByPolicy returns the 'count' closest peers to the given ID, as NearestPeers does,
+// in the order given by the ranking policy.
+func (rt *RoutingTable) NearestPeersByPolicy(id ID, count int, policy RankingPolicy) []peer.ID {
+	rt.tabLock.RLock()
+	pds := rt.nearestPeers(id, count)
+	now := rt.clock.Now()
+	ranked := make([]RankedPeer, 0, pds.Len())
+	for _, p := range pds.peers {
+		var rtt time.Duration
+		if pi := rt.buckets[rt.bucketIdForPeer(p.p)].getPeer(p.p); pi != nil {
+			rtt = rt.effectiveRTT(pi, now)
+		}
+		ranked = append(ranked, RankedPeer{Peer: p.p, Distance: p.distance, RTT: rtt})
+	}
+	rt.tabLock.RUnlock()
+
+	policy.Rank(ranked)
+
+	out := make([]peer.ID, 0, len(ranked))
+	for _, p := range ranked {
+		out = append(out, p.Peer)
+	}
+	return out
+}
+
+// nearestPeers returns the 'count' closest peers to the given ID, sorted by distance.
+// locking is the responsibility of the caller
+func (rt *RoutingTable) nearestPeers(id ID, count int) *peerDistanceSorter {
+	if rt.trie != nil {
+		return rt.nearestPeersFromTrie(id, count)
+	}
+
 	// This is the number of bits _we_ share with the key. All peers in this
 	// bucket share cpl bits with us and will therefore share at least cpl+1
 	// bits with the given key. +1 because both the target and all peers in
 	// this bucket differ from us in the cpl bit.
 	cpl := CommonPrefixLen(id, rt.local)
 
-	// It's assumed that this also protects the buckets.
-	rt.tabLock.RLock()
-
 	// Get bucket index or last bucket
 	if cpl >= len(rt.buckets) {
 		cpl = len(rt.buckets) - 1
 	}
-	//Added by Kanemitsu
-	if rt.isKadRTT {
-		count = rt.buckets[cpl].beta
-	}
 
-	pds := peerDistanceSorter{
+	pds := &peerDistanceSorter{
 		peers:  make([]peerDistance, 0, count+rt.bucketsize),
 		target: id,
 	}
@@ -2113,9 +946,8 @@ func (rt *RoutingTable) NearestPeers(id ID, count int) []peer.ID {
 	// to the right share exactly cpl bits (as opposed to the cpl+1 bits
 	// shared by the peers in the cpl bucket).
 	//
-	// This is, unfortunately, less efficient than we'd like. We will switch
-	// to a trie implementation eventually which will allow us to find the
-	// closest N peers to any target key.
+	// This is, unfortunately, less efficient than we'd like. The XORTrieIndex
+	// option lets us find the closest N peers to any target key directly.
 
 	if pds.Len() < count {
 		for i := cpl + 1; i < len(rt.buckets); i++ {
@@ -2133,7 +965,6 @@ func (rt *RoutingTable) NearestPeers(id ID, count int) []peer.ID {
 	for i := cpl - 1; i >= 0 && pds.Len() < count; i-- {
 		pds.appendPeersFromList(rt.buckets[i].list)
 	}
-	//rt.tabLock.RUnlock()
 
 	// Sort by distance to local peer
 	pds.sort()
@@ -2141,88 +972,23 @@ func (rt *RoutingTable) NearestPeers(id ID, count int) []peer.ID {
 	if count < pds.Len() {
 		pds.peers = pds.peers[:count]
 	}
+	return pds
+}
 
-	if rt.isKadRTT && len(pds.peers) > 0 {
-
-		//get the 1st entry in pds that has the shortest distance with ID.
-		first := pds.peers[0]
-		fID := first.p
-		fcpl := rt.bucketIdForPeer(fID)
-		fRTT := rt.buckets[fcpl].getPeer(fID).rtt
-
-		minDist := first.distance
-
-		rttpds := peerRTTDistanceSorter{
-			peers:  make([]peerRTTDistance, 0, count+rt.bucketsize),
-			target: id,
-		}
-		rttpds.appendPeersFromList(rt.buckets[cpl].list)
-
-		if rttpds.Len() < count {
-			for i := cpl + 1; i < len(rt.buckets); i++ {
-				rttpds.appendPeersFromList(rt.buckets[i].list)
-			}
-		}
-
-		// If we're still short, add in buckets that share _fewer_ bits. We can
-		// do this bucket by bucket because each bucket will share 1 fewer bit
-		// than the last.
-		//
-		// * bucket cpl-1: cpl-1 shared bits.
-		// * bucket cpl-2: cpl-2 shared bits.
-		// ...
-		for i := cpl - 1; i >= 0 && rttpds.Len() < count; i-- {
-			rttpds.appendPeersFromList(rt.buckets[i].list)
-		}
-		//Sort by increasing order of RTT.
-		rttpds.sort()
-
-		rt.tabLock.RUnlock()
-		//For each entry of rttpds, the log condition is checked.
-		//if the condition is satisfied, the entry is added with hither
-		//priority.
-		OKList := make([]peer.ID, 0, pds.Len())
-		NGList := make([]peer.ID, 0, pds.Len())
-
-		for _, p := range rttpds.peers {
-			minDistInt := big.NewInt(0).SetBytes(minDist)
-			pDistInt := big.NewInt(0).SetBytes(p.distance)
-			minDistIntDouble := minDistInt.Mul(minDistInt, big.NewInt(2))
-			if pDistInt.Cmp(minDistIntDouble) < 0 {
-				//If it's OK, p should have higher priority.
-				rtt := p.rtt
-				if rtt <= fRTT {
-					OKList = append(OKList, p.p)
-
-				} else {
-					NGList = append(NGList, p.p)
-				}
-
-			} else {
-				NGList = append(NGList, p.p)
-			}
-
-		}
-		out := make([]peer.ID, 0, pds.Len())
-		for _, p := range OKList {
-			out = append(out, p)
-		}
-
-		for _, p := range NGList {
-			out = append(out, p)
-		}
-		return out
-
-	} else {
-		rt.tabLock.RUnlock()
-		out := make([]peer.ID, 0, pds.Len())
-		for _, p := range pds.peers {
-			out = append(out, p.p)
-		}
-
-		return out
-	}
-
+// nearestPeersFromTrie walks the index towards the given ID until it has found 'count' peers,
+// which come out sorted by distance.
+// locking is the responsibility of the caller
+func (rt *RoutingTable) nearestPeersFromTrie(id ID, count int) *peerDistanceSorter {
+	pds := &peerDistanceSorter{target: id}
+	if count <= 0 {
+		return pds
+	}
+	pds.peers = make([]peerDistance, 0, count)
+	rt.trie.walkClosest(id, func(pi *PeerInfo) bool {
+		pds.appendPeer(pi.Id, pi.dhtId)
+		return pds.Len() < count
+	})
+	return pds
 }
 
 // Size returns the total number of peers in the routing table
@@ -2275,13 +1041,15 @@ func (rt *RoutingTable) GetDiversityStats() []peerdiversity.CplDiversityStats {
 
 // the caller is responsible for the locking
 func (rt *RoutingTable) bucketIdForPeer(p peer.ID) int {
-	peerID := ConvertPeerID(p)
-	cpl := CommonPrefixLen(peerID, rt.local)
-	bucketID := cpl
-	if bucketID >= len(rt.buckets) {
-		bucketID = len(rt.buckets) - 1
+	return rt.bucketIndex(CommonPrefixLen(ConvertPeerID(p), rt.local))
+}
+
+// the caller is responsible for the locking
+func (rt *RoutingTable) bucketIndex(cpl int) int {
+	if cpl >= len(rt.buckets) {
+		return len(rt.buckets) - 1
 	}
-	return bucketID
+	return cpl
 }
 
 // maxCommonPrefix returns the maximum common prefix length between any peer in
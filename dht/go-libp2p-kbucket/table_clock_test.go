@@ -0,0 +1,106 @@
+package kbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/test"
+
+	"github.com/libp2p/go-libp2p-kbucket/clock"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKadRTTIntervalsWithMockClock(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewMock()
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(20, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil,
+		KadRTT(true), Clock(clk), RTTInterval(time.Minute), InitialStoreRate(0.5), InitialExchangeProbability(0.5))
+	require.NoError(t, err)
+	defer rt.Close()
+
+	start := clk.Now()
+	require.Equal(t, start, rt.AdaptationStats().WindowStart)
+
+	// every interval, the store rate is the number of arrivals of the window, which counts one more
+	// from the start, over its length in nanoseconds
+	for i, arrivals := range []int{30, 90, 6} {
+		for j := 0; j < arrivals-1; j++ {
+			_, err := rt.TryAddPeerKadRTT(test.RandPeerIDFatal(t), true, false, time.Duration(1+j%20)*time.Millisecond)
+			if err != nil {
+				require.Equal(t, ErrPeerRejectedNoCapacity, err)
+			}
+		}
+		stats := rt.AdaptationStats()
+		require.Equal(t, uint64(i), stats.Windows)
+		require.Equal(t, int64(arrivals), stats.Arrivals)
+
+		// the arrival that comes after the end of the interval closes the window
+		clk.Add(time.Minute)
+		_, _ = rt.TryAddPeerKadRTT(test.RandPeerIDFatal(t), true, false, time.Millisecond)
+		stats = rt.AdaptationStats()
+		require.Equal(t, uint64(i+1), stats.Windows)
+		require.Equal(t, float64(arrivals+1)/float64(time.Minute), stats.StoreRate)
+		require.Equal(t, start.Add(time.Duration(i+1)*time.Minute), stats.WindowStart)
+		require.Equal(t, int64(1), stats.Arrivals)
+	}
+
+	// nothing happens as long as the clock doesn't move
+	_, _ = rt.TryAddPeerKadRTT(test.RandPeerIDFatal(t), true, false, time.Millisecond)
+	require.Equal(t, uint64(3), rt.AdaptationStats().Windows)
+}
+
+func TestRTTFreshnessWithMockClock(t *testing.T) {
+	t.Parallel()
+
+	clk := clock.NewMock()
+	local := test.RandPeerIDFatal(t)
+	rt, err := NewRoutingTable(20, ConvertPeerID(local), time.Hour, pstore.NewMetrics(), NoOpThreshold, nil,
+		KadRTT(true), Clock(clk), RTTMaxAge(time.Minute))
+	require.NoError(t, err)
+	defer rt.Close()
+
+	sub := rt.Subscribe()
+	defer sub.Close()
+
+	added := clk.Now()
+	p := test.RandPeerIDFatal(t)
+	_, err = rt.TryAddPeerKadRTT(p, true, false, 10*time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, added, rt.GetPeerInfos()[0].AddedAt)
+
+	clk.Add(30 * time.Second)
+	rtt, ok := rt.EstimateRTT(p)
+	require.True(t, ok)
+	require.Equal(t, 10*time.Millisecond, rtt)
+
+	// the sample gets stale, and nothing else is known
+	clk.Add(31 * time.Second)
+	_, ok = rt.EstimateRTT(p)
+	require.False(t, ok)
+
+	rt.SetRTT(p, 20*time.Millisecond)
+	stats, _ := rt.RTTStats(p)
+	require.Equal(t, clk.Now(), stats.LastMeasuredAt())
+	rtt, ok = rt.EstimateRTT(p)
+	require.True(t, ok)
+	require.Equal(t, stats.EWMA(), rtt)
+
+	// events are stamped with the time of the clock
+	evts := drain(t, sub)
+	require.Len(t, evts, 2)
+	require.Equal(t, added, evts[0].Time)
+	require.Equal(t, clk.Now(), evts[1].Time)
+
+	// a sample recorded on a copy of the peer info is stamped with the time it is given
+	pi := rt.GetPeerInfos()[0]
+	clk.Add(time.Minute)
+	pi.SetRTT(30*time.Millisecond, clk.Now())
+	require.Equal(t, clk.Now(), pi.RTTStats().LastMeasuredAt())
+	require.False(t, pi.RTTStats().IsStale(clk.Now(), time.Minute))
+
+	require.Error(t, Clock(nil)(rt))
+}
@@ -0,0 +1,205 @@
+package vivaldi
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// PeerCoordinate is the coordinate of a peer, as it was last heard of.
+type PeerCoordinate struct {
+	Peer       peer.ID     `json:"peer"`
+	Coordinate *Coordinate `json:"coordinate"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+// Client maintains the coordinate of the local node from the RTT samples to its peers,
+// along with the coordinates of the peers it heard of. It is safe for concurrent use.
+type Client struct {
+	mu sync.Mutex
+
+	cfg   Config
+	coord *Coordinate
+	rng   *rand.Rand
+
+	peers   map[peer.ID]*PeerCoordinate
+	samples map[peer.ID][]float64
+}
+
+// NewClient returns a client whose coordinate starts at the origin.
+func NewClient(cfg Config) (*Client, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Client{
+		cfg:     cfg,
+		coord:   NewCoordinate(cfg),
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		peers:   make(map[peer.ID]*PeerCoordinate),
+		samples: make(map[peer.ID][]float64),
+	}, nil
+}
+
+// Config returns the parameters of the client.
+func (c *Client) Config() Config {
+	return c.cfg
+}
+
+// Coordinate returns a copy of the local coordinate.
+func (c *Client) Coordinate() *Coordinate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.coord.Clone()
+}
+
+// Update records an RTT sample to the given peer, whose coordinate is other, and moves the local coordinate
+// accordingly. It returns the new local coordinate.
+func (c *Client) Update(p peer.ID, other *Coordinate, rtt time.Duration) (*Coordinate, error) {
+	if err := other.Validate(c.cfg.Dimensions); err != nil {
+		return nil, err
+	}
+	if rtt <= 0 || rtt > c.cfg.MaxRTT {
+		return nil, ErrInvalidRTT
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setPeerCoordinate(p, other, time.Now())
+	c.update(other, c.filteredRTT(p, rtt.Seconds()))
+	return c.coord.Clone(), nil
+}
+
+// locking is the responsibility of the caller
+func (c *Client) update(other *Coordinate, rtt float64) {
+	dist := c.coord.distanceTo(other)
+
+	// the weight of the sample grows with our error, relative to the error of the peer
+	totalErr := math.Max(c.coord.Error+other.Error, zeroThreshold)
+	weight := c.coord.Error / totalErr
+
+	relErr := math.Abs(dist-rtt) / rtt
+	c.coord.Error = math.Min(c.cfg.CE*weight*relErr+c.coord.Error*(1.0-c.cfg.CE*weight), c.cfg.ErrorMax)
+
+	force := c.cfg.CC * weight * (rtt - dist)
+	c.coord.applyForce(c.cfg, force, other, c.random)
+}
+
+// filteredRTT returns the median of the latest samples to the peer, including the given one.
+// locking is the responsibility of the caller
+func (c *Client) filteredRTT(p peer.ID, rtt float64) float64 {
+	samples := append(c.samples[p], rtt)
+	if len(samples) > c.cfg.LatencyFilterSize {
+		samples = samples[1:]
+	}
+	c.samples[p] = samples
+
+	sorted := make([]float64, len(samples))
+	copy(sorted, samples)
+	sort.Float64s(sorted)
+	return sorted[len(sorted)/2]
+}
+
+// locking is the responsibility of the caller
+func (c *Client) random() []float64 {
+	r := make([]float64, c.cfg.Dimensions)
+	for i := range r {
+		r[i] = c.rng.Float64() - 0.5
+	}
+	return r
+}
+
+// SetPeerCoordinate records the coordinate of a peer without an RTT sample, e.g. when it is relayed by
+// another peer. Coordinates older than the one already known are ignored. Those dated in the future are
+// dated now: otherwise a relaying peer could plant coordinates that are never evicted nor updated.
+func (c *Client) SetPeerCoordinate(p peer.ID, coord *Coordinate, updatedAt time.Time) error {
+	if err := coord.Validate(c.cfg.Dimensions); err != nil {
+		return err
+	}
+	if now := time.Now(); updatedAt.After(now) {
+		updatedAt = now
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if known, ok := c.peers[p]; ok && known.UpdatedAt.After(updatedAt) {
+		return nil
+	}
+	c.setPeerCoordinate(p, coord, updatedAt)
+	return nil
+}
+
+// locking is the responsibility of the caller
+func (c *Client) setPeerCoordinate(p peer.ID, coord *Coordinate, updatedAt time.Time) {
+	if _, ok := c.peers[p]; !ok && len(c.peers) >= c.cfg.MaxPeers {
+		// forget the peer we haven't heard of for the longest time
+		var oldest *PeerCoordinate
+		for _, pc := range c.peers {
+			if oldest == nil || pc.UpdatedAt.Before(oldest.UpdatedAt) {
+				oldest = pc
+			}
+		}
+		c.forget(oldest.Peer)
+	}
+	c.peers[p] = &PeerCoordinate{Peer: p, Coordinate: coord.Clone(), UpdatedAt: updatedAt}
+}
+
+// PeerCoordinate returns a copy of the coordinate of the peer, if it is known.
+func (c *Client) PeerCoordinate(p peer.ID) (*Coordinate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pc, ok := c.peers[p]
+	if !ok {
+		return nil, false
+	}
+	return pc.Coordinate.Clone(), true
+}
+
+// Peers returns up to max of the peer coordinates known, most recently updated first.
+func (c *Client) Peers(max int) []PeerCoordinate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pcs := make([]PeerCoordinate, 0, len(c.peers))
+	for _, pc := range c.peers {
+		pcs = append(pcs, PeerCoordinate{Peer: pc.Peer, Coordinate: pc.Coordinate.Clone(), UpdatedAt: pc.UpdatedAt})
+	}
+	sort.Slice(pcs, func(i, j int) bool { return pcs[i].UpdatedAt.After(pcs[j].UpdatedAt) })
+	if max >= 0 && len(pcs) > max {
+		pcs = pcs[:max]
+	}
+	return pcs
+}
+
+// EstimateRTT returns the RTT to the peer estimated from its coordinate, if it is known.
+func (c *Client) EstimateRTT(p peer.ID) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pc, ok := c.peers[p]
+	if !ok {
+		return 0, false
+	}
+	return c.coord.DistanceTo(pc.Coordinate), true
+}
+
+// Forget drops the coordinate and the RTT samples of the peer.
+func (c *Client) Forget(p peer.ID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.forget(p)
+}
+
+// locking is the responsibility of the caller
+func (c *Client) forget(p peer.ID) {
+	delete(c.peers, p)
+	delete(c.samples, p)
+}
@@ -0,0 +1,197 @@
+// Package vivaldi implements Vivaldi network coordinates, with which a node estimates the RTT to
+// peers it has never measured from the coordinates they advertise.
+//
+// Every node keeps a coordinate in a low dimensional Euclidean space augmented with a height, which
+// models the access link of the node. Each RTT sample to a peer whose coordinate is known pulls or
+// pushes the local coordinate so that the distance between the two coordinates gets closer to the
+// sample. Over time, the distance between the coordinates of any two nodes approximates the RTT
+// between them.
+//
+// See F. Dabek, R. Cox, F. Kaashoek and R. Morris, "Vivaldi: A Decentralized Network Coordinate System",
+// SIGCOMM 2004.
+package vivaldi
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// zeroThreshold is the distance under which two coordinates are considered at the same place.
+const zeroThreshold = 1.0e-6
+
+var (
+	// ErrDimensions is returned for coordinates whose dimensions don't match the configuration.
+	ErrDimensions = errors.New("coordinate dimensions don't match")
+	// ErrInvalidCoordinate is returned for coordinates with NaN or infinite components, or a negative height or error.
+	ErrInvalidCoordinate = errors.New("invalid coordinate")
+	// ErrInvalidRTT is returned for RTT samples that are not positive or unrealistically large.
+	ErrInvalidRTT = errors.New("invalid rtt")
+)
+
+// Config holds the parameters of the Vivaldi algorithm.
+type Config struct {
+	// Dimensions is the number of dimensions of the Euclidean part of the coordinates.
+	Dimensions int
+	// ErrorMax is the highest, and initial, error estimate of a coordinate.
+	ErrorMax float64
+	// CE bounds the weight of a sample in the update of the error estimate.
+	CE float64
+	// CC bounds the weight of a sample in the update of the coordinate.
+	CC float64
+	// HeightMin is the minimum height of a coordinate, in seconds.
+	HeightMin float64
+	// LatencyFilterSize is the number of samples per peer whose median is used to update the
+	// coordinate, which filters out the occasional outlier.
+	LatencyFilterSize int
+	// MaxPeers is the number of peer coordinates a Client remembers.
+	MaxPeers int
+	// MaxRTT is the largest RTT sample accepted.
+	MaxRTT time.Duration
+}
+
+// DefaultConfig returns the parameters recommended by the Vivaldi paper, with an 8 dimensional space.
+func DefaultConfig() Config {
+	return Config{
+		Dimensions:        8,
+		ErrorMax:          1.5,
+		CE:                0.25,
+		CC:                0.25,
+		HeightMin:         10.0e-6,
+		LatencyFilterSize: 3,
+		MaxPeers:          1024,
+		MaxRTT:            10 * time.Second,
+	}
+}
+
+// Validate returns an error if the parameters are out of range.
+func (c Config) Validate() error {
+	switch {
+	case c.Dimensions <= 0:
+		return fmt.Errorf("dimensions must be positive, got %d", c.Dimensions)
+	case c.ErrorMax <= 0:
+		return fmt.Errorf("max error must be positive, got %f", c.ErrorMax)
+	case c.CE <= 0 || c.CE > 1:
+		return fmt.Errorf("ce must be in (0, 1], got %f", c.CE)
+	case c.CC <= 0 || c.CC > 1:
+		return fmt.Errorf("cc must be in (0, 1], got %f", c.CC)
+	case c.HeightMin < 0:
+		return fmt.Errorf("min height must not be negative, got %f", c.HeightMin)
+	case c.LatencyFilterSize <= 0:
+		return fmt.Errorf("latency filter size must be positive, got %d", c.LatencyFilterSize)
+	case c.MaxPeers <= 0:
+		return fmt.Errorf("max peers must be positive, got %d", c.MaxPeers)
+	case c.MaxRTT <= 0:
+		return fmt.Errorf("max rtt must be positive, got %s", c.MaxRTT)
+	}
+	return nil
+}
+
+// Coordinate is a network coordinate. Distances are in seconds.
+type Coordinate struct {
+	Vec    []float64 `json:"vec"`
+	Height float64   `json:"height"`
+	// Error is the confidence of the node in its coordinate; lower is better.
+	Error float64 `json:"error"`
+}
+
+// NewCoordinate returns a coordinate at the origin, with the highest error.
+func NewCoordinate(cfg Config) *Coordinate {
+	return &Coordinate{
+		Vec:    make([]float64, cfg.Dimensions),
+		Height: cfg.HeightMin,
+		Error:  cfg.ErrorMax,
+	}
+}
+
+// Clone returns a deep copy of the coordinate.
+func (c *Coordinate) Clone() *Coordinate {
+	vec := make([]float64, len(c.Vec))
+	copy(vec, c.Vec)
+	return &Coordinate{Vec: vec, Height: c.Height, Error: c.Error}
+}
+
+func finite(f float64) bool {
+	return !math.IsInf(f, 0) && !math.IsNaN(f)
+}
+
+// Validate returns an error if the coordinate doesn't have the given dimensions or isn't valid.
+func (c *Coordinate) Validate(dimensions int) error {
+	if c == nil || len(c.Vec) != dimensions {
+		return ErrDimensions
+	}
+	for _, v := range c.Vec {
+		if !finite(v) {
+			return ErrInvalidCoordinate
+		}
+	}
+	if !finite(c.Height) || !finite(c.Error) || c.Height < 0 || c.Error < 0 {
+		return ErrInvalidCoordinate
+	}
+	return nil
+}
+
+// DistanceTo returns the RTT estimated between the two coordinates, which must have the same dimensions.
+func (c *Coordinate) DistanceTo(other *Coordinate) time.Duration {
+	return secondsToDuration(c.distanceTo(other))
+}
+
+func (c *Coordinate) distanceTo(other *Coordinate) float64 {
+	return magnitude(diff(c.Vec, other.Vec)) + c.Height + other.Height
+}
+
+// applyForce moves the coordinate by force seconds, away from other if it is positive and towards it otherwise.
+// The height takes its share of the force, in proportion to its part in the distance.
+func (c *Coordinate) applyForce(cfg Config, force float64, other *Coordinate, random func() []float64) {
+	unit, mag := unitVectorAt(c.Vec, other.Vec, random)
+	for i := range c.Vec {
+		c.Vec[i] += unit[i] * force
+	}
+	if mag > zeroThreshold {
+		c.Height = (c.Height+other.Height)*force/mag + c.Height
+	}
+	c.Height = math.Max(c.Height, cfg.HeightMin)
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func diff(a, b []float64) []float64 {
+	d := make([]float64, len(a))
+	for i := range a {
+		d[i] = a[i] - b[i]
+	}
+	return d
+}
+
+func magnitude(v []float64) float64 {
+	sum := 0.0
+	for _, x := range v {
+		sum += x * x
+	}
+	return math.Sqrt(sum)
+}
+
+// unitVectorAt returns the unit vector pointing from b to a, and the distance between them.
+// If they are at the same place, the direction is random.
+func unitVectorAt(a, b []float64, random func() []float64) ([]float64, float64) {
+	d := diff(a, b)
+	if mag := magnitude(d); mag > zeroThreshold {
+		for i := range d {
+			d[i] /= mag
+		}
+		return d, mag
+	}
+
+	for {
+		r := random()
+		if mag := magnitude(r); mag > zeroThreshold {
+			for i := range r {
+				r[i] /= mag
+			}
+			return r, 0
+		}
+	}
+}
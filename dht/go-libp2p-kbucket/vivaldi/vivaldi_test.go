@@ -0,0 +1,178 @@
+package vivaldi
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, DefaultConfig().Validate())
+
+	cfg := DefaultConfig()
+	cfg.Dimensions = 0
+	require.Error(t, cfg.Validate())
+	_, err := NewClient(cfg)
+	require.Error(t, err)
+
+	cfg = DefaultConfig()
+	cfg.CC = 1.5
+	require.Error(t, cfg.Validate())
+}
+
+func TestUpdateValidation(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewClient(DefaultConfig())
+	require.NoError(t, err)
+	p := test.RandPeerIDFatal(t)
+
+	_, err = c.Update(p, &Coordinate{Vec: make([]float64, 2)}, time.Millisecond)
+	require.Equal(t, ErrDimensions, err)
+
+	bad := NewCoordinate(DefaultConfig())
+	bad.Vec[0] = math.NaN()
+	_, err = c.Update(p, bad, time.Millisecond)
+	require.Equal(t, ErrInvalidCoordinate, err)
+
+	_, err = c.Update(p, NewCoordinate(DefaultConfig()), 0)
+	require.Equal(t, ErrInvalidRTT, err)
+	_, err = c.Update(p, NewCoordinate(DefaultConfig()), time.Minute)
+	require.Equal(t, ErrInvalidRTT, err)
+
+	_, ok := c.PeerCoordinate(p)
+	require.False(t, ok)
+
+	// two coordinates at the origin are pushed apart in a random direction
+	coord, err := c.Update(p, NewCoordinate(DefaultConfig()), 10*time.Millisecond)
+	require.NoError(t, err)
+	require.Greater(t, magnitude(coord.Vec), 0.0)
+	rtt, ok := c.EstimateRTT(p)
+	require.True(t, ok)
+	require.Greater(t, int64(rtt), int64(0))
+
+	c.Forget(p)
+	_, ok = c.EstimateRTT(p)
+	require.False(t, ok)
+}
+
+func TestPeerCoordinates(t *testing.T) {
+	t.Parallel()
+
+	cfg := DefaultConfig()
+	cfg.MaxPeers = 2
+	c, err := NewClient(cfg)
+	require.NoError(t, err)
+
+	now := time.Now()
+	var peers []peer.ID
+	for i := 0; i < 3; i++ {
+		p := test.RandPeerIDFatal(t)
+		peers = append(peers, p)
+		coord := NewCoordinate(cfg)
+		coord.Vec[0] = float64(i+1) * 0.01
+		require.NoError(t, c.SetPeerCoordinate(p, coord, now.Add(time.Duration(i-3)*time.Second)))
+	}
+
+	// the peer heard of first was forgotten
+	_, ok := c.PeerCoordinate(peers[0])
+	require.False(t, ok)
+	pcs := c.Peers(-1)
+	require.Len(t, pcs, 2)
+	require.Equal(t, peers[2], pcs[0].Peer)
+	require.Equal(t, peers[1], pcs[1].Peer)
+	require.Len(t, c.Peers(1), 1)
+
+	// older coordinates don't overwrite newer ones
+	stale := NewCoordinate(cfg)
+	require.NoError(t, c.SetPeerCoordinate(peers[2], stale, now.Add(-2*time.Second)))
+	coord, ok := c.PeerCoordinate(peers[2])
+	require.True(t, ok)
+	require.Equal(t, 0.03, coord.Vec[0])
+
+	// copies are handed out
+	coord.Vec[0] = 1
+	coord, _ = c.PeerCoordinate(peers[2])
+	require.Equal(t, 0.03, coord.Vec[0])
+
+	rtt, ok := c.EstimateRTT(peers[2])
+	require.True(t, ok)
+	require.InDelta(t, 0.03+2*cfg.HeightMin, rtt.Seconds(), 1e-9)
+
+	// a coordinate from the future is dated now, so it is evicted and overwritten like any other
+	future := test.RandPeerIDFatal(t)
+	require.NoError(t, c.SetPeerCoordinate(future, NewCoordinate(cfg), now.Add(time.Hour)))
+	pcs = c.Peers(1)
+	require.Equal(t, future, pcs[0].Peer)
+	require.False(t, pcs[0].UpdatedAt.After(time.Now()))
+
+	fresh := NewCoordinate(cfg)
+	fresh.Vec[0] = 0.05
+	require.NoError(t, c.SetPeerCoordinate(future, fresh, time.Now()))
+	coord, _ = c.PeerCoordinate(future)
+	require.Equal(t, 0.05, coord.Vec[0])
+}
+
+// TestConvergence checks that nodes placed on a plane, whose RTTs are the distances between them,
+// find coordinates that predict the RTTs between nodes that never measured each other.
+func TestConvergence(t *testing.T) {
+	t.Parallel()
+
+	const n = 20
+	rng := rand.New(rand.NewSource(42))
+
+	type node struct {
+		id     peer.ID
+		x, y   float64
+		client *Client
+	}
+	nodes := make([]*node, n)
+	for i := range nodes {
+		c, err := NewClient(DefaultConfig())
+		require.NoError(t, err)
+		nodes[i] = &node{
+			id:     test.RandPeerIDFatal(t),
+			x:      rng.Float64() * 0.1,
+			y:      rng.Float64() * 0.1,
+			client: c,
+		}
+	}
+	rtt := func(a, b *node) time.Duration {
+		return secondsToDuration(math.Hypot(a.x-b.x, a.y-b.y) + 0.001)
+	}
+
+	// each node only measures its 5 following neighbours
+	for round := 0; round < 1000; round++ {
+		for i, a := range nodes {
+			b := nodes[(i+1+rng.Intn(5))%n]
+			_, err := a.client.Update(b.id, b.client.Coordinate(), rtt(a, b))
+			require.NoError(t, err)
+		}
+	}
+
+	var relErr float64
+	var count int
+	for i, a := range nodes {
+		for j, b := range nodes {
+			if i == j {
+				continue
+			}
+			want := rtt(a, b).Seconds()
+			got := a.client.Coordinate().distanceTo(b.client.Coordinate())
+			relErr += math.Abs(got-want) / want
+			count++
+		}
+	}
+	require.Less(t, relErr/float64(count), 0.25)
+	for _, a := range nodes {
+		require.Less(t, a.client.Coordinate().Error, DefaultConfig().ErrorMax)
+	}
+}
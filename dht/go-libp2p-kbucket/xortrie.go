@@ -0,0 +1,110 @@
+package kbucket
+
+// xorTrie is a binary trie of the peers in the routing table, keyed by their DHT ID.
+// A peer is stored in the shallowest node under which no other peer lives, so the
+// depth of the trie is O(log n) for n peers with random IDs.
+//
+// Walking the trie towards a target, taking the branch that agrees with the target
+// first, visits the peers by increasing XOR distance to it, which lets NearestPeers
+// stop as soon as it has enough peers.
+//
+// This mirrors go-libp2p-xor/trie, which can't be used here: its key package
+// imports this package (for KbucketIDToKey), so depending on it would be an
+// import cycle. It also stores bare keys, and we need the PeerInfo at each leaf.
+type xorTrie struct {
+	branch [2]*xorTrie
+	peer   *PeerInfo
+}
+
+func newXORTrie() *xorTrie {
+	return &xorTrie{}
+}
+
+// bitAt returns the bit of the ID at the given depth, most significant first.
+func bitAt(id ID, depth int) int {
+	return int(id[depth/8]>>(7-uint(depth%8))) & 1
+}
+
+func (t *xorTrie) isLeaf() bool {
+	return t.branch[0] == nil
+}
+
+// add inserts or replaces the peer and returns true if it wasn't in the trie.
+func (t *xorTrie) add(pi *PeerInfo) bool {
+	for depth := 0; ; depth++ {
+		if t.isLeaf() {
+			switch {
+			case t.peer == nil:
+				t.peer = pi
+				return true
+			case t.peer.dhtId.equal(pi.dhtId):
+				t.peer = pi
+				return false
+			}
+			// push the peer living here one level down and keep going
+			old := t.peer
+			t.peer = nil
+			t.branch[0], t.branch[1] = &xorTrie{}, &xorTrie{}
+			t.branch[bitAt(old.dhtId, depth)].peer = old
+		}
+		t = t.branch[bitAt(pi.dhtId, depth)]
+	}
+}
+
+// remove deletes the peer with the given ID and returns true if it was in the trie.
+func (t *xorTrie) remove(id ID) bool {
+	return t.removeAt(0, id)
+}
+
+func (t *xorTrie) removeAt(depth int, id ID) bool {
+	if t.isLeaf() {
+		if t.peer != nil && t.peer.dhtId.equal(id) {
+			t.peer = nil
+			return true
+		}
+		return false
+	}
+
+	if !t.branch[bitAt(id, depth)].removeAt(depth+1, id) {
+		return false
+	}
+	// fold the branches back if at most one peer is left under them
+	b0, b1 := t.branch[0], t.branch[1]
+	if b0.isLeaf() && b1.isLeaf() && (b0.peer == nil || b1.peer == nil) {
+		t.peer = b0.peer
+		if t.peer == nil {
+			t.peer = b1.peer
+		}
+		t.branch[0], t.branch[1] = nil, nil
+	}
+	return true
+}
+
+// size returns the number of peers in the trie.
+func (t *xorTrie) size() int {
+	if t.isLeaf() {
+		if t.peer == nil {
+			return 0
+		}
+		return 1
+	}
+	return t.branch[0].size() + t.branch[1].size()
+}
+
+// walkClosest calls fn on the peers by increasing XOR distance to the target until it returns false.
+// It returns false if the walk was stopped.
+func (t *xorTrie) walkClosest(target ID, fn func(*PeerInfo) bool) bool {
+	return t.walkClosestAt(0, target, fn)
+}
+
+func (t *xorTrie) walkClosestAt(depth int, target ID, fn func(*PeerInfo) bool) bool {
+	if t.isLeaf() {
+		if t.peer == nil {
+			return true
+		}
+		return fn(t.peer)
+	}
+	b := bitAt(target, depth)
+	return t.branch[b].walkClosestAt(depth+1, target, fn) &&
+		t.branch[1-b].walkClosestAt(depth+1, target, fn)
+}
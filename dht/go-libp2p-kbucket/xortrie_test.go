@@ -0,0 +1,139 @@
+package kbucket
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestXORTrie(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewSource(3))
+	trie := newXORTrie()
+	in := make(map[peer.ID]*PeerInfo)
+	var all []*PeerInfo
+	for i := 0; i < 200; i++ {
+		pi := randPeerInfo(t, true, 0)
+		all = append(all, &pi)
+	}
+
+	for step := 0; step < 1000; step++ {
+		pi := all[rng.Intn(len(all))]
+		if _, ok := in[pi.Id]; ok && rng.Intn(2) == 0 {
+			require.True(t, trie.remove(pi.dhtId))
+			require.False(t, trie.remove(pi.dhtId))
+			delete(in, pi.Id)
+		} else {
+			require.Equal(t, !ok, trie.add(pi))
+			in[pi.Id] = pi
+		}
+		require.Equal(t, len(in), trie.size())
+	}
+
+	ids := make([]peer.ID, 0, len(in))
+	for p := range in {
+		ids = append(ids, p)
+	}
+	for i := 0; i < 20; i++ {
+		target := ConvertPeerID(test.RandPeerIDFatal(t))
+		var walked []peer.ID
+		trie.walkClosest(target, func(pi *PeerInfo) bool {
+			walked = append(walked, pi.Id)
+			return true
+		})
+		require.Equal(t, SortClosestPeers(ids, target), walked)
+
+		// the walk stops when asked to
+		n := 0
+		require.False(t, trie.walkClosest(target, func(*PeerInfo) bool {
+			n++
+			return n < 5
+		}))
+		require.Equal(t, 5, n)
+	}
+
+	for _, pi := range in {
+		require.True(t, trie.remove(pi.dhtId))
+	}
+	require.Zero(t, trie.size())
+	require.True(t, trie.isLeaf())
+}
+
+func TestNearestPeersTrieIndex(t *testing.T) {
+	t.Parallel()
+
+	for _, kadRTT := range []bool{false, true} {
+		local := test.RandPeerIDFatal(t)
+		m := pstore.NewMetrics()
+		list, err := NewRoutingTable(10, ConvertPeerID(local), time.Hour, m, NoOpThreshold, nil, KadRTT(kadRTT))
+		require.NoError(t, err)
+		indexed, err := NewRoutingTable(10, ConvertPeerID(local), time.Hour, m, NoOpThreshold, nil, KadRTT(kadRTT), XORTrieIndex(true))
+		require.NoError(t, err)
+
+		var peers []peer.ID
+		for i := 0; i < 300; i++ {
+			p := test.RandPeerIDFatal(t)
+			peers = append(peers, p)
+			rtt := time.Duration(1+i%13) * time.Millisecond
+			list.TryAddPeerKadRTT(p, true, true, rtt)
+			indexed.TryAddPeerKadRTT(p, true, true, rtt)
+		}
+		for _, p := range peers[:50] {
+			list.RemovePeer(p)
+			indexed.RemovePeer(p)
+		}
+		require.Equal(t, list.Size(), indexed.Size())
+		require.Equal(t, indexed.Size(), indexed.trie.size())
+
+		for i := 0; i < 50; i++ {
+			target := ConvertPeerID(test.RandPeerIDFatal(t))
+			for _, count := range []int{0, 1, 7, 20, 1000} {
+				require.Equal(t, list.NearestPeers(target, count), indexed.NearestPeers(target, count))
+			}
+			// RTT annotations are the same, whichever way the peers were found
+			require.Equal(t, list.NearestPeersByPolicy(target, 10, RTTRanking{}), indexed.NearestPeersByPolicy(target, 10, RTTRanking{}))
+		}
+		for _, p := range peers {
+			require.Equal(t, list.Find(p), indexed.Find(p))
+		}
+	}
+}
+
+func BenchmarkNearestPeers(b *testing.B) {
+	for _, size := range []int{20, 100} {
+		for _, index := range []bool{false, true} {
+			name := fmt.Sprintf("bucketsize=%d/list", size)
+			if index {
+				name = fmt.Sprintf("bucketsize=%d/trie", size)
+			}
+			b.Run(name, func(b *testing.B) {
+				local := ConvertKey("localKey")
+				tab, err := NewRoutingTable(size, local, time.Hour, pstore.NewMetrics(), NoOpThreshold, nil, XORTrieIndex(index))
+				require.NoError(b, err)
+				for i := 0; i < 10000; i++ {
+					tab.TryAddPeer(test.RandPeerIDFatal(b), true, false)
+				}
+
+				targets := make([]ID, 1000)
+				for i := range targets {
+					targets[i] = ConvertPeerID(test.RandPeerIDFatal(b))
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					tab.NearestPeers(targets[i%len(targets)], 20)
+				}
+			})
+		}
+	}
+}
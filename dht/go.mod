@@ -17,7 +17,6 @@ require (
 	github.com/libp2p/go-libp2p-kbucket v0.4.7
 	github.com/libp2p/go-libp2p-swarm v0.5.3
 	github.com/libp2p/go-libp2p-transport-upgrader v0.4.6
-	github.com/libp2p/go-libp2p-xor v0.0.0-20200501025846-71e284145d58
 	github.com/libp2p/go-tcp-transport v0.2.7
 	github.com/multiformats/go-multiaddr v0.3.3
 	github.com/multiformats/go-multiaddr-net v0.2.0
@@ -66,6 +66,7 @@ type SetupOpts struct {
 	Latency     time.Duration
 	AutoRefresh bool
 	RandomWalk  bool
+	LookupTrace bool
 
 	BucketSize     int
 	Alpha          int
@@ -83,6 +84,16 @@ type SetupOpts struct {
 	ExpectedServer    bool
 	iskadrtt		  bool
 	kadrtt_interval int
+	kadrtt_rtt_provider string
+	kadrtt_coordinates bool
+	kadrtt_max_entries int
+	kadrtt_min_k int
+	kadrtt_max_k int
+	kadrtt_next_hop string
+	kadrtt_rtt_penalty time.Duration
+	kadrtt_peer_timeout_min time.Duration
+	kadrtt_peer_timeout_max time.Duration
+	kadrtt_hedging bool
 }
 
 type DHTRunInfo struct {
@@ -98,10 +109,12 @@ func GetCommonOpts(runenv *runtime.RunEnv) *SetupOpts {
 		Latency:     time.Duration(runenv.IntParam("latency")) * time.Millisecond,
 		AutoRefresh: runenv.BooleanParam("auto_refresh"),
 		RandomWalk:  runenv.BooleanParam("random_walk"),
+		LookupTrace: runenv.BooleanParam("lookup_trace"),
 
-		BucketSize: runenv.IntParam("bucket_size"),
-		Alpha:      runenv.IntParam("alpha"),
-		Beta:       runenv.IntParam("beta"),
+		BucketSize:     runenv.IntParam("bucket_size"),
+		Alpha:          runenv.IntParam("alpha"),
+		Beta:           runenv.IntParam("beta"),
+		NDisjointPaths: runenv.IntParam("n_paths"),
 
 		ClientMode: runenv.BooleanParam("client_mode"),
 		Datastore:  OptDatastore(runenv.IntParam("datastore")),
@@ -114,6 +127,16 @@ func GetCommonOpts(runenv *runtime.RunEnv) *SetupOpts {
 		ExpectedServer:    runenv.BooleanParam("expect_dht"),
 		iskadrtt:			runenv.BooleanParam("iskadrtt"),
 		kadrtt_interval:	runenv.IntParam("kadrtt_interval"),
+		kadrtt_rtt_provider:	runenv.StringParam("kadrtt_rtt_provider"),
+		kadrtt_coordinates:	runenv.BooleanParam("kadrtt_coordinates"),
+		kadrtt_max_entries:	runenv.IntParam("kadrtt_max_entries"),
+		kadrtt_min_k:	runenv.IntParam("kadrtt_min_k"),
+		kadrtt_max_k:	runenv.IntParam("kadrtt_max_k"),
+		kadrtt_next_hop:	runenv.StringParam("kadrtt_next_hop"),
+		kadrtt_rtt_penalty:	time.Duration(runenv.IntParam("kadrtt_rtt_penalty")) * time.Millisecond,
+		kadrtt_peer_timeout_min:	time.Duration(runenv.IntParam("kadrtt_peer_timeout_min")) * time.Millisecond,
+		kadrtt_peer_timeout_max:	time.Duration(runenv.IntParam("kadrtt_peer_timeout_max")) * time.Millisecond,
+		kadrtt_hedging:	runenv.BooleanParam("kadrtt_hedging"),
 	}
 	return opts
 }
@@ -913,7 +936,7 @@ func Teardown(ctx context.Context, ri *utils.RunInfo) {
 	}
 }
 
-var graphLogger, rtLogger, nodeLogger *zap.SugaredLogger
+var graphLogger, rtLogger, rtParamsLogger, nodeLogger *zap.SugaredLogger
 
 func initAssets(runenv *runtime.RunEnv) error {
 	var err error
@@ -931,6 +954,13 @@ func initAssets(runenv *runtime.RunEnv) error {
 		return err
 	}
 
+	_, rtParamsLogger, err = runenv.CreateStructuredAsset("dht_rt_params.out", runtime.StandardJSONConfig())
+	if err != nil {
+		runenv.RecordMessage("failed to initialize dht_rt_params.out asset; nooping logger: %s", err)
+		rtParamsLogger = zap.NewNop().Sugar()
+		return err
+	}
+
 	_, nodeLogger, err = runenv.CreateStructuredAsset("node.out", runtime.StandardJSONConfig())
 	if err != nil {
 		runenv.RecordMessage("failed to initialize node.out asset; nooping logger: %s", err)
@@ -951,6 +981,8 @@ func outputGraph(dht *kaddht.IpfsDHT, graphID string) {
 	for _, p := range dht.RoutingTable().ListPeers() {
 		rtLogger.Infow(graphID, "Node", dht.PeerID().Pretty(), "Peer", p.Pretty())
 	}
+
+	rtParamsLogger.Infow(graphID, "Node", dht.PeerID().Pretty(), "Params", dht.RoutingTable().Snapshot())
 }
 
 func outputStart(node *NodeParams) {
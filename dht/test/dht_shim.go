@@ -15,9 +15,6 @@ import (
 
 	kaddht "github.com/libp2p/go-libp2p-kad-dht"
 	kbucket "github.com/libp2p/go-libp2p-kbucket"
-	"github.com/libp2p/go-libp2p-xor/kademlia"
-	"github.com/libp2p/go-libp2p-xor/key"
-	"github.com/libp2p/go-libp2p-xor/trie"
 
 	"go.uber.org/zap"
 )
@@ -31,12 +28,33 @@ func createDHT(ctx context.Context, h host.Host, ds datastore.Batching, opts *Se
 		kaddht.Concurrency(opts.Alpha),
 		kaddht.Resiliency(opts.Beta),
 		kaddht.NamespacedValidator("ipns", ipns.Validator{KeyBook: h.Peerstore()}),
+		kaddht.LookupTracing(opts.LookupTrace),
+		kaddht.DisjointPaths(opts.NDisjointPaths),
 		//Added by Kanemitsu
 		kaddht.IsKadRTT(opts.iskadrtt),
 		kaddht.KadRTT_Interval(opts.kadrtt_interval),
 
 	}
 
+	if opts.iskadrtt {
+		rttProvider, err := kaddht.RTTProviderByName(opts.kadrtt_rtt_provider)
+		if err != nil {
+			return nil, err
+		}
+		nextHop, err := kaddht.NextHopScoringByName(opts.kadrtt_next_hop)
+		if err != nil {
+			return nil, err
+		}
+		dhtOptions = append(dhtOptions, kaddht.KadRTT_RTTProvider(rttProvider),
+			kaddht.KadRTT_NetworkCoordinates(opts.kadrtt_coordinates),
+			kaddht.KadRTT_BucketKRange(opts.kadrtt_min_k, opts.kadrtt_max_k),
+			kaddht.KadRTT_MaxEntries(opts.kadrtt_max_entries),
+			kaddht.KadRTT_NextHopScoring(nextHop),
+			kaddht.KadRTT_RTTPenalty(opts.kadrtt_rtt_penalty),
+			kaddht.KadRTT_PeerTimeouts(opts.kadrtt_peer_timeout_min, opts.kadrtt_peer_timeout_max),
+			kaddht.KadRTT_Hedging(opts.kadrtt_hedging))
+	}
+
 	if !opts.AutoRefresh {
 		dhtOptions = append(dhtOptions, kaddht.DisableAutoRefresh())
 	}
@@ -79,16 +97,16 @@ func specializedTraceQuery(ctx context.Context, runenv *runtime.RunEnv, tag stri
 		}
 		_, rtlogger, err = runenv.CreateStructuredAsset("rt_evts.out", runtime.StandardJSONConfig())
 		if err != nil {
-			runenv.RecordMessage("failed to initialize dht_lookups.out asset; nooping logger: %s", err)
+			runenv.RecordMessage("failed to initialize rt_evts.out asset; nooping logger: %s", err)
 			rtlogger = zap.NewNop().Sugar()
 		}
 	})
 
 	ectx, events := kaddht.RegisterForLookupEvents(ctx)
-	// ectx, rtEvts := kaddht.RegisterForRoutingTableEvents(ectx)
+	ectx, rtEvts := kaddht.RegisterForRoutingTableEvents(ectx)
 
 	lookupLogger := sqlogger.With("tag", tag)
-	// routingTableLogger := rtlogger.With("tag", tag)
+	routingTableLogger := rtlogger.With("tag", tag)
 
 	go func() {
 		for e := range events {
@@ -96,40 +114,27 @@ func specializedTraceQuery(ctx context.Context, runenv *runtime.RunEnv, tag stri
 		}
 	}()
 
-	// go func() {
-	// 	for e := range rtEvts {
-	// 		routingTableLogger.Infow("rt event", "info", e)
-	// 	}
-	// }()
+	go func() {
+		for e := range rtEvts {
+			routingTableLogger.Infow("rt event", "info", e)
+		}
+	}()
 
 	return ectx
 }
 
-// TableHealth computes health reports for a network of nodes, whose routing contacts are given.
-func TableHealth(dht *kaddht.IpfsDHT, peers map[peer.ID]*DHTNodeInfo, ri *DHTRunInfo) {
-	// Construct global network view trie
-	var kn []key.Key
-	knownNodes := trie.New()
+// TableHealth computes the health report of the routing table of the node, against the expected servers
+// among the given peers, and records it.
+func TableHealth(dht *kaddht.IpfsDHT, peers map[peer.ID]*DHTNodeInfo, ri *DHTRunInfo) kbucket.TableHealth {
+	var known []peer.ID
 	for p, info := range peers {
 		if info.Properties.ExpectedServer {
-			k := kadPeerID(p)
-			kn = append(kn, k)
-			knownNodes.Add(k)
+			known = append(known, p)
 		}
 	}
 
-	rtPeerIDs := dht.RoutingTable().ListPeers()
-	rtPeers := make([]key.Key, len(rtPeerIDs))
-	for i, p := range rtPeerIDs {
-		rtPeers[i] = kadPeerID(p)
-	}
-
-	report := kademlia.TableHealth(kadPeerID(dht.PeerID()), rtPeers, knownNodes)
+	report := dht.RoutingTable().Health(known)
 	ri.RunEnv.RecordMessage("table health: %s", report.String())
 
-	return
-}
-
-func kadPeerID(p peer.ID) key.Key {
-	return key.KbucketIDToKey(kbucket.ConvertPeerID(p))
+	return report
 }
@@ -79,17 +79,15 @@ func TestGetClosestPeers(ctx context.Context, ri *DHTRunInfo) error {
 				ectx, cancel := context.WithCancel(ctx)
 				ectx = TraceQuery(ectx, runenv, node, p.Pretty(), "get-closest-peers")
 				t := time.Now()
-				pids, err := node.dht.GetClosestPeers(ectx, c.KeyString())
+				// the trace of the lookup is published to dht_lookups.out along with its other events
+				peers, trace, err := node.dht.GetClosestPeersWithTrace(ectx, c.KeyString())
 				cancel()
 
-				peers := make([]peer.ID, 0, node.info.Properties.BucketSize)
-				for p := range pids {
-					peers = append(peers, p)
-				}
-
 				if err == nil {
 					runenv.R().RecordPoint(fmt.Sprintf("time-to-gcp-%d", i), float64(time.Since(t).Nanoseconds()))
-					runenv.R().RecordPoint(fmt.Sprintf("gcp-peers-found-%d", i), float64(len(pids)))
+					runenv.R().RecordPoint(fmt.Sprintf("gcp-peers-found-%d", i), float64(len(peers)))
+					runenv.R().RecordPoint(fmt.Sprintf("gcp-hops-%d", i), float64(trace.Depth()))
+					runenv.R().RecordPoint(fmt.Sprintf("gcp-queries-%d", i), float64(len(trace.Hops)))
 					actualClosest := getClosestPeerRanking(node, others, c)
 					outputGCP(runenv, node.info.Addrs.ID, c, peers, actualClosest)
 				} else {